@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setRoleCommand is the first argument that makes the server binary change
+// a user's role and exit, which is how the first admin is made
+const setRoleCommand = "set-role"
+
+// RoleRequest changes the role of a user
+type RoleRequest struct {
+	Role Role `json:"role" binding:"required"`
+}
+
+// getUsers lists every account with its role
+func getUsers(c *gin.Context) {
+	users, err := database.GetUsers()
+	if err != nil {
+		log.Printf("Error getting users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
+		return
+	}
+	recordAudit(c, "users.view", "", map[string]any{"users": len(users)})
+
+	c.JSON(http.StatusOK, users)
+}
+
+// updateUserRole changes the role of a user. Admins cannot drop their own
+// role, so that there is always an admin left to undo mistakes.
+func updateUserRole(c *gin.Context) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.Role.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown role %q", req.Role)})
+		return
+	}
+	userID := c.Param("user_id")
+	if userID == currentUserID(c) && req.Role != RoleAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Admins cannot remove their own admin role"})
+		return
+	}
+
+	user, err := database.GetUser(userID)
+	if errors.Is(err, errUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err == nil {
+		err = database.SetUserRole(userID, req.Role)
+	}
+	if err != nil {
+		log.Printf("Error setting role of user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set role"})
+		return
+	}
+	recordAudit(c, "user.role", userID, map[string]any{"from": user.Role, "to": req.Role})
+
+	user.Role = req.Role
+	c.JSON(http.StatusOK, user)
+}
+
+// getInstructorStudents lists the learners assigned to an instructor
+func getInstructorStudents(c *gin.Context) {
+	instructorID := c.Param("user_id")
+	students, err := database.GetStudents(instructorID)
+	if err != nil {
+		log.Printf("Error getting students: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get students"})
+		return
+	}
+	recordAudit(c, "students.view", instructorID, map[string]any{"students": len(students)})
+
+	c.JSON(http.StatusOK, students)
+}
+
+// addStudent assigns a learner to an instructor
+func addStudent(c *gin.Context) {
+	instructorID, studentID := c.Param("user_id"), c.Param("student_id")
+
+	instructor, err := database.GetUser(instructorID)
+	if err == nil {
+		_, err = database.GetUser(studentID)
+	}
+	if errors.Is(err, errUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign student"})
+		return
+	}
+	if !instructor.Role.Can(PermViewStudents) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User is not an instructor"})
+		return
+	}
+
+	if err := database.AddStudent(instructorID, studentID); err != nil {
+		log.Printf("Error assigning student %s to %s: %v", studentID, instructorID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign student"})
+		return
+	}
+	recordAudit(c, "student.add", studentID, map[string]any{"instructor_id": instructorID})
+
+	c.Status(http.StatusNoContent)
+}
+
+// removeStudent takes a learner away from an instructor
+func removeStudent(c *gin.Context) {
+	instructorID, studentID := c.Param("user_id"), c.Param("student_id")
+
+	removed, err := database.RemoveStudent(instructorID, studentID)
+	if err != nil {
+		log.Printf("Error removing student %s from %s: %v", studentID, instructorID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove student"})
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Student not found"})
+		return
+	}
+	recordAudit(c, "student.remove", studentID, map[string]any{"instructor_id": instructorID})
+
+	c.Status(http.StatusNoContent)
+}
+
+// runSetRole gives the user named in args[0] the role in args[1]
+func runSetRole(cfg DatabaseConfig, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s <username> <role>", setRoleCommand)
+	}
+	username, role := args[0], Role(args[1])
+	if !role.Valid() {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	var err error
+	database, err = NewDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	user, _, err := database.GetUserByUsername(username)
+	if err != nil {
+		return fmt.Errorf("failed to get user %s: %v", username, err)
+	}
+	if err := database.SetUserRole(user.ID, role); err != nil {
+		return fmt.Errorf("failed to set role: %v", err)
+	}
+	writeAudit(&AuditEntry{ActorID: "cli", Action: "user.role", Target: user.ID, Details: map[string]any{"from": user.Role, "to": role}})
+	return nil
+}
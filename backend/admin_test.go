@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAdminReadsAreAudited(t *testing.T) {
+	instructor, _ := newTestUser(t, RoleInstructor)
+	admin, adminToken := newTestUser(t, RoleAdmin)
+
+	tests := []struct {
+		path       string
+		wantAction string
+		wantTarget string
+	}{
+		{"/api/admin/users", "users.view", ""},
+		{"/api/admin/instructors/" + instructor.ID + "/students", "students.view", instructor.ID},
+		{"/api/admin/audit?actor_id=" + instructor.ID, "audit.view", instructor.ID},
+		{"/api/admin/lessons", "lessons.view_all", ""},
+		{"/api/admin/lessons/1/versions", "lesson.versions.view", "1"},
+		{"/api/admin/lessons/1/versions/1", "lesson.version.view", "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			recorder := serve(t, http.MethodGet, tt.path, nil, adminToken, nil)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+			}
+
+			entries, err := database.GetAuditLog(admin.ID, 0, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) == 0 || entries[0].Action != tt.wantAction || entries[0].Target != tt.wantTarget {
+				t.Errorf("last audit entry = %+v, want %s of %q", entries, tt.wantAction, tt.wantTarget)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditEntry records a privileged action, or a refused attempt at one
+type AuditEntry struct {
+	ID int64 `json:"id"`
+
+	// ActorID is the user who acted, or "cli" for server commands
+	ActorID string `json:"actor_id"`
+
+	// Action is a dotted name such as "user.role" or "access.denied"
+	Action string `json:"action"`
+
+	// Target is what was acted on, usually a user ID
+	Target  string         `json:"target,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+
+	ClientIP  string `json:"client_ip,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Page sizes of audit log listings
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 500
+)
+
+// recordAudit logs a privileged action of the request's user
+func recordAudit(c *gin.Context, action, target string, details map[string]any) {
+	writeAudit(&AuditEntry{
+		ActorID:  currentUserID(c),
+		Action:   action,
+		Target:   target,
+		Details:  details,
+		ClientIP: c.ClientIP(),
+	})
+}
+
+// writeAudit stores an audit entry. The action has already happened, so a
+// failure to store it is logged loudly instead of failing the request.
+func writeAudit(entry *AuditEntry) {
+	entry.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	log.Printf("🔐 Audit: %s %s %s %v", entry.ActorID, entry.Action, entry.Target, entry.Details)
+	if err := database.RecordAudit(entry); err != nil {
+		log.Printf("⚠️  Failed to write audit entry %s by %s: %v", entry.Action, entry.ActorID, err)
+	}
+}
+
+// getAuditLog lists audit entries newest first. The actor_id query parameter
+// narrows them to one user, and before and limit page through them.
+func getAuditLog(c *gin.Context) {
+	before, err := strconv.ParseInt(c.DefaultQuery("before", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid before"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultAuditLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	actorID := c.Query("actor_id")
+	entries, err := database.GetAuditLog(actorID, before, min(limit, maxAuditLimit))
+	if err != nil {
+		log.Printf("Error getting audit log: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get audit log"})
+		return
+	}
+	recordAudit(c, "audit.view", actorID, map[string]any{"before": before, "entries": len(entries)})
+
+	c.JSON(http.StatusOK, entries)
+}
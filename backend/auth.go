@@ -0,0 +1,480 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User is a learner's account
+type User struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Role      Role   `json:"role"`
+	CreatedAt string `json:"created_at"`
+}
+
+// CredentialsRequest registers or logs in a user. ProgressTokens are the
+// receipts of lessons passed anonymously, which are added to the account
+// when ProgressNonce is the nonce of the browser they were issued to.
+type CredentialsRequest struct {
+	Username       string   `json:"username" binding:"required"`
+	Password       string   `json:"password" binding:"required"`
+	ProgressTokens []string `json:"progress_tokens,omitempty"`
+	ProgressNonce  string   `json:"progress_nonce,omitempty"`
+}
+
+// AuthResponse carries the bearer token of a logged-in user
+type AuthResponse struct {
+	Token     string `json:"token"`
+	ExpiresAt string `json:"expires_at"`
+	User      *User  `json:"user"`
+
+	// Merged counts the lessons added from progress tokens
+	Merged int `json:"merged"`
+}
+
+// Password rules; bcrypt ignores everything past 72 bytes
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+// usernameRegex lists the characters allowed in usernames
+var usernameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,32}$`)
+
+var (
+	// errUsernameTaken is returned when registering a username that exists
+	errUsernameTaken = errors.New("username is already taken")
+
+	// errUserNotFound is returned when looking up an unknown user
+	errUserNotFound = errors.New("user not found")
+)
+
+// dummyPasswordHash is compared against when a login names an unknown user,
+// so that it takes as long as a wrong password
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// Global signer of the bearer and progress tokens
+var tokens *TokenSigner
+
+// Kinds of tokens
+const (
+	sessionToken  = "session"
+	progressToken = "progress"
+	ticketToken   = "ticket"
+)
+
+// ticketTTL is how long a WebSocket ticket can be used to connect
+const ticketTTL = 30 * time.Second
+
+// tokenClaims is the signed content of a token. Session tokens name their
+// user in Subject; progress tokens prove an anonymous pass of a lesson, and
+// carry an ID so that each is merged once and the hash of the nonce of the
+// browser they were issued to. Tickets name their user too, and carry an ID
+// so that each opens one WebSocket.
+type tokenClaims struct {
+	Kind        string `json:"kind"`
+	ID          string `json:"jti,omitempty"`
+	Subject     string `json:"sub,omitempty"`
+	Nonce       string `json:"nonce,omitempty"`
+	LessonID    int    `json:"lesson_id,omitempty"`
+	Version     int    `json:"lesson_version,omitempty"`
+	CompletedAt string `json:"completed_at,omitempty"`
+	IssuedAt    int64  `json:"iat"`
+	ExpiresAt   int64  `json:"exp,omitempty"`
+}
+
+// TokenSigner issues and verifies tokens of the form payload.signature,
+// both base64url encoded, where the signature is an HMAC-SHA256 of the
+// payload
+type TokenSigner struct {
+	secret      []byte
+	ttl         time.Duration
+	progressTTL time.Duration
+}
+
+// authSecretFile keeps the generated signing key when AUTH_SECRET is unset
+var authSecretFile = filepath.Join("data", "auth_secret")
+
+// NewTokenSigner creates the signer with the configured secret, or with the
+// key in the data directory, which is generated on the first start
+func NewTokenSigner(cfg AuthConfig) (*TokenSigner, error) {
+	signer := &TokenSigner{
+		secret:      []byte(cfg.Secret),
+		ttl:         time.Duration(cfg.TokenTTLHours) * time.Hour,
+		progressTTL: time.Duration(cfg.ProgressTokenTTLHours) * time.Hour,
+	}
+	if cfg.Secret != "" {
+		return signer, nil
+	}
+
+	if key, err := os.ReadFile(authSecretFile); err == nil {
+		signer.secret = []byte(strings.TrimSpace(string(key)))
+		return signer, nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %v", authSecretFile, err)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %v", err)
+	}
+	signer.secret = []byte(hex.EncodeToString(key))
+	if err := os.WriteFile(authSecretFile, signer.secret, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %v", authSecretFile, err)
+	}
+	log.Printf("⚠️  AUTH_SECRET is not set, signing tokens with the key generated in %s", authSecretFile)
+	return signer, nil
+}
+
+// Sign issues a token for claims
+func (s *TokenSigner) Sign(claims tokenClaims) string {
+	payload, _ := json.Marshal(claims)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac(encoded))
+}
+
+// Verify checks the signature, kind and expiry of a token
+func (s *TokenSigner) Verify(token, kind string) (*tokenClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errors.New("malformed token")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.mac(encoded)) {
+		return nil, errors.New("invalid token signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed token")
+	}
+	if claims.Kind != kind {
+		return nil, fmt.Errorf("not a %s token", kind)
+	}
+	if claims.ExpiresAt != 0 && time.Now().Unix() >= claims.ExpiresAt {
+		return nil, errors.New("token expired")
+	}
+	return &claims, nil
+}
+
+// mac signs the encoded payload of a token
+func (s *TokenSigner) mac(encoded string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(encoded))
+	return h.Sum(nil)
+}
+
+// session issues the bearer token of a logged-in user
+func (s *TokenSigner) session(user *User) (string, time.Time) {
+	now := time.Now()
+	expires := now.Add(s.ttl)
+	return s.Sign(tokenClaims{Kind: sessionToken, Subject: user.ID, IssuedAt: now.Unix(), ExpiresAt: expires.Unix()}), expires
+}
+
+// progress issues the token of an anonymous pass of a lesson to the browser
+// holding nonce
+func (s *TokenSigner) progress(lessonID, version int, completedAt, nonce string) string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	now := time.Now()
+	return s.Sign(tokenClaims{
+		Kind:        progressToken,
+		ID:          hex.EncodeToString(id),
+		Nonce:       hashNonce(nonce),
+		LessonID:    lessonID,
+		Version:     version,
+		CompletedAt: completedAt,
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   now.Add(s.progressTTL).Unix(),
+	})
+}
+
+// ticket issues the short-lived token a user opens a WebSocket with.
+// Browsers can only send it in the URL, which ends up in access logs and
+// browser history, where a session token would stay usable for days.
+func (s *TokenSigner) ticket(userID string) (string, time.Time) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	now := time.Now()
+	expires := now.Add(ticketTTL)
+	return s.Sign(tokenClaims{
+		Kind:      ticketToken,
+		ID:        hex.EncodeToString(id),
+		Subject:   userID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expires.Unix(),
+	}), expires
+}
+
+// usedTickets remembers the tickets that opened a WebSocket until they
+// expire, by ID
+var usedTickets = struct {
+	sync.Mutex
+	expires map[string]int64
+}{expires: make(map[string]int64)}
+
+// claimTicket reports whether a ticket is used for the first time
+func claimTicket(claims *tokenClaims) bool {
+	usedTickets.Lock()
+	defer usedTickets.Unlock()
+	now := time.Now().Unix()
+	for id, expires := range usedTickets.expires {
+		if expires <= now {
+			delete(usedTickets.expires, id)
+		}
+	}
+	if _, used := usedTickets.expires[claims.ID]; used {
+		return false
+	}
+	usedTickets.expires[claims.ID] = claims.ExpiresAt
+	return true
+}
+
+// hashNonce is what progress tokens keep of a browser's nonce, so that a
+// token alone does not reveal the nonce needed to merge it
+func hashNonce(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticate identifies the user of a request by the bearer token in its
+// Authorization header. Browsers cannot set headers on WebSocket requests,
+// which send a ticket from POST /api/ws/ticket in the ticket query parameter
+// instead. Requests without a token are anonymous; a bad token is rejected.
+func authenticate(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok && c.FullPath() == "/api/ws" && c.Query("ticket") != "" {
+		claims, err := tokens.Verify(c.Query("ticket"), ticketToken)
+		if err != nil || !claimTicket(claims) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or used ticket"})
+			return
+		}
+		c.Set("user_id", claims.Subject)
+		c.Next()
+		return
+	}
+	if token == "" {
+		c.Next()
+		return
+	}
+
+	claims, err := tokens.Verify(token, sessionToken)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+	c.Set("user_id", claims.Subject)
+	c.Next()
+}
+
+// issueTicket hands the logged-in user a ticket to open a WebSocket with
+func issueTicket(c *gin.Context) {
+	ticket, expires := tokens.ticket(currentUserID(c))
+	c.JSON(http.StatusOK, gin.H{"ticket": ticket, "expires_at": expires.UTC().Format(time.RFC3339)})
+}
+
+// requireUser rejects anonymous requests
+func requireUser(c *gin.Context) {
+	if currentUserID(c) == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Login required"})
+		return
+	}
+	c.Next()
+}
+
+// currentUserID returns the ID of the logged-in user, or "" for anonymous
+// requests
+func currentUserID(c *gin.Context) string {
+	return c.GetString("user_id")
+}
+
+// register creates an account and logs it in
+func register(c *gin.Context) {
+	var req CredentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !usernameRegex.MatchString(req.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Usernames have 3 to 32 letters, digits, '_', '.' or '-'"})
+		return
+	}
+	if len(req.Password) < minPasswordLength || len(req.Password) > maxPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Passwords have %d to %d bytes", minPasswordLength, maxPasswordLength)})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+	user, err := database.CreateUser(newUserID(), req.Username, string(hash))
+	if errors.Is(err, errUsernameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username is already taken"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating user %s: %v", req.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+	log.Printf("User %s registered", user.Username)
+
+	respondLoggedIn(c, http.StatusCreated, user, req.ProgressNonce, req.ProgressTokens)
+}
+
+// login checks a user's password and issues a token
+func login(c *gin.Context) {
+	var req CredentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, hash, err := database.GetUserByUsername(req.Username)
+	if err != nil && !errors.Is(err, errUserNotFound) {
+		log.Printf("Error getting user %s: %v", req.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		return
+	}
+	// Accounts of single sign-on users have no password
+	known := user != nil && hash != ""
+	if !known {
+		hash = string(dummyPasswordHash)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil || !known {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Wrong username or password"})
+		return
+	}
+
+	respondLoggedIn(c, http.StatusOK, user, req.ProgressNonce, req.ProgressTokens)
+}
+
+// respondLoggedIn merges the anonymous progress into the user's and sends
+// the user's token
+func respondLoggedIn(c *gin.Context, status int, user *User, progressNonce string, progressTokens []string) {
+	merged, err := mergeProgress(user.ID, progressNonce, progressTokens)
+	if err != nil {
+		log.Printf("Error merging progress of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge progress"})
+		return
+	}
+
+	token, expires := tokens.session(user)
+	c.JSON(status, AuthResponse{Token: token, ExpiresAt: expires.UTC().Format(time.RFC3339), User: user, Merged: merged})
+}
+
+// getCurrentUser returns the logged-in user
+func getCurrentUser(c *gin.Context) {
+	user, err := database.GetUser(currentUserID(c))
+	if errors.Is(err, errUserNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User no longer exists"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// mergeProgressTokens adds the lessons passed anonymously to the logged-in
+// user's progress
+func mergeProgressTokens(c *gin.Context) {
+	var req struct {
+		ProgressTokens []string `json:"progress_tokens"`
+		ProgressNonce  string   `json:"progress_nonce"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	merged, err := mergeProgress(currentUserID(c), req.ProgressNonce, req.ProgressTokens)
+	if err != nil {
+		log.Printf("Error merging progress: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge progress"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"merged": merged})
+}
+
+// mergeProgress records the lessons of valid progress tokens as completed
+// by a user, keeping lessons the user completed before as they are. Tokens
+// that are invalid, expired, issued to another browser than the one holding
+// nonce or merged before are skipped; it returns how many lessons were added.
+func mergeProgress(userID, nonce string, progressTokens []string) (int, error) {
+	if len(progressTokens) == 0 || nonce == "" {
+		return 0, nil
+	}
+	progress, err := database.GetUserProgress(userID)
+	if err != nil {
+		return 0, err
+	}
+	completed := make(map[int]bool)
+	for _, p := range progress {
+		completed[p.LessonID] = p.Completed
+	}
+
+	merged := 0
+	for _, token := range progressTokens {
+		claims, err := tokens.Verify(token, progressToken)
+		if err != nil || claims.ID == "" || !hmac.Equal([]byte(claims.Nonce), []byte(hashNonce(nonce))) || completed[claims.LessonID] {
+			continue
+		}
+		claimed, err := database.ClaimProgressToken(claims.ID, userID, claims.ExpiresAt)
+		if err != nil {
+			return merged, err
+		}
+		if !claimed {
+			continue
+		}
+		completedAt := claims.CompletedAt
+		err = database.UpdateUserProgress(UserProgress{
+			UserID:        userID,
+			LessonID:      claims.LessonID,
+			Completed:     true,
+			CompletedAt:   &completedAt,
+			LessonVersion: claims.Version,
+		})
+		if err != nil {
+			return merged, err
+		}
+		completed[claims.LessonID] = true
+		merged++
+	}
+	return merged, nil
+}
+
+// newUserID generates a random user ID
+func newUserID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return "u_" + hex.EncodeToString(id)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTokenSigner(t *testing.T) {
+	signer, err := NewTokenSigner(AuthConfig{Secret: "secret", TokenTTLHours: 1, ProgressTokenTTLHours: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewTokenSigner(AuthConfig{Secret: "other secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _ := signer.session(&User{ID: "u_1"})
+	payload, signature, _ := strings.Cut(session, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"kind":"session","sub":"u_2","iat":1}`))
+
+	tests := []struct {
+		name    string
+		token   string
+		kind    string
+		wantSub string
+		wantErr bool
+	}{
+		{"valid session", session, sessionToken, "u_1", false},
+		{"wrong kind", session, progressToken, "", true},
+		{"tampered payload", forged + "." + signature, sessionToken, "", true},
+		{"tampered signature", payload + "." + base64.RawURLEncoding.EncodeToString([]byte("signature")), sessionToken, "", true},
+		{"malformed", "not a token", sessionToken, "", true},
+		{"signed payload that is not JSON", payload[:4] + "." + base64.RawURLEncoding.EncodeToString(signer.mac(payload[:4])), sessionToken, "", true},
+		{"expired", signer.Sign(tokenClaims{Kind: sessionToken, Subject: "u_1", IssuedAt: 1, ExpiresAt: 2}), sessionToken, "", true},
+		{"signed with another secret", other.Sign(tokenClaims{Kind: sessionToken, Subject: "u_1", IssuedAt: 1}), sessionToken, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := signer.Verify(tt.token, tt.kind)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, want one: %v", err, tt.wantErr)
+			}
+			if err == nil && claims.Subject != tt.wantSub {
+				t.Errorf("subject %q, want %q", claims.Subject, tt.wantSub)
+			}
+		})
+	}
+}
+
+func TestMergeProgress(t *testing.T) {
+	const nonce = "browser nonce"
+	completedAt := time.Now().UTC().Format(time.RFC3339)
+	replayed := tokens.progress(1, 1, completedAt, nonce)
+	_, firstToken := newTestUser(t, RoleLearner)
+	var first gin.H
+	serve(t, http.MethodPost, "/api/progress/merge", gin.H{"progress_tokens": []string{replayed}, "progress_nonce": nonce}, firstToken, &first)
+	if first["merged"] != float64(1) {
+		t.Fatalf("first merge = %v, want 1 lesson merged", first)
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		nonce      string
+		wantMerged int
+	}{
+		{"valid token", tokens.progress(1, 1, completedAt, nonce), nonce, 1},
+		{"token merged before", replayed, nonce, 0},
+		{"nonce of another browser", tokens.progress(1, 1, completedAt, nonce), "other browser", 0},
+		{"missing nonce", tokens.progress(1, 1, completedAt, nonce), "", 0},
+		{
+			name:  "expired token",
+			token: tokens.Sign(tokenClaims{Kind: progressToken, ID: "expired", Nonce: hashNonce(nonce), LessonID: 1, Version: 1, IssuedAt: 1, ExpiresAt: 2}),
+			nonce: nonce,
+		},
+		{
+			name:  "token without an ID",
+			token: tokens.Sign(tokenClaims{Kind: progressToken, Nonce: hashNonce(nonce), LessonID: 1, Version: 1, IssuedAt: time.Now().Unix()}),
+			nonce: nonce,
+		},
+		{"session token", firstToken, nonce, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, token := newTestUser(t, RoleLearner)
+			var response gin.H
+			body := gin.H{"progress_tokens": []string{tt.token}, "progress_nonce": tt.nonce}
+			if recorder := serve(t, http.MethodPost, "/api/progress/merge", body, token, &response); recorder.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", recorder.Code, recorder.Body)
+			}
+			if response["merged"] != float64(tt.wantMerged) {
+				t.Errorf("merged %v, want %d", response["merged"], tt.wantMerged)
+			}
+
+			var progress []UserProgress
+			serve(t, http.MethodGet, "/api/progress", nil, token, &progress)
+			if len(progress) != tt.wantMerged {
+				t.Errorf("progress = %+v, want %d lessons", progress, tt.wantMerged)
+			}
+		})
+	}
+}
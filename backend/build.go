@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// compileTimeout bounds the go build phase of an execution
+const compileTimeout = 15 * time.Second
+
+// Diagnostic is a compiler error positioned in the learner's source
+type Diagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// diagnosticPattern matches compiler lines such as "./main.go:5:2: undefined: x"
+var diagnosticPattern = regexp.MustCompile(`^(?:\./)?([^\s:]+\.go):(\d+)(?::(\d+))?: (.+)$`)
+
+// parseDiagnostics extracts positioned errors from go build output. Indented
+// lines continue the message of the error before them.
+func parseDiagnostics(output string) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	for _, line := range strings.Split(output, "\n") {
+		if match := diagnosticPattern.FindStringSubmatch(line); match != nil {
+			d := Diagnostic{File: match[1], Message: match[4]}
+			d.Line, _ = strconv.Atoi(match[2])
+			d.Column, _ = strconv.Atoi(match[3])
+			diagnostics = append(diagnostics, d)
+			continue
+		}
+		if strings.HasPrefix(line, "\t") && len(diagnostics) > 0 {
+			last := &diagnostics[len(diagnostics)-1]
+			last.Message += "\n" + strings.TrimSpace(line)
+		}
+	}
+	return diagnostics
+}
+
+// Workspace limits keep a single request from filling the disk
+const (
+	maxWorkspaceFiles = 64
+	maxWorkspaceBytes = 1 << 20
+)
+
+// maxRequestBytes caps the body of a request or WebSocket message that
+// carries a workspace, leaving room for JSON escaping and the other fields,
+// so that oversized requests are cut off before they are held in memory
+const maxRequestBytes = maxWorkspaceBytes + 256<<10
+
+// defaultGoMod returns the go.mod used when a request does not bring its
+// own. It declares the language version of the release the request is built
+// with, so that programs get that release's semantics, such as the per
+// iteration loop variables of Go 1.22.
+func defaultGoMod(goVersion string) string {
+	language := "1.21"
+	if goVersion != "" {
+		language = Toolchain{Version: goVersion}.languageVersion()
+	}
+	return "module sandbox\n\ngo " + language + "\n"
+}
+
+// workspaceFiles returns every file of the module to build, keyed by its
+// slash-separated path relative to the module root. Code becomes main.go, a
+// default go.mod is added when the request has none and the hidden tests
+// become main_test.go.
+func (r *CodeExecutionRequest) workspaceFiles() (map[string]string, error) {
+	if r.Code == "" && len(r.Files) == 0 {
+		return nil, errors.New("code or files are required")
+	}
+	if len(r.Files) > maxWorkspaceFiles {
+		return nil, fmt.Errorf("too many files: at most %d are allowed", maxWorkspaceFiles)
+	}
+
+	files := make(map[string]string, len(r.Files)+3)
+	size := 0
+	for name, content := range r.Files {
+		if err := checkWorkspacePath(name); err != nil {
+			return nil, err
+		}
+		// Test files would run alongside the hidden tests and could report
+		// them as passed
+		if strings.HasSuffix(name, "_test.go") {
+			return nil, fmt.Errorf("test file %q is not allowed", name)
+		}
+		files[name] = content
+		size += len(content)
+	}
+	if size+len(r.Code) > maxWorkspaceBytes {
+		return nil, fmt.Errorf("files are too large: at most %d bytes are allowed", maxWorkspaceBytes)
+	}
+
+	if r.Code != "" {
+		if _, ok := files["main.go"]; ok {
+			return nil, errors.New("main.go is given both as code and in files")
+		}
+		files["main.go"] = r.Code
+	}
+	if _, ok := files["go.mod"]; !ok {
+		files["go.mod"] = defaultGoMod(r.GoVersion)
+	}
+	if r.TestCode != "" {
+		files["main_test.go"] = r.TestCode
+	}
+	return files, nil
+}
+
+// checkWorkspacePath rejects paths that could escape the module directory
+func checkWorkspacePath(name string) error {
+	switch {
+	case name == "" || strings.Contains(name, "\\"):
+		return fmt.Errorf("invalid file path %q", name)
+	case path.IsAbs(name) || path.Clean(name) != name:
+		return fmt.Errorf("file path %q must be relative and clean", name)
+	case name == ".." || strings.HasPrefix(name, "../"):
+		return fmt.Errorf("file path %q is outside the module", name)
+	}
+	return nil
+}
+
+// writeWorkspace lays out the files of a module in dir
+func writeWorkspace(dir string, files map[string]string) error {
+	for name, content := range files {
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %v", name, err)
+		}
+		if err := os.WriteFile(target, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// buildResult describes the compile phase of an execution
+type buildResult struct {
+	output   string
+	duration time.Duration
+	err      error
+	timedOut bool
+	cached   bool
+}
+
+// hostBuilder compiles workspaces with the host toolchain for the local and
+// sandbox backends
+type hostBuilder struct {
+	modules         ModulesConfig
+	cache           *BuildCache
+	toolchainConfig ToolchainConfig
+
+	toolchainsMu sync.Mutex
+	toolchains   *Toolchains
+}
+
+// toolchainDiscoveryTimeout bounds looking up the host's Go releases, which
+// runs go env once for each of them
+const toolchainDiscoveryTimeout = 30 * time.Second
+
+// Toolchains implements Executor. The releases are looked up on first use
+// and kept once found; a failed lookup is retried by the next caller. The
+// lookup does not use the caller's context, so that one cancelled request
+// cannot fail it for the others waiting on it.
+func (b *hostBuilder) Toolchains(ctx context.Context) (*Toolchains, error) {
+	b.toolchainsMu.Lock()
+	defer b.toolchainsMu.Unlock()
+
+	if b.toolchains != nil {
+		return b.toolchains, nil
+	}
+
+	discoverCtx, cancel := context.WithTimeout(context.Background(), toolchainDiscoveryTimeout)
+	defer cancel()
+	toolchains, err := discoverHostToolchains(discoverCtx, b.toolchainConfig)
+	if err != nil {
+		log.Printf("⚠️  Cannot list Go toolchains: %v", err)
+		return nil, err
+	}
+	b.toolchains = toolchains
+	return toolchains, nil
+}
+
+// toolchain selects the release a request is built with
+func (b *hostBuilder) toolchain(ctx context.Context, req *CodeExecutionRequest) (Toolchain, error) {
+	toolchains, err := b.Toolchains(ctx)
+	if err != nil {
+		return Toolchain{}, err
+	}
+	return toolchains.Select(req.GoVersion)
+}
+
+// checkToolchains reports whether every resolved release still runs, which
+// is what the host backends need to build
+func (b *hostBuilder) checkToolchains(ctx context.Context) error {
+	toolchains, err := b.Toolchains(ctx)
+	if err != nil {
+		return fmt.Errorf("go toolchain unavailable: %v", err)
+	}
+	for _, version := range toolchains.Versions() {
+		toolchain, _ := toolchains.Select(version)
+		cmd := exec.CommandContext(ctx, toolchain.goCommand(), "version")
+		cmd.Env = append(os.Environ(), "GOROOT="+toolchain.GoRoot, "GOTOOLCHAIN=local")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("go toolchain %s unavailable: %v %s", version, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// build produces the binary for files, which are already laid out in srcDir,
+// at out. An unchanged workspace is served from the binary cache.
+func (b *hostBuilder) build(ctx context.Context, toolchain Toolchain, files map[string]string, srcDir, out string, tests bool) *buildResult {
+	key := b.cache.Key(b.cacheSalt(toolchain), files, tests)
+	if b.cache.Load(key, out) {
+		return &buildResult{cached: true}
+	}
+
+	build := buildProgram(ctx, toolchain, srcDir, out, tests, b.modules, b.cache.GoCacheDir())
+	if build.err == nil {
+		if err := b.cache.Store(key, out); err != nil {
+			log.Printf("⚠️  %v", err)
+		}
+	}
+	return build
+}
+
+// Vet implements Executor by running gofmt -d and go vet with the host
+// toolchain. Like a build, it runs none of the learner's code.
+func (b *hostBuilder) Vet(ctx context.Context, req *CodeExecutionRequest) (*CheckResponse, error) {
+	toolchain, err := b.toolchain(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	files, err := req.withToolchain(toolchain).workspaceFiles()
+	if err != nil {
+		return nil, err
+	}
+	if rejected := b.modules.checkImports(files); rejected != nil {
+		return rejectedCheck(rejected), nil
+	}
+
+	dir, err := os.MkdirTemp("", "go_vet_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := writeWorkspace(dir, files); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, compileTimeout)
+	defer cancel()
+
+	// gofmt exits with an error for files that do not parse, which go vet
+	// reports as well
+	gofmt := exec.CommandContext(ctx, toolchain.gofmtCommand(), append([]string{"-d"}, goFileNames(files)...)...)
+	gofmt.Dir = dir
+	formatDiff, err := gofmt.Output()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("failed to run gofmt: %v", err)
+	}
+
+	vet := exec.CommandContext(ctx, toolchain.goCommand(), "vet", "-json", "./...")
+	vet.Dir = dir
+	vet.Env = toolchainEnv(toolchain, b.modules, b.cache.GoCacheDir())
+	vetOutput, err := vet.CombinedOutput()
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("failed to run go vet: %v", err)
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return &CheckResponse{Findings: []Finding{}, Error: "Check timeout exceeded", GoVersion: toolchain.Version}, nil
+	}
+	output := strings.ReplaceAll(string(vetOutput), dir+string(filepath.Separator), "")
+	response := newCheckResponse(files, string(formatDiff), output, err != nil)
+	response.GoVersion = toolchain.Version
+	return response, nil
+}
+
+// goFileNames returns the sorted paths of the Go files of a workspace
+func goFileNames(files map[string]string) []string {
+	var names []string
+	for name := range files {
+		if strings.HasSuffix(name, ".go") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// cacheSalt identifies everything besides the workspace that goes into a
+// binary
+func (b *hostBuilder) cacheSalt(toolchain Toolchain) string {
+	return strings.Join([]string{toolchain.Version, toolchain.GoRoot, runtime.GOOS + "/" + runtime.GOARCH, "CGO_ENABLED=0", "-trimpath", strings.Join(b.modules.Allowed, ",")}, "\x00")
+}
+
+// buildProgram compiles the module in srcDir with the given release into a
+// static binary at out, or into a test binary when tests is set
+func buildProgram(ctx context.Context, toolchain Toolchain, srcDir, out string, tests bool, modules ModulesConfig, goCache string) *buildResult {
+	ctx, cancel := context.WithTimeout(ctx, compileTimeout)
+	defer cancel()
+
+	// -trimpath prints the learner's files in stack traces as paths within
+	// the module, which parsePanic relies on
+	args := []string{"build", "-trimpath", "-o", out, "."}
+	if tests {
+		args = []string{"test", "-c", "-trimpath", "-o", out, "."}
+	}
+
+	cmd := exec.CommandContext(ctx, toolchain.goCommand(), args...)
+	cmd.Dir = srcDir
+	cmd.Env = toolchainEnv(toolchain, modules, goCache)
+
+	start := time.Now()
+	output, err := cmd.CombinedOutput()
+
+	return &buildResult{
+		output:   string(output),
+		duration: time.Since(start),
+		err:      err,
+		timedOut: ctx.Err() == context.DeadlineExceeded,
+	}
+}
+
+// toolchainEnv returns the environment for the go command of a release.
+// Modules are resolved from the offline module cache only, and goCache, when
+// set, is used as GOCACHE.
+func toolchainEnv(toolchain Toolchain, modules ModulesConfig, goCache string) []string {
+	// A static binary also runs in an empty sandbox root without a libc
+	env := append(os.Environ(), "CGO_ENABLED=0", "GOROOT="+toolchain.GoRoot)
+	env = append(env, modules.buildEnv()...)
+	if goCache != "" {
+		env = append(env, "GOCACHE="+goCache)
+	}
+	return env
+}
+
+// compileFailure turns a failed build into a response
+func compileFailure(build *buildResult) *CodeExecutionResponse {
+	response := &CodeExecutionResponse{
+		CompileOutput: build.output,
+		Diagnostics:   parseDiagnostics(build.output),
+		CompileTimeMs: build.duration.Milliseconds(),
+		Error:         "Compilation failed",
+	}
+	if build.timedOut {
+		response.Error = "Compilation timeout exceeded"
+	}
+	return response
+}
+
+// setRunResult records the duration, exit code and error of the run phase.
+// The exit code stays unset when the program did not exit normally.
+func (r *CodeExecutionResponse) setRunResult(ctx context.Context, err error, duration time.Duration) {
+	r.RunTimeMs = duration.Milliseconds()
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		code := 0
+		r.ExitCode = &code
+	case errors.As(err, &exitErr) && exitErr.ExitCode() >= 0:
+		code := exitErr.ExitCode()
+		r.ExitCode = &code
+	}
+
+	if err != nil {
+		r.Error = executionError(ctx, err)
+	}
+}
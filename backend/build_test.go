@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []Diagnostic
+	}{
+		{
+			name:   "no output",
+			output: "",
+			want:   []Diagnostic{},
+		},
+		{
+			name:   "error with column",
+			output: "# sandbox\n./main.go:5:2: undefined: x\n",
+			want:   []Diagnostic{{File: "main.go", Line: 5, Column: 2, Message: "undefined: x"}},
+		},
+		{
+			name:   "error without column",
+			output: "main.go:3: syntax error: unexpected newline",
+			want:   []Diagnostic{{File: "main.go", Line: 3, Message: "syntax error: unexpected newline"}},
+		},
+		{
+			name:   "file in a package directory",
+			output: "greet/greet.go:7:9: cannot use 1 (untyped int constant) as string value in return statement",
+			want: []Diagnostic{{
+				File:    "greet/greet.go",
+				Line:    7,
+				Column:  9,
+				Message: "cannot use 1 (untyped int constant) as string value in return statement",
+			}},
+		},
+		{
+			name: "indented lines continue the message",
+			output: "./main.go:8:14: cannot use s (variable of type string) as int value in argument to f:\n" +
+				"\thave string\n" +
+				"\twant int\n" +
+				"./main.go:9:2: declared and not used: y\n",
+			want: []Diagnostic{
+				{File: "main.go", Line: 8, Column: 14, Message: "cannot use s (variable of type string) as int value in argument to f:\nhave string\nwant int"},
+				{File: "main.go", Line: 9, Column: 2, Message: "declared and not used: y"},
+			},
+		},
+		{
+			name:   "indented line before any error is ignored",
+			output: "\tnote: module requires Go 1.22\n",
+			want:   []Diagnostic{},
+		},
+		{
+			name:   "lines that are not errors are ignored",
+			output: "go: downloading example.com/m v1.0.0\nmain.go is fine\n",
+			want:   []Diagnostic{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseDiagnostics(tt.output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDiagnostics() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkspaceFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     CodeExecutionRequest
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "code becomes main.go with a default go.mod",
+			req:  CodeExecutionRequest{Code: "package main"},
+			want: []string{"go.mod", "main.go"},
+		},
+		{
+			name: "hidden tests become main_test.go",
+			req:  CodeExecutionRequest{Code: "package main", TestCode: "package main"},
+			want: []string{"go.mod", "main.go", "main_test.go"},
+		},
+		{
+			name: "files of other packages",
+			req:  CodeExecutionRequest{Files: map[string]string{"main.go": "package main", "greet/greet.go": "package greet"}},
+			want: []string{"go.mod", "greet/greet.go", "main.go"},
+		},
+		{
+			name:    "learner test file",
+			req:     CodeExecutionRequest{Code: "package main", Files: map[string]string{"x_test.go": "package main"}},
+			wantErr: true,
+		},
+		{
+			name:    "learner test file in a package",
+			req:     CodeExecutionRequest{Code: "package main", Files: map[string]string{"greet/greet_test.go": "package greet"}},
+			wantErr: true,
+		},
+		{
+			name:    "path outside the module",
+			req:     CodeExecutionRequest{Files: map[string]string{"../main.go": "package main"}},
+			wantErr: true,
+		},
+		{
+			name:    "nothing to build",
+			req:     CodeExecutionRequest{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := tt.req.workspaceFiles()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("workspaceFiles() error = %v, want one: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var names []string
+			for name := range files {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("files = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckToolchains(t *testing.T) {
+	goRoot, err := exec.Command("go", "env", "GOROOT").Output()
+	if err != nil {
+		t.Skipf("go is not installed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		goRoots []string
+		wantErr bool
+	}{
+		{"go on the PATH", nil, false},
+		{"configured GOROOT", []string{strings.TrimSpace(string(goRoot))}, false},
+		{"missing GOROOT", []string{t.TempDir()}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := &hostBuilder{toolchainConfig: ToolchainConfig{GoRoots: tt.goRoots}}
+			if err := builder.checkToolchains(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("checkToolchains() error = %v, want one: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BuildCache keeps compiled binaries keyed by a hash of everything that went
+// into them, and owns the GOCACHE shared by all builds. A nil *BuildCache
+// disables caching.
+type BuildCache struct {
+	dir      string
+	maxBytes int64
+
+	// mu serializes stores and evictions
+	mu sync.Mutex
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// BuildCacheStats is reported by /api/metrics
+type BuildCacheStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// NewBuildCache creates the cache directories
+func NewBuildCache(cfg BuildCacheConfig) (*BuildCache, error) {
+	dir, err := filepath.Abs(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid build cache dir: %v", err)
+	}
+	for _, sub := range []string{"gocache", "binaries"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create build cache: %v", err)
+		}
+	}
+	return &BuildCache{dir: dir, maxBytes: int64(cfg.MaxMB) << 20}, nil
+}
+
+// GoCacheDir returns the directory to use as GOCACHE, or "" when caching is
+// disabled and the go command's default applies
+func (c *BuildCache) GoCacheDir() string {
+	if c == nil {
+		return ""
+	}
+	return filepath.Join(c.dir, "gocache")
+}
+
+// Key hashes the workspace together with anything else that changes the
+// binary, such as the toolchain version, passed in as salt
+func (c *BuildCache) Key(salt string, files map[string]string, tests bool) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00tests=%t\x00", salt, tests)
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\x00%d\x00%s", name, len(files[name]), files[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// binaryPath returns where the binary for key is stored
+func (c *BuildCache) binaryPath(key string) string {
+	return filepath.Join(c.dir, "binaries", key)
+}
+
+// Load copies the cached binary for key to dst and reports whether there
+// was one
+func (c *BuildCache) Load(key, dst string) bool {
+	if c == nil {
+		return false
+	}
+
+	src := c.binaryPath(key)
+	if err := copyFile(src, dst, 0755); err != nil {
+		c.misses.Add(1)
+		return false
+	}
+	c.hits.Add(1)
+
+	// The modification time doubles as the last use for eviction
+	now := time.Now()
+	os.Chtimes(src, now, now)
+	return true
+}
+
+// Store adds the binary at src to the cache under key
+func (c *BuildCache) Store(key, src string) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Copy under a temporary name so that a concurrent Load never sees a
+	// partly written binary
+	tmp := c.binaryPath(key) + ".tmp"
+	if err := copyFile(src, tmp, 0755); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to cache binary: %v", err)
+	}
+	if err := os.Rename(tmp, c.binaryPath(key)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to cache binary: %v", err)
+	}
+
+	c.evict()
+	return nil
+}
+
+// evict removes the least recently used binaries until the cache fits in
+// its size limit. The caller holds mu.
+func (c *BuildCache) evict() {
+	if c.maxBytes <= 0 {
+		return
+	}
+
+	entries := c.entries()
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size()
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+	for _, entry := range entries {
+		if total <= c.maxBytes {
+			break
+		}
+		if err := os.Remove(c.binaryPath(entry.Name())); err == nil {
+			total -= entry.Size()
+		}
+	}
+}
+
+// entries lists the cached binaries
+func (c *BuildCache) entries() []os.FileInfo {
+	dirEntries, err := os.ReadDir(filepath.Join(c.dir, "binaries"))
+	if err != nil {
+		return nil
+	}
+
+	var infos []os.FileInfo
+	for _, entry := range dirEntries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// Stats returns the hit and miss counters and the size of the cache
+func (c *BuildCache) Stats() BuildCacheStats {
+	if c == nil {
+		return BuildCacheStats{}
+	}
+
+	stats := BuildCacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+	for _, entry := range c.entries() {
+		stats.Entries++
+		stats.Bytes += entry.Size()
+	}
+	return stats
+}
+
+// copyFile copies src to dst, replacing dst
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CheckResponse reports what gofmt, go vet and the built-in analyzers found
+// in a program, without running it
+type CheckResponse struct {
+	// Formatted is set when gofmt leaves every file as it is; FormatDiff
+	// holds the output of gofmt -d otherwise
+	Formatted  bool   `json:"formatted"`
+	FormatDiff string `json:"format_diff,omitempty"`
+
+	// VetClean is set when go vet checked the code and found nothing
+	VetClean bool `json:"vet_clean"`
+
+	Findings []Finding `json:"findings"`
+
+	// Error and Diagnostics report code that go vet cannot check, such as
+	// code that does not compile
+	Error       string       `json:"error,omitempty"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+
+	GoVersion string `json:"go_version,omitempty"`
+}
+
+// Finding is one problem in the learner's code
+type Finding struct {
+	// Tool is "gofmt", "vet" or "lint", and Check names the vet analyzer or
+	// the built-in analyzer that reported the finding
+	Tool  string `json:"tool"`
+	Check string `json:"check"`
+
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"end_line,omitempty"`
+	EndColumn int    `json:"end_column,omitempty"`
+	Message   string `json:"message"`
+
+	SuggestedFixes []SuggestedFix `json:"suggested_fixes,omitempty"`
+}
+
+// SuggestedFix is a change to the learner's code that resolves a finding
+type SuggestedFix struct {
+	Message string     `json:"message"`
+	Edits   []TextEdit `json:"edits"`
+}
+
+// TextEdit replaces the text from one position of a file up to another.
+// Lines and columns count from 1, and columns in bytes, like the compiler's.
+type TextEdit struct {
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"end_line"`
+	EndColumn int    `json:"end_column"`
+	NewText   string `json:"new_text"`
+}
+
+// checkCode handles requests to check a program with gofmt, go vet and the
+// built-in analyzers
+func checkCode(c *gin.Context) {
+	var req CodeExecutionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	files, err := req.workspaceFiles()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := resolveGoVersion(c.Request.Context(), &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// go vet compiles the code, so it waits for a worker like a run
+	release, err := scheduler.Acquire(c.Request.Context(), requestUser(c), nil)
+	if err != nil {
+		respondNotScheduled(c, err)
+		return
+	}
+	defer release()
+
+	response, err := executor.Vet(c.Request.Context(), &req)
+	if err != nil {
+		respondExecutionError(c, err)
+		return
+	}
+	response.Findings = append(response.Findings, lintFiles(files)...)
+	sortFindings(response.Findings)
+
+	c.JSON(http.StatusOK, response)
+}
+
+// newCheckResponse gathers the findings in the output of gofmt -d and of
+// go vet -json, whose file names are relative to the module root. vetFailed
+// is set when go vet could not check the code.
+func newCheckResponse(files map[string]string, formatDiff, vetOutput string, vetFailed bool) *CheckResponse {
+	vetFindings, diagnostics := parseVetOutput(vetOutput, files)
+	response := &CheckResponse{
+		Formatted:  formatDiff == "",
+		FormatDiff: formatDiff,
+		VetClean:   !vetFailed && len(vetFindings) == 0,
+		Findings:   append(parseFormatDiff(formatDiff, files), vetFindings...),
+	}
+	if vetFailed {
+		response.Error = "Compilation failed"
+		response.Diagnostics = diagnostics
+		if len(diagnostics) == 0 {
+			response.Error = "go vet failed: " + strings.TrimSpace(vetOutput)
+		}
+	}
+	return response
+}
+
+// rejectedCheck turns a request rejected for its imports into a check result
+func rejectedCheck(rejected *CodeExecutionResponse) *CheckResponse {
+	return &CheckResponse{
+		Formatted:   true,
+		Findings:    []Finding{},
+		Error:       rejected.Error,
+		Diagnostics: rejected.Diagnostics,
+	}
+}
+
+// vetDiagnostic is one finding in the output of go vet -json
+type vetDiagnostic struct {
+	Posn           string `json:"posn"`
+	End            string `json:"end"`
+	Message        string `json:"message"`
+	SuggestedFixes []struct {
+		Message string `json:"message"`
+		Edits   []struct {
+			Filename string `json:"filename"`
+			Start    int    `json:"start"`
+			End      int    `json:"end"`
+			New      string `json:"new"`
+		} `json:"edits"`
+	} `json:"suggested_fixes"`
+}
+
+// vetPositionRegex matches positions such as "geo/geo.go:5:12"
+var vetPositionRegex = regexp.MustCompile(`^(.+):(\d+):(\d+)$`)
+
+// parseVetOutput reads the findings of go vet -json. The report is one JSON
+// object per package; other lines are package headers and the compiler
+// errors that kept go vet from checking a package, which are returned as
+// diagnostics.
+func parseVetOutput(output string, files map[string]string) ([]Finding, []Diagnostic) {
+	var findings []Finding
+	var report, rest strings.Builder
+	inReport := false
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case line == "{":
+			inReport = true
+			report.Reset()
+			report.WriteString(line)
+		case inReport:
+			report.WriteString(line)
+			if line == "}" {
+				inReport = false
+				findings = append(findings, vetFindings(report.String(), files)...)
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			rest.WriteString(strings.TrimPrefix(line, "vet: ") + "\n")
+		}
+	}
+	return findings, parseDiagnostics(rest.String())
+}
+
+// vetFindings converts the report of one package, which maps analyzers to
+// their findings. Analyzers that failed report an error object instead,
+// which is left out.
+func vetFindings(report string, files map[string]string) []Finding {
+	var packages map[string]map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(report), &packages); err != nil {
+		return nil
+	}
+
+	var findings []Finding
+	for _, analyzers := range packages {
+		for analyzer, raw := range analyzers {
+			var diagnostics []vetDiagnostic
+			if err := json.Unmarshal(raw, &diagnostics); err != nil {
+				continue
+			}
+			for _, d := range diagnostics {
+				finding := Finding{Tool: "vet", Check: analyzer, Message: d.Message}
+				if match := vetPositionRegex.FindStringSubmatch(d.Posn); match != nil {
+					finding.File = match[1]
+					finding.Line, _ = strconv.Atoi(match[2])
+					finding.Column, _ = strconv.Atoi(match[3])
+				}
+				if match := vetPositionRegex.FindStringSubmatch(d.End); match != nil && d.End != d.Posn {
+					finding.EndLine, _ = strconv.Atoi(match[2])
+					finding.EndColumn, _ = strconv.Atoi(match[3])
+				}
+				for _, fix := range d.SuggestedFixes {
+					suggested := SuggestedFix{Message: fix.Message, Edits: []TextEdit{}}
+					for _, edit := range fix.Edits {
+						suggested.Edits = append(suggested.Edits, textEdit(edit.Filename, files[edit.Filename], edit.Start, edit.End, edit.New))
+					}
+					finding.SuggestedFixes = append(finding.SuggestedFixes, suggested)
+				}
+				findings = append(findings, finding)
+			}
+		}
+	}
+	return findings
+}
+
+// hunkHeaderRegex matches the header of a hunk of a unified diff and
+// captures where it starts in the old file and how many lines of it it spans
+var hunkHeaderRegex = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+\d+(?:,(\d+))? @@`)
+
+// parseFormatDiff turns every hunk of gofmt -d output into a finding whose
+// suggested fix applies the hunk
+func parseFormatDiff(diff string, files map[string]string) []Finding {
+	var findings []Finding
+	var file string
+	lines := strings.Split(diff, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if name, ok := strings.CutPrefix(line, "+++ "); ok {
+			file = strings.TrimSpace(name)
+			continue
+		}
+		match := hunkHeaderRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		oldStart, _ := strconv.Atoi(match[1])
+		oldCount, newCount := 1, 1
+		if match[2] != "" {
+			oldCount, _ = strconv.Atoi(match[2])
+		}
+		if match[3] != "" {
+			newCount, _ = strconv.Atoi(match[3])
+		}
+
+		// Walk the hunk, noting the first and last changed line of the old
+		// file and collecting the new text
+		var newText strings.Builder
+		oldLine, firstChanged, lastChanged := oldStart, 0, 0
+		for oldCount > 0 || newCount > 0 {
+			if i+1 >= len(lines) {
+				break
+			}
+			i++
+			body := lines[i]
+			switch {
+			case strings.HasPrefix(body, "-"):
+				if firstChanged == 0 {
+					firstChanged = oldLine
+				}
+				lastChanged = oldLine
+				oldLine++
+				oldCount--
+			case strings.HasPrefix(body, "+"):
+				if firstChanged == 0 {
+					firstChanged = oldLine
+				}
+				lastChanged = max(lastChanged, oldLine-1, firstChanged)
+				newText.WriteString(body[1:] + "\n")
+				newCount--
+			case strings.HasPrefix(body, " ") || body == "":
+				newText.WriteString(strings.TrimPrefix(body, " ") + "\n")
+				oldLine++
+				oldCount--
+				newCount--
+			}
+		}
+
+		content := files[file]
+		start := lineOffset(content, oldStart)
+		if oldLine == oldStart {
+			// A hunk without old lines inserts after its start line
+			start = lineOffset(content, oldStart+1)
+		}
+		edit := textEdit(file, content, start, lineOffset(content, oldLine), newText.String())
+		findings = append(findings, Finding{
+			Tool:    "gofmt",
+			Check:   "gofmt",
+			File:    file,
+			Line:    firstChanged,
+			Column:  1,
+			EndLine: lastChanged,
+			Message: "formatting differs from gofmt",
+			SuggestedFixes: []SuggestedFix{
+				{Message: "Format with gofmt", Edits: []TextEdit{edit}},
+			},
+		})
+	}
+	return findings
+}
+
+// textEdit builds the edit replacing content[start:end] of a file
+func textEdit(file, content string, start, end int, newText string) TextEdit {
+	edit := TextEdit{File: file, NewText: newText}
+	edit.Line, edit.Column = filePosition(content, start)
+	edit.EndLine, edit.EndColumn = filePosition(content, end)
+	return edit
+}
+
+// filePosition converts a byte offset in content to a line and column
+func filePosition(content string, offset int) (int, int) {
+	offset = min(max(offset, 0), len(content))
+	line := 1 + strings.Count(content[:offset], "\n")
+	column := offset - strings.LastIndexByte(content[:offset], '\n')
+	return line, column
+}
+
+// lineOffset returns the offset at which a line starts, or the length of
+// content for lines past its end
+func lineOffset(content string, line int) int {
+	offset := 0
+	for ; line > 1; line-- {
+		next := strings.IndexByte(content[offset:], '\n')
+		if next < 0 {
+			return len(content)
+		}
+		offset += next + 1
+	}
+	return offset
+}
+
+// sortFindings orders findings by their position
+func sortFindings(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Config holds the server settings read from the environment at startup
+type Config struct {
+	Port string
+
+	// TrustedProxies lists the addresses or CIDR ranges of the reverse
+	// proxies whose forwarded client address is believed. No proxy is
+	// trusted by default, so clients are known by their own address.
+	TrustedProxies []string
+
+	Executor  ExecutorConfig
+	Scheduler SchedulerConfig
+	Database  DatabaseConfig
+	Auth      AuthConfig
+}
+
+// AuthConfig controls the tokens that authenticate users
+type AuthConfig struct {
+	// Secret signs the tokens; when empty, a key generated on the first
+	// start is kept in the data directory
+	Secret string
+
+	// TokenTTLHours is how long a login lasts
+	TokenTTLHours int
+
+	// ProgressTokenTTLHours is how long an anonymous pass can be merged into
+	// an account
+	ProgressTokenTTLHours int
+
+	// FrontendURL is where browsers return to after single sign-on
+	FrontendURL string
+
+	OIDC OIDCConfig
+}
+
+// OIDCConfig connects to an OpenID Connect provider for single sign-on,
+// which is off while Issuer is empty
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// ProviderName labels the login button
+	ProviderName string
+
+	// UsernameClaim names the claim usernames are made from, and
+	// GroupsClaim the one whose groups map to roles. Without any admin or
+	// instructor groups, roles are left to the admins.
+	UsernameClaim    string
+	GroupsClaim      string
+	AdminGroups      []string
+	InstructorGroups []string
+}
+
+// DatabaseConfig controls where lessons come from and what the progress
+// database keeps
+type DatabaseConfig struct {
+	// LessonsDir holds the lesson files loaded into the lessons database
+	LessonsDir string
+
+	// HistoryPerLesson is how many executions are kept per user and lesson,
+	// the oldest being dropped first; zero keeps all of them
+	HistoryPerLesson int
+}
+
+// SchedulerConfig limits how many programs run at once. PerUser counts both
+// queued and running programs; zero disables the per-user limit.
+type SchedulerConfig struct {
+	Workers   int
+	PerUser   int
+	QueueSize int
+}
+
+// ExecutorConfig selects and configures the code execution backend
+type ExecutorConfig struct {
+	// Backend is one of "docker", "sandbox", "local" or "fake"
+	Backend     string
+	DockerImage string
+	DockerPool  DockerPoolConfig
+	Limits      ResourceLimits
+	Sandbox     SandboxConfig
+	Modules     ModulesConfig
+	BuildCache  BuildCacheConfig
+	Toolchains  ToolchainConfig
+}
+
+// DockerPoolConfig sizes the pools of pre-started containers of the docker
+// backend. A size of zero starts a container for every request. MaxWaiting
+// bounds the requests waiting for a container of a pool; zero means no
+// bound.
+type DockerPoolConfig struct {
+	Size            int
+	RefillPerSecond int
+	MaxWaiting      int
+}
+
+// SandboxConfig holds the rlimits that only the namespace sandbox backend
+// applies, on top of the ResourceLimits shared by all backends
+type SandboxConfig struct {
+	MaxOpenFiles  int
+	MaxFileSizeMB int
+}
+
+// BuildCacheConfig locates the shared GOCACHE and the compiled binary cache
+type BuildCacheConfig struct {
+	Dir   string
+	MaxMB int
+}
+
+// LoadConfig reads the configuration from environment variables, falling
+// back to development defaults
+func LoadConfig() Config {
+	return Config{
+		Port:           getEnv("PORT", "8080"),
+		TrustedProxies: getEnvList("TRUSTED_PROXIES", nil),
+		Executor: ExecutorConfig{
+			Backend:     getEnv("EXECUTOR_BACKEND", "local"),
+			DockerImage: getEnv("EXECUTOR_DOCKER_IMAGE", "go-executor:latest"),
+			DockerPool: DockerPoolConfig{
+				Size:            getEnvInt("DOCKER_POOL_SIZE", 4),
+				RefillPerSecond: getEnvInt("DOCKER_POOL_REFILL_PER_SECOND", 2),
+				MaxWaiting:      getEnvInt("DOCKER_POOL_MAX_WAITING", 32),
+			},
+			Limits: ResourceLimits{
+				MemoryMB:    getEnvInt("LIMIT_MEMORY_MB", 256),
+				CPUSeconds:  getEnvInt("LIMIT_CPU_SECONDS", 5),
+				WallSeconds: getEnvInt("LIMIT_WALL_SECONDS", 10),
+				Processes:   getEnvInt("LIMIT_PROCESSES", 64),
+				OutputKB:    getEnvInt("LIMIT_OUTPUT_KB", 1024),
+				CPUs:        getEnvInt("LIMIT_CPUS", 1),
+			},
+			Sandbox: SandboxConfig{
+				MaxOpenFiles:  getEnvInt("SANDBOX_MAX_OPEN_FILES", 64),
+				MaxFileSizeMB: getEnvInt("SANDBOX_MAX_FILE_SIZE_MB", 8),
+			},
+			Modules: ModulesConfig{
+				Dir:     getEnv("MODULE_CACHE_DIR", "modules"),
+				Allowed: getEnvList("MODULE_ALLOWLIST", defaultAllowedModules),
+			},
+			BuildCache: BuildCacheConfig{
+				Dir:   getEnv("BUILD_CACHE_DIR", "cache"),
+				MaxMB: getEnvInt("BUILD_CACHE_MAX_MB", 512),
+			},
+			Toolchains: ToolchainConfig{
+				GoRoots: getEnvList("GO_TOOLCHAINS", nil),
+				Default: getEnv("GO_DEFAULT_VERSION", ""),
+			},
+		},
+		Scheduler: SchedulerConfig{
+			Workers:   getEnvInt("EXECUTION_WORKERS", runtime.NumCPU()),
+			PerUser:   getEnvInt("EXECUTION_PER_USER", 2),
+			QueueSize: getEnvInt("EXECUTION_QUEUE_SIZE", 32),
+		},
+		Database: DatabaseConfig{
+			LessonsDir:       getEnv("LESSONS_DIR", "lessons"),
+			HistoryPerLesson: getEnvInt("HISTORY_PER_LESSON", 100),
+		},
+		Auth: AuthConfig{
+			Secret:                getEnv("AUTH_SECRET", ""),
+			TokenTTLHours:         getEnvInt("AUTH_TOKEN_TTL_HOURS", 168),
+			ProgressTokenTTLHours: getEnvInt("PROGRESS_TOKEN_TTL_HOURS", 720),
+			FrontendURL:           getEnv("FRONTEND_URL", "http://localhost:5173"),
+			OIDC: OIDCConfig{
+				Issuer:           getEnv("OIDC_ISSUER", ""),
+				ClientID:         getEnv("OIDC_CLIENT_ID", ""),
+				ClientSecret:     getEnv("OIDC_CLIENT_SECRET", ""),
+				RedirectURL:      getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/api/auth/oidc/callback"),
+				Scopes:           getEnvList("OIDC_SCOPES", []string{"openid", "profile", "email"}),
+				ProviderName:     getEnv("OIDC_PROVIDER_NAME", "Single sign-on"),
+				UsernameClaim:    getEnv("OIDC_USERNAME_CLAIM", "preferred_username"),
+				GroupsClaim:      getEnv("OIDC_GROUPS_CLAIM", "groups"),
+				AdminGroups:      getEnvList("OIDC_ADMIN_GROUPS", nil),
+				InstructorGroups: getEnvList("OIDC_INSTRUCTOR_GROUPS", nil),
+			},
+		},
+	}
+}
+
+// getEnv returns the value of an environment variable or a default when unset
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvInt returns the integer value of an environment variable or a default
+// when it is unset or malformed
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("⚠️  Ignoring invalid %s=%q: %v", key, value, err)
+		return fallback
+	}
+	return n
+}
+
+// getEnvList returns the comma-separated values of an environment variable
+// or a default when it is unset
+func getEnvList(key string, fallback []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -3,20 +3,24 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // Database represents the database connection
 type Database struct {
-	conn *sql.DB
+	conn   *sql.DB
+	config DatabaseConfig
 }
 
 // NewDatabase creates a new database connection
-func NewDatabase() (*Database, error) {
+func NewDatabase(cfg DatabaseConfig) (*Database, error) {
 	// Create data directory if it doesn't exist
 	dataDir := "data"
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
@@ -37,7 +41,7 @@ func NewDatabase() (*Database, error) {
 		return nil, err
 	}
 
-	database := &Database{conn: db}
+	database := &Database{conn: db, config: cfg}
 
 	// Initialize database schema
 	if err := database.initSchema(); err != nil {
@@ -57,6 +61,7 @@ func (db *Database) initSchema() error {
 		lesson_id INTEGER NOT NULL,
 		completed BOOLEAN NOT NULL DEFAULT 0,
 		completed_at DATETIME,
+		lesson_version INTEGER NOT NULL DEFAULT 1,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		UNIQUE(user_id, lesson_id)
@@ -65,6 +70,65 @@ func (db *Database) initSchema() error {
 	CREATE INDEX IF NOT EXISTS idx_user_progress_user_id ON user_progress(user_id);
 	CREATE INDEX IF NOT EXISTS idx_user_progress_lesson_id ON user_progress(lesson_id);
 	CREATE INDEX IF NOT EXISTS idx_user_progress_completed ON user_progress(completed);
+
+	CREATE TABLE IF NOT EXISTS executions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		lesson_id INTEGER NOT NULL DEFAULT 0,
+		kind TEXT NOT NULL,
+		code TEXT NOT NULL,
+		files TEXT NOT NULL DEFAULT '{}',
+		stdin TEXT NOT NULL DEFAULT '',
+		go_version TEXT NOT NULL DEFAULT '',
+		output TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		exit_code INTEGER,
+		passed BOOLEAN,
+		duration_ms INTEGER NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_executions_user_lesson ON executions(user_id, lesson_id, id);
+
+	CREATE TABLE IF NOT EXISTS users (
+		id TEXT PRIMARY KEY,
+		username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		password_hash TEXT NOT NULL,
+		role TEXT NOT NULL DEFAULT 'learner',
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS identities (
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (issuer, subject)
+	);
+
+	CREATE TABLE IF NOT EXISTS students (
+		instructor_id TEXT NOT NULL,
+		student_id TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (instructor_id, student_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS merged_progress_tokens (
+		token_id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		expires_at INTEGER NOT NULL,
+		merged_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		actor_id TEXT NOT NULL,
+		action TEXT NOT NULL,
+		target TEXT NOT NULL DEFAULT '',
+		details TEXT NOT NULL DEFAULT '{}',
+		client_ip TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
 	`
 
 	_, err := db.conn.Exec(createTableSQL)
@@ -72,6 +136,30 @@ func (db *Database) initSchema() error {
 		return err
 	}
 
+	// Accounts created before roles existed are learners
+	columns, err := tableColumns(db.conn, "users")
+	if err != nil {
+		return err
+	}
+	if !columns["role"] {
+		log.Println("📚 Adding role to users...")
+		if _, err := db.conn.Exec("ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'learner'"); err != nil {
+			return err
+		}
+	}
+
+	// Lessons completed before lessons had versions were on their first one
+	columns, err = tableColumns(db.conn, "user_progress")
+	if err != nil {
+		return err
+	}
+	if !columns["lesson_version"] {
+		log.Println("📚 Adding lesson_version to user progress...")
+		if _, err := db.conn.Exec("ALTER TABLE user_progress ADD COLUMN lesson_version INTEGER NOT NULL DEFAULT 1"); err != nil {
+			return err
+		}
+	}
+
 	// Initialize lessons database
 	if err := db.initLessonsDatabase(); err != nil {
 		return err
@@ -84,7 +172,7 @@ func (db *Database) initSchema() error {
 // GetUserProgress retrieves all progress for a user
 func (db *Database) GetUserProgress(userID string) ([]UserProgress, error) {
 	query := `
-		SELECT user_id, lesson_id, completed, completed_at 
+		SELECT user_id, lesson_id, completed, completed_at, lesson_version
 		FROM user_progress 
 		WHERE user_id = ? 
 		ORDER BY lesson_id
@@ -101,7 +189,7 @@ func (db *Database) GetUserProgress(userID string) ([]UserProgress, error) {
 		var p UserProgress
 		var completedAt sql.NullString
 
-		err := rows.Scan(&p.UserID, &p.LessonID, &p.Completed, &completedAt)
+		err := rows.Scan(&p.UserID, &p.LessonID, &p.Completed, &completedAt, &p.LessonVersion)
 		if err != nil {
 			return []UserProgress{}, err
 		}
@@ -119,9 +207,9 @@ func (db *Database) GetUserProgress(userID string) ([]UserProgress, error) {
 // UpdateUserProgress updates or creates user progress
 func (db *Database) UpdateUserProgress(progress UserProgress) error {
 	query := `
-		INSERT OR REPLACE INTO user_progress 
-		(user_id, lesson_id, completed, completed_at, updated_at) 
-		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		INSERT OR REPLACE INTO user_progress
+		(user_id, lesson_id, completed, completed_at, lesson_version, updated_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
 	`
 
 	var completedAt interface{}
@@ -129,10 +217,376 @@ func (db *Database) UpdateUserProgress(progress UserProgress) error {
 		completedAt = *progress.CompletedAt
 	}
 
-	_, err := db.conn.Exec(query, progress.UserID, progress.LessonID, progress.Completed, completedAt)
+	_, err := db.conn.Exec(query, progress.UserID, progress.LessonID, progress.Completed, completedAt, max(progress.LessonVersion, 1))
+	return err
+}
+
+// CreateUser adds an account. Usernames are unique regardless of case.
+func (db *Database) CreateUser(id, username, passwordHash string) (*User, error) {
+	return insertUser(db.conn, id, username, passwordHash)
+}
+
+// CreateIdentityUser adds an account without a password for an identity
+// of a single sign-on provider
+func (db *Database) CreateIdentityUser(id, username, issuer, subject string) (*User, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	user, err := insertUser(tx, id, username, "")
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO identities (issuer, subject, user_id, created_at) VALUES (?, ?, ?, ?)",
+		issuer, subject, user.ID, user.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return user, tx.Commit()
+}
+
+// GetUserByIdentity retrieves the account of a single sign-on identity
+func (db *Database) GetUserByIdentity(issuer, subject string) (*User, error) {
+	var user User
+	err := db.conn.QueryRow(`
+		SELECT u.id, u.username, u.role, u.created_at
+		FROM identities i JOIN users u ON u.id = i.user_id
+		WHERE i.issuer = ? AND i.subject = ?
+	`, issuer, subject).Scan(&user.ID, &user.Username, &user.Role, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// insertUser adds an account with conn, which is the database or a
+// transaction
+func insertUser(conn interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}, id, username, passwordHash string) (*User, error) {
+	user := &User{ID: id, Username: username, Role: RoleLearner, CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+	result, err := conn.Exec(
+		"INSERT INTO users (id, username, password_hash, created_at) VALUES (?, ?, ?, ?) ON CONFLICT(username) DO NOTHING",
+		user.ID, user.Username, passwordHash, user.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if inserted, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if inserted == 0 {
+		return nil, errUsernameTaken
+	}
+	return user, nil
+}
+
+// GetUser retrieves an account by its ID
+func (db *Database) GetUser(id string) (*User, error) {
+	var user User
+	err := db.conn.QueryRow("SELECT id, username, role, created_at FROM users WHERE id = ?", id).
+		Scan(&user.ID, &user.Username, &user.Role, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUserByUsername retrieves an account and its password hash by username
+func (db *Database) GetUserByUsername(username string) (*User, string, error) {
+	var user User
+	var passwordHash string
+	err := db.conn.QueryRow("SELECT id, username, password_hash, role, created_at FROM users WHERE username = ?", username).
+		Scan(&user.ID, &user.Username, &passwordHash, &user.Role, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", errUserNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &user, passwordHash, nil
+}
+
+// GetUsers lists every account by username
+func (db *Database) GetUsers() ([]User, error) {
+	return db.queryUsers("SELECT id, username, role, created_at FROM users ORDER BY username")
+}
+
+// SetUserRole changes the role of an account
+func (db *Database) SetUserRole(id string, role Role) error {
+	result, err := db.conn.Exec("UPDATE users SET role = ? WHERE id = ?", role, id)
+	if err != nil {
+		return err
+	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return errUserNotFound
+	}
+	return nil
+}
+
+// AddStudent assigns a learner to an instructor; assigning twice is a no-op
+func (db *Database) AddStudent(instructorID, studentID string) error {
+	_, err := db.conn.Exec(
+		"INSERT INTO students (instructor_id, student_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		instructorID, studentID, time.Now().UTC().Format(time.RFC3339),
+	)
+	return err
+}
+
+// ClaimProgressToken records that a progress token was merged by a user and
+// reports whether it was merged for the first time. Tokens are only kept
+// until they expire, after which they cannot be merged anyway.
+func (db *Database) ClaimProgressToken(tokenID, userID string, expiresAt int64) (bool, error) {
+	now := time.Now().UTC()
+	if _, err := db.conn.Exec("DELETE FROM merged_progress_tokens WHERE expires_at <= ?", now.Unix()); err != nil {
+		return false, err
+	}
+	result, err := db.conn.Exec(
+		"INSERT INTO merged_progress_tokens (token_id, user_id, expires_at, merged_at) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING",
+		tokenID, userID, expiresAt, now.Format(time.RFC3339),
+	)
+	if err != nil {
+		return false, err
+	}
+	claimed, err := result.RowsAffected()
+	return claimed > 0, err
+}
+
+// RemoveStudent takes a learner away from an instructor and reports whether
+// the learner was assigned
+func (db *Database) RemoveStudent(instructorID, studentID string) (bool, error) {
+	result, err := db.conn.Exec("DELETE FROM students WHERE instructor_id = ? AND student_id = ?", instructorID, studentID)
+	if err != nil {
+		return false, err
+	}
+	removed, err := result.RowsAffected()
+	return removed > 0, err
+}
+
+// GetStudents lists the learners assigned to an instructor by username
+func (db *Database) GetStudents(instructorID string) ([]User, error) {
+	return db.queryUsers(`
+		SELECT u.id, u.username, u.role, u.created_at
+		FROM students s JOIN users u ON u.id = s.student_id
+		WHERE s.instructor_id = ?
+		ORDER BY u.username
+	`, instructorID)
+}
+
+// IsStudent reports whether a learner is assigned to an instructor
+func (db *Database) IsStudent(instructorID, studentID string) (bool, error) {
+	var found int
+	err := db.conn.QueryRow("SELECT 1 FROM students WHERE instructor_id = ? AND student_id = ?", instructorID, studentID).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// queryUsers runs a query selecting id, username, role and created_at
+func (db *Database) queryUsers(query string, args ...any) ([]User, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &user.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// RecordAudit appends an entry to the audit log
+func (db *Database) RecordAudit(entry *AuditEntry) error {
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return err
+	}
+	if entry.Details == nil {
+		details = []byte("{}")
+	}
+
+	result, err := db.conn.Exec(
+		"INSERT INTO audit_log (actor_id, action, target, details, client_ip, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		entry.ActorID, entry.Action, entry.Target, string(details), entry.ClientIP, entry.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	entry.ID, err = result.LastInsertId()
 	return err
 }
 
+// GetAuditLog lists audit entries newest first. actorID, when not empty,
+// narrows them to one actor; before, when not zero, pages back from that
+// entry.
+func (db *Database) GetAuditLog(actorID string, before int64, limit int) ([]AuditEntry, error) {
+	query := `
+		SELECT id, actor_id, action, target, details, client_ip, created_at
+		FROM audit_log
+		WHERE (? = '' OR actor_id = ?) AND (? = 0 OR id < ?)
+		ORDER BY id DESC
+		LIMIT ?
+	`
+
+	rows, err := db.conn.Query(query, actorID, actorID, before, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var details string
+		if err := rows.Scan(&entry.ID, &entry.ActorID, &entry.Action, &entry.Target, &details, &entry.ClientIP, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(details), &entry.Details); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// RecordExecution stores an execution in its user's history and drops the
+// oldest ones of the same lesson beyond the configured number
+func (db *Database) RecordExecution(execution *Execution) (int64, error) {
+	files, err := json.Marshal(execution.Files)
+	if err != nil {
+		return 0, err
+	}
+	if execution.Files == nil {
+		files = []byte("{}")
+	}
+
+	result, err := db.conn.Exec(`
+		INSERT INTO executions
+		(user_id, lesson_id, kind, code, files, stdin, go_version, output, error, exit_code, passed, duration_ms, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		execution.UserID,
+		execution.LessonID,
+		execution.Kind,
+		execution.Code,
+		string(files),
+		execution.Stdin,
+		execution.GoVersion,
+		execution.Output,
+		execution.Error,
+		execution.ExitCode,
+		execution.Passed,
+		execution.DurationMs,
+		execution.CreatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if keep := db.config.HistoryPerLesson; keep > 0 {
+		_, err = db.conn.Exec(`
+			DELETE FROM executions
+			WHERE user_id = ? AND lesson_id = ? AND id NOT IN (
+				SELECT id FROM executions WHERE user_id = ? AND lesson_id = ? ORDER BY id DESC LIMIT ?
+			)
+		`, execution.UserID, execution.LessonID, execution.UserID, execution.LessonID, keep)
+		if err != nil {
+			return id, err
+		}
+	}
+	return id, nil
+}
+
+// GetExecutions lists a user's executions, newest first and without their
+// code and output. A lessonID of nil lists those of every lesson; before,
+// when not zero, pages back from that execution.
+func (db *Database) GetExecutions(userID string, lessonID *int, before int64, limit int) ([]Execution, error) {
+	query := `
+		SELECT id, user_id, lesson_id, kind, go_version, error, exit_code, passed, duration_ms, created_at
+		FROM executions
+		WHERE user_id = ? AND (? IS NULL OR lesson_id = ?) AND (? = 0 OR id < ?)
+		ORDER BY id DESC
+		LIMIT ?
+	`
+
+	rows, err := db.conn.Query(query, userID, lessonID, lessonID, before, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	executions := []Execution{}
+	for rows.Next() {
+		var e Execution
+		err := rows.Scan(&e.ID, &e.UserID, &e.LessonID, &e.Kind, &e.GoVersion, &e.Error, &e.ExitCode, &e.Passed, &e.DurationMs, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		executions = append(executions, e)
+	}
+	return executions, rows.Err()
+}
+
+// GetExecution loads one of a user's executions with its code and output
+func (db *Database) GetExecution(userID string, id int64) (*Execution, error) {
+	query := `
+		SELECT id, user_id, lesson_id, kind, code, files, stdin, go_version, output, error, exit_code, passed, duration_ms, created_at
+		FROM executions
+		WHERE user_id = ? AND id = ?
+	`
+
+	var e Execution
+	var files string
+	err := db.conn.QueryRow(query, userID, id).Scan(
+		&e.ID,
+		&e.UserID,
+		&e.LessonID,
+		&e.Kind,
+		&e.Code,
+		&files,
+		&e.Stdin,
+		&e.GoVersion,
+		&e.Output,
+		&e.Error,
+		&e.ExitCode,
+		&e.Passed,
+		&e.DurationMs,
+		&e.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(files), &e.Files); err != nil {
+		return nil, err
+	}
+	if len(e.Files) == 0 {
+		e.Files = nil
+	}
+	return &e, nil
+}
+
 // Close closes the database connection
 func (db *Database) Close() error {
 	return db.conn.Close()
@@ -141,8 +595,7 @@ func (db *Database) Close() error {
 // initLessonsDatabase initializes the lessons database
 func (db *Database) initLessonsDatabase() error {
 	// Open lessons database
-	lessonsDBPath := filepath.Join("data", "lessons.db")
-	lessonsDB, err := sql.Open("sqlite3", lessonsDBPath)
+	lessonsDB, err := openLessonsDatabase()
 	if err != nil {
 		return err
 	}
@@ -159,11 +612,27 @@ func (db *Database) initLessonsDatabase() error {
 		variants TEXT NOT NULL,
 		exercise TEXT NOT NULL,
 		solution TEXT NOT NULL,
+		test_code TEXT NOT NULL DEFAULT '',
+		starter_files TEXT NOT NULL DEFAULT '{}',
+		solution_files TEXT NOT NULL DEFAULT '{}',
+		go_version TEXT NOT NULL DEFAULT '',
+		require_vet INTEGER NOT NULL DEFAULT 0,
 		difficulty TEXT NOT NULL,
 		order_index INTEGER NOT NULL,
 		category TEXT NOT NULL,
+		version INTEGER NOT NULL DEFAULT 1,
+		retired_at DATETIME,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS lesson_versions (
+		lesson_id INTEGER NOT NULL,
+		version INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		author_id TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (lesson_id, version)
 	);`
 
 	_, err = lessonsDB.Exec(createLessonsTable)
@@ -171,138 +640,183 @@ func (db *Database) initLessonsDatabase() error {
 		return err
 	}
 
-	// Check if lessons table is empty and populate it
-	var count int
-	err = lessonsDB.QueryRow("SELECT COUNT(*) FROM lessons").Scan(&count)
-	if err != nil {
+	// Bring databases created by older versions up to date
+	if err := db.migrateLessonColumns(lessonsDB); err != nil {
+		return err
+	}
+
+	// Lessons from before versioning start at version 1
+	if err := db.snapshotUnversionedLessons(lessonsDB); err != nil {
 		return err
 	}
 
-	if count == 0 {
-		log.Println("📚 Populating lessons database...")
-		return db.populateLessonsDatabase(lessonsDB)
+	lessons, err := loadLessonFiles(db.config.LessonsDir)
+	if err != nil {
+		return fmt.Errorf("invalid lesson files: %v", err)
+	}
+	if err := db.syncLessonFiles(lessonsDB, lessons); err != nil {
+		return err
 	}
 
 	log.Println("✅ Lessons database initialized")
 	return nil
 }
 
-// populateLessonsDatabase populates the lessons database with initial data
-func (db *Database) populateLessonsDatabase(lessonsDB *sql.DB) error {
-	lessons := getTutorialLessons()
+// openLessonsDatabase opens the database the lessons are kept in
+func openLessonsDatabase() (*sql.DB, error) {
+	return sql.Open("sqlite3", filepath.Join("data", "lessons.db"))
+}
 
-	stmt, err := lessonsDB.Prepare(`
-		INSERT INTO lessons (id, title, description, content, explanation, variants, exercise, solution, difficulty, order_index, category)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`)
+// lessonColumnMigrations lists the columns added to the lessons table after
+// its first release, in the order they were added
+var lessonColumnMigrations = []struct {
+	name       string
+	definition string
+}{
+	{"test_code", "TEXT NOT NULL DEFAULT ''"},
+	{"starter_files", "TEXT NOT NULL DEFAULT '{}'"},
+	{"solution_files", "TEXT NOT NULL DEFAULT '{}'"},
+	{"go_version", "TEXT NOT NULL DEFAULT ''"},
+	{"require_vet", "INTEGER NOT NULL DEFAULT 0"},
+	{"version", "INTEGER NOT NULL DEFAULT 1"},
+	{"retired_at", "DATETIME"},
+}
+
+// migrateLessonColumns adds missing columns to an existing lessons table
+func (db *Database) migrateLessonColumns(lessonsDB *sql.DB) error {
+	columns, err := tableColumns(lessonsDB, "lessons")
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	for _, lesson := range lessons {
-		// Convert variants slice to JSON string
-		variantsJSON, err := json.Marshal(lesson.Variants)
-		if err != nil {
-			return err
+	for _, column := range lessonColumnMigrations {
+		if columns[column.name] {
+			continue
 		}
-
-		_, err = stmt.Exec(
-			lesson.ID,
-			lesson.Title,
-			lesson.Description,
-			lesson.Content,
-			lesson.Explanation,
-			string(variantsJSON),
-			lesson.Exercise,
-			lesson.Solution,
-			lesson.Difficulty,
-			lesson.Order,
-			lesson.Category,
-		)
-		if err != nil {
+		log.Printf("📚 Adding %s to lessons database...", column.name)
+		if _, err := lessonsDB.Exec("ALTER TABLE lessons ADD COLUMN " + column.name + " " + column.definition); err != nil {
 			return err
 		}
 	}
 
-	log.Println("✅ Lessons database populated successfully")
 	return nil
 }
 
-// GetLessons retrieves all lessons from the database
-func (db *Database) GetLessons() ([]Lesson, error) {
-	lessonsDBPath := filepath.Join("data", "lessons.db")
-	lessonsDB, err := sql.Open("sqlite3", lessonsDBPath)
-	if err != nil {
-		return nil, err
-	}
-	defer lessonsDB.Close()
-
-	query := `
-		SELECT id, title, description, content, explanation, variants, exercise, solution, difficulty, order_index, category
-		FROM lessons 
-		ORDER BY order_index
-	`
-
-	rows, err := lessonsDB.Query(query)
+// tableColumns returns the names of the columns of a table
+func tableColumns(conn *sql.DB, table string) (map[string]bool, error) {
+	rows, err := conn.Query("PRAGMA table_info(" + table + ")")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var lessons []Lesson
+	columns := make(map[string]bool)
 	for rows.Next() {
-		var lesson Lesson
-		var variantsJSON string
-
-		err := rows.Scan(
-			&lesson.ID,
-			&lesson.Title,
-			&lesson.Description,
-			&lesson.Content,
-			&lesson.Explanation,
-			&variantsJSON,
-			&lesson.Exercise,
-			&lesson.Solution,
-			&lesson.Difficulty,
-			&lesson.Order,
-			&lesson.Category,
-		)
-		if err != nil {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
 			return nil, err
 		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
 
-		// Parse variants JSON
-		err = json.Unmarshal([]byte(variantsJSON), &lesson.Variants)
+// syncLessonFiles brings the lessons database up to date with the lesson
+// files. Lessons new to the database are added in the order of their files,
+// and lessons whose file changed get a new version. Lessons edited through
+// the API since they were last loaded keep those edits.
+func (db *Database) syncLessonFiles(lessonsDB *sql.DB, lessons []Lesson) error {
+	added, updated := 0, 0
+	for i := range lessons {
+		lesson := &lessons[i]
+		status, err := syncLessonFile(lessonsDB, lesson)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("lesson %d: %v", lesson.ID, err)
+		}
+		switch status {
+		case "added":
+			added++
+		case "updated":
+			updated++
+		case "edited":
+			log.Printf("⚠️  Lesson %d was edited through the API, ignoring changes to its file", lesson.ID)
 		}
-
-		lessons = append(lessons, lesson)
 	}
 
-	return lessons, nil
+	log.Printf("📚 Loaded %d lessons from %s, %d added and %d updated", len(lessons), db.config.LessonsDir, added, updated)
+	return nil
 }
 
-// GetLesson retrieves a specific lesson by ID from the database
-func (db *Database) GetLesson(id int) (*Lesson, error) {
-	lessonsDBPath := filepath.Join("data", "lessons.db")
-	lessonsDB, err := sql.Open("sqlite3", lessonsDBPath)
+// syncLessonFile writes a lesson loaded from its file to the database, and
+// reports whether it was "added", "updated", "edited" through the API and
+// left alone, or unchanged ("")
+func syncLessonFile(lessonsDB *sql.DB, lesson *Lesson) (string, error) {
+	tx, err := lessonsDB.Begin()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	defer lessonsDB.Close()
+	defer tx.Rollback()
 
-	query := `
-		SELECT id, title, description, content, explanation, variants, exercise, solution, difficulty, order_index, category
-		FROM lessons 
-		WHERE id = ?
-	`
+	status := "updated"
+	current, err := scanLesson(tx.QueryRow("SELECT "+lessonColumns+" FROM lessons WHERE id = ?", lesson.ID))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if err := insertLessonRow(tx, lesson.ID, lesson.Order); err != nil {
+			return "", err
+		}
+		lesson.Version = 1
+		status = "added"
+	case err != nil:
+		return "", err
+	default:
+		var author string
+		err = tx.QueryRow("SELECT author_id FROM lesson_versions WHERE lesson_id = ? AND version = ?", current.ID, current.Version).Scan(&author)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+		if author != "" && author != lessonFilesAuthor {
+			return "edited", nil
+		}
+		if sameLessonContent(current, lesson) {
+			return "", nil
+		}
+		lesson.Version = current.Version + 1
+	}
+
+	if err := writeLessonVersion(tx, lesson, lessonFilesAuthor); err != nil {
+		return "", err
+	}
+	return status, tx.Commit()
+}
+
+// sameLessonContent reports whether two lessons have the same content,
+// whatever their version, order and retirement
+func sameLessonContent(a, b *Lesson) bool {
+	contents := make([]string, 2)
+	for i, lesson := range []*Lesson{a, b} {
+		content := LessonContent{Lesson: *lesson, TestCode: lesson.TestCode}
+		content.Version, content.Order, content.RetiredAt = 0, 0, nil
+		if len(content.Variants) == 0 {
+			content.Variants = nil
+		}
+		data, _ := json.Marshal(content)
+		contents[i] = string(data)
+	}
+	return contents[0] == contents[1]
+}
 
+// lessonColumns are the lesson columns scanLesson reads, in order
+const lessonColumns = `id, title, description, content, explanation, variants, exercise, solution, test_code, starter_files, solution_files, go_version, require_vet, difficulty, order_index, category, version, retired_at`
+
+// scanLesson reads a row of lessonColumns
+func scanLesson(row interface{ Scan(...any) error }) (*Lesson, error) {
 	var lesson Lesson
-	var variantsJSON string
+	var variantsJSON, starterJSON, solutionJSON string
+	var retiredAt sql.NullString
 
-	err = lessonsDB.QueryRow(query, id).Scan(
+	err := row.Scan(
 		&lesson.ID,
 		&lesson.Title,
 		&lesson.Description,
@@ -311,19 +825,422 @@ func (db *Database) GetLesson(id int) (*Lesson, error) {
 		&variantsJSON,
 		&lesson.Exercise,
 		&lesson.Solution,
+		&lesson.TestCode,
+		&starterJSON,
+		&solutionJSON,
+		&lesson.GoVersion,
+		&lesson.RequireVet,
 		&lesson.Difficulty,
 		&lesson.Order,
 		&lesson.Category,
+		&lesson.Version,
+		&retiredAt,
 	)
 	if err != nil {
 		return nil, err
 	}
+	if retiredAt.Valid {
+		lesson.RetiredAt = &retiredAt.String
+	}
 
 	// Parse variants JSON
 	err = json.Unmarshal([]byte(variantsJSON), &lesson.Variants)
 	if err != nil {
 		return nil, err
 	}
+	if err := decodeLessonFiles(&lesson, starterJSON, solutionJSON); err != nil {
+		return nil, err
+	}
 
 	return &lesson, nil
 }
+
+// GetLessons retrieves the lessons from the database in order, with the
+// retired ones only when includeRetired is set
+func (db *Database) GetLessons(includeRetired bool) ([]Lesson, error) {
+	lessonsDB, err := openLessonsDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer lessonsDB.Close()
+
+	query := "SELECT " + lessonColumns + " FROM lessons WHERE retired_at IS NULL OR ? ORDER BY order_index, id"
+	rows, err := lessonsDB.Query(query, includeRetired)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lessons []Lesson
+	for rows.Next() {
+		lesson, err := scanLesson(rows)
+		if err != nil {
+			return nil, err
+		}
+		lessons = append(lessons, *lesson)
+	}
+
+	return lessons, rows.Err()
+}
+
+// GetLesson retrieves a specific lesson by ID from the database, retired or
+// not, so that links and history keep working
+func (db *Database) GetLesson(id int) (*Lesson, error) {
+	lessonsDB, err := openLessonsDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer lessonsDB.Close()
+
+	lesson, err := scanLesson(lessonsDB.QueryRow("SELECT "+lessonColumns+" FROM lessons WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errLessonNotFound
+	}
+	return lesson, err
+}
+
+// CreateLesson adds a lesson as version 1 under the next free ID. A lesson
+// without an order goes after all others.
+func (db *Database) CreateLesson(lesson *Lesson, authorID string) (*Lesson, error) {
+	lessonsDB, err := openLessonsDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer lessonsDB.Close()
+
+	tx, err := lessonsDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	created := *lesson
+	created.Version = 1
+	created.RetiredAt = nil
+	var maxOrder int
+	err = tx.QueryRow("SELECT COALESCE(MAX(id), 0) + 1, COALESCE(MAX(order_index), 0) FROM lessons").Scan(&created.ID, &maxOrder)
+	if err != nil {
+		return nil, err
+	}
+	if created.Order <= 0 {
+		created.Order = maxOrder + 1
+	}
+
+	if err := insertLessonRow(tx, created.ID, created.Order); err != nil {
+		return nil, err
+	}
+	if err := writeLessonVersion(tx, &created, authorID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdateLesson stores new content for a lesson as its next version. When
+// baseVersion is not zero it must be the lesson's current version, so that
+// two authors cannot silently overwrite each other's edits. The order and
+// retirement of the lesson are kept.
+func (db *Database) UpdateLesson(lesson *Lesson, baseVersion int, authorID string) (*Lesson, error) {
+	lessonsDB, err := openLessonsDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer lessonsDB.Close()
+
+	tx, err := lessonsDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	current, err := scanLesson(tx.QueryRow("SELECT "+lessonColumns+" FROM lessons WHERE id = ?", lesson.ID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errLessonNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if baseVersion != 0 && baseVersion != current.Version {
+		return nil, errLessonVersionConflict
+	}
+
+	updated := *lesson
+	updated.Version = current.Version + 1
+	updated.Order = current.Order
+	updated.RetiredAt = current.RetiredAt
+	if err := writeLessonVersion(tx, &updated, authorID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// insertLessonRow adds an empty lesson for writeLessonVersion to fill in
+func insertLessonRow(tx *sql.Tx, id, order int) error {
+	_, err := tx.Exec(
+		"INSERT INTO lessons (id, title, description, content, explanation, variants, exercise, solution, difficulty, order_index, category) VALUES (?, '', '', '', '', '[]', '', '', '', ?, '')",
+		id, order,
+	)
+	return err
+}
+
+// writeLessonVersion sets the content columns of a lesson to the given
+// version and keeps a snapshot of it in lesson_versions
+func writeLessonVersion(tx *sql.Tx, lesson *Lesson, authorID string) error {
+	variantsJSON, err := json.Marshal(lesson.Variants)
+	if err != nil {
+		return err
+	}
+	starterJSON, err := encodeLessonFiles(lesson.StarterFiles)
+	if err != nil {
+		return err
+	}
+	solutionJSON, err := encodeLessonFiles(lesson.SolutionFiles)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE lessons SET title = ?, description = ?, content = ?, explanation = ?, variants = ?, exercise = ?, solution = ?, test_code = ?,
+			starter_files = ?, solution_files = ?, go_version = ?, require_vet = ?, difficulty = ?, category = ?, version = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`,
+		lesson.Title,
+		lesson.Description,
+		lesson.Content,
+		lesson.Explanation,
+		string(variantsJSON),
+		lesson.Exercise,
+		lesson.Solution,
+		lesson.TestCode,
+		starterJSON,
+		solutionJSON,
+		lesson.GoVersion,
+		lesson.RequireVet,
+		lesson.Difficulty,
+		lesson.Category,
+		lesson.Version,
+		lesson.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return insertLessonSnapshot(tx, lesson, authorID)
+}
+
+// insertLessonSnapshot records the content of a lesson version
+func insertLessonSnapshot(conn interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}, lesson *Lesson, authorID string) error {
+	snapshot := LessonContent{Lesson: *lesson, TestCode: lesson.TestCode}
+	snapshot.RetiredAt = nil
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Exec(
+		"INSERT INTO lesson_versions (lesson_id, version, content, author_id, created_at) VALUES (?, ?, ?, ?, ?)",
+		lesson.ID, lesson.Version, string(content), authorID, time.Now().UTC().Format(time.RFC3339),
+	)
+	return err
+}
+
+// snapshotUnversionedLessons records the current content of lessons whose
+// version has no snapshot, which are those written before versioning
+func (db *Database) snapshotUnversionedLessons(lessonsDB *sql.DB) error {
+	rows, err := lessonsDB.Query("SELECT " + lessonColumns + ` FROM lessons l
+		WHERE NOT EXISTS (SELECT 1 FROM lesson_versions v WHERE v.lesson_id = l.id AND v.version = l.version)`)
+	if err != nil {
+		return err
+	}
+	var lessons []*Lesson
+	for rows.Next() {
+		lesson, err := scanLesson(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		lessons = append(lessons, lesson)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, lesson := range lessons {
+		if err := insertLessonSnapshot(lessonsDB, lesson, ""); err != nil {
+			return err
+		}
+	}
+	if len(lessons) > 0 {
+		log.Printf("📚 Recorded versions of %d lessons", len(lessons))
+	}
+	return nil
+}
+
+// ReorderLessons puts the lessons with the given IDs first, in that order,
+// followed by the others in their current order. Reordering does not make
+// a new version of any lesson.
+func (db *Database) ReorderLessons(ids []int) error {
+	lessonsDB, err := openLessonsDatabase()
+	if err != nil {
+		return err
+	}
+	defer lessonsDB.Close()
+
+	tx, err := lessonsDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id FROM lessons ORDER BY order_index, id")
+	if err != nil {
+		return err
+	}
+	listed := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		listed[id] = false
+	}
+	order := append([]int(nil), ids...)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		if _, ok := listed[id]; ok {
+			listed[id] = true
+		} else {
+			order = append(order, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, found := range listed {
+		if !found {
+			return fmt.Errorf("lesson %d: %w", id, errLessonNotFound)
+		}
+	}
+
+	for i, id := range order {
+		if _, err := tx.Exec("UPDATE lessons SET order_index = ? WHERE id = ?", i+1, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SetLessonRetired retires a lesson, hiding it from learners, or brings it
+// back. Retiring a retired lesson keeps the time it was first retired.
+func (db *Database) SetLessonRetired(id int, retired bool) error {
+	lessonsDB, err := openLessonsDatabase()
+	if err != nil {
+		return err
+	}
+	defer lessonsDB.Close()
+
+	result, err := lessonsDB.Exec(
+		"UPDATE lessons SET retired_at = CASE WHEN ? THEN COALESCE(retired_at, CURRENT_TIMESTAMP) END WHERE id = ?",
+		retired, id,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errLessonNotFound
+	}
+	return nil
+}
+
+// GetLessonVersions lists the versions of a lesson, newest first, without
+// their content
+func (db *Database) GetLessonVersions(id int) ([]LessonVersion, error) {
+	lessonsDB, err := openLessonsDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer lessonsDB.Close()
+
+	rows, err := lessonsDB.Query(
+		"SELECT lesson_id, version, author_id, created_at FROM lesson_versions WHERE lesson_id = ? ORDER BY version DESC",
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []LessonVersion{}
+	for rows.Next() {
+		var v LessonVersion
+		if err := rows.Scan(&v.LessonID, &v.Version, &v.AuthorID, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// GetLessonVersion returns a version of a lesson with its content
+func (db *Database) GetLessonVersion(id, version int) (*LessonVersion, error) {
+	lessonsDB, err := openLessonsDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer lessonsDB.Close()
+
+	var v LessonVersion
+	var content string
+	err = lessonsDB.QueryRow(
+		"SELECT lesson_id, version, author_id, created_at, content FROM lesson_versions WHERE lesson_id = ? AND version = ?",
+		id, version,
+	).Scan(&v.LessonID, &v.Version, &v.AuthorID, &v.CreatedAt, &content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errLessonNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	v.Lesson = &LessonContent{}
+	if err := json.Unmarshal([]byte(content), v.Lesson); err != nil {
+		return nil, err
+	}
+	v.Lesson.Lesson.TestCode = v.Lesson.TestCode
+	return &v, nil
+}
+
+// encodeLessonFiles stores a lesson's file map as a JSON object
+func encodeLessonFiles(files map[string]string) (string, error) {
+	if files == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(files)
+	return string(data), err
+}
+
+// decodeLessonFiles parses the starter and solution file columns, leaving
+// the maps nil for single-file lessons
+func decodeLessonFiles(lesson *Lesson, starterJSON, solutionJSON string) error {
+	if err := json.Unmarshal([]byte(starterJSON), &lesson.StarterFiles); err != nil {
+		return err
+	}
+	if len(lesson.StarterFiles) == 0 {
+		lesson.StarterFiles = nil
+	}
+	if err := json.Unmarshal([]byte(solutionJSON), &lesson.SolutionFiles); err != nil {
+		return err
+	}
+	if len(lesson.SolutionFiles) == 0 {
+		lesson.SolutionFiles = nil
+	}
+	return nil
+}
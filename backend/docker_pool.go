@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// containerPoolLabel marks pooled containers so that those left behind by a
+// previous server process can be removed at startup
+const containerPoolLabel = "go-tutorial.pool"
+
+// containerPool keeps idle, started sandbox containers ready so that a
+// request does not pay the container start-up cost. Every container serves a
+// single request and is removed afterwards.
+type containerPool struct {
+	kind  string
+	image string
+	args  []string
+	size  int
+
+	// maxWaiting bounds the requests waiting for a container; zero means
+	// no bound
+	maxWaiting int
+
+	// refillEvery is the pause between two container starts while refilling
+	refillEvery time.Duration
+
+	idle chan string
+
+	waiting  atomic.Int64
+	rejected atomic.Int64
+	started  atomic.Int64
+	failed   atomic.Int64
+}
+
+// ContainerPoolStats is reported by /api/metrics
+type ContainerPoolStats struct {
+	Size       int   `json:"size"`
+	Idle       int   `json:"idle"`
+	Waiting    int64 `json:"waiting"`
+	MaxWaiting int   `json:"max_waiting"`
+	Rejected   int64 `json:"rejected"`
+	Started    int64 `json:"started"`
+	Failed     int64 `json:"failed"`
+}
+
+// newContainerPool creates a pool of containers started from image with the
+// given docker run flags and begins filling it. A size of zero starts every
+// container on demand.
+func newContainerPool(kind, image string, args []string, cfg DockerPoolConfig) *containerPool {
+	p := &containerPool{
+		kind:        kind,
+		image:       image,
+		args:        args,
+		size:        cfg.Size,
+		maxWaiting:  max(cfg.MaxWaiting, 0),
+		refillEvery: time.Second,
+		idle:        make(chan string, max(cfg.Size, 0)),
+	}
+	if cfg.RefillPerSecond > 0 {
+		p.refillEvery = time.Second / time.Duration(cfg.RefillPerSecond)
+	}
+
+	p.removeStale()
+	if p.size > 0 {
+		go p.refill()
+	}
+	return p
+}
+
+// Get takes an idle container, waiting for one when the pool is empty. A
+// *BusyError is returned at once when too many requests are waiting.
+func (p *containerPool) Get(ctx context.Context) (string, error) {
+	if p.size <= 0 {
+		return p.start(ctx)
+	}
+
+	waiting := p.waiting.Add(1)
+	defer p.waiting.Add(-1)
+	if p.maxWaiting > 0 && waiting > int64(p.maxWaiting) {
+		p.rejected.Add(1)
+		return "", &BusyError{
+			Message:    "The server is busy, try again shortly",
+			RetryAfter: time.Duration(waiting) * p.refillEvery,
+		}
+	}
+
+	select {
+	case name := <-p.idle:
+		return name, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Discard removes a container once its request is done
+func (p *containerPool) Discard(name string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if out, err := exec.CommandContext(ctx, "docker", "rm", "-f", name).CombinedOutput(); err != nil {
+			log.Printf("⚠️  Failed to remove container %s: %v %s", name, err, strings.TrimSpace(string(out)))
+		}
+	}()
+}
+
+// Stats returns the pool's size and counters
+func (p *containerPool) Stats() ContainerPoolStats {
+	return ContainerPoolStats{
+		Size:       p.size,
+		Idle:       len(p.idle),
+		Waiting:    p.waiting.Load(),
+		MaxWaiting: p.maxWaiting,
+		Rejected:   p.rejected.Load(),
+		Started:    p.started.Load(),
+		Failed:     p.failed.Load(),
+	}
+}
+
+// refill starts containers at the configured rate whenever the pool has room
+func (p *containerPool) refill() {
+	failing := false
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		name, err := p.start(ctx)
+		cancel()
+
+		if err != nil {
+			// Log only the first of a run of failures, docker may be down
+			if !failing {
+				log.Printf("⚠️  Cannot refill %s container pool: %v", p.kind, err)
+			}
+			failing = true
+		} else {
+			if failing {
+				log.Printf("✅ %s container pool is refilling again", p.kind)
+			}
+			failing = false
+			// Blocks while the pool is full
+			p.idle <- name
+		}
+
+		time.Sleep(p.refillEvery)
+	}
+}
+
+// start runs a new idle container that waits for work. Every container runs
+// without network and capabilities, cannot gain privileges and has a
+// read-only root; the pool's args add the writable mounts it needs.
+func (p *containerPool) start(ctx context.Context) (string, error) {
+	name := fmt.Sprintf("go-exec-%s-%d", p.kind, time.Now().UnixNano())
+	args := []string{"run", "-d", "--rm", "--name", name, "--network", "none",
+		"--cap-drop", "ALL", "--security-opt", "no-new-privileges", "--read-only",
+		"--label", containerPoolLabel + "=" + p.kind}
+	args = append(args, p.args...)
+	args = append(args, "--entrypoint", "sleep", p.image, "infinity")
+
+	out, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput()
+	if err != nil {
+		p.failed.Add(1)
+		// A container may have been created even though starting it failed
+		p.Discard(name)
+		return "", fmt.Errorf("failed to start container: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	p.started.Add(1)
+	return name, nil
+}
+
+// removeStale removes idle containers of this kind left by an earlier server
+func (p *containerPool) removeStale() {
+	out, err := exec.Command("docker", "ps", "-aq", "--filter", "label="+containerPoolLabel+"="+p.kind).Output()
+	if err != nil {
+		return
+	}
+	if ids := strings.Fields(string(out)); len(ids) > 0 {
+		exec.Command("docker", append([]string{"rm", "-f"}, ids...)...).Run()
+		log.Printf("🧹 Removed %d stale %s containers", len(ids), p.kind)
+	}
+}
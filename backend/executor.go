@@ -1,111 +1,97 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"io"
-	"os"
-	"os/exec"
+	"log"
+	"runtime"
 	"time"
 )
 
-// CodeExecutionService handles secure Go code execution
-type CodeExecutionService struct {
-	dockerImage string
+// Executor runs learner code in a sandbox. Implementations must be safe for
+// concurrent use.
+type Executor interface {
+	// Name identifies the backend in logs and in /api/health
+	Name() string
+
+	// Execute runs the program in req, or its hidden tests when req.TestCode
+	// is set. Program output is forwarded to streams as it is produced;
+	// test runs are only reported once they finish. Cancelling ctx kills the
+	// program. Failures of the learner's program are reported in the
+	// response; the error is reserved for failures of the backend itself.
+	Execute(ctx context.Context, req *CodeExecutionRequest, streams *ExecutionStreams) (*CodeExecutionResponse, error)
+
+	// Check reports whether the backend is able to run code
+	Check(ctx context.Context) error
+
+	// Vet runs gofmt -d and go vet on the program in req without running
+	// it. Like Execute, it reports problems of the learner's code in the
+	// response.
+	Vet(ctx context.Context, req *CodeExecutionRequest) (*CheckResponse, error)
+
+	// Toolchains returns the Go releases the backend can build with
+	Toolchains(ctx context.Context) (*Toolchains, error)
 }
 
-// NewCodeExecutionService creates a new code execution service
-func NewCodeExecutionService() *CodeExecutionService {
-	return &CodeExecutionService{
-		dockerImage: "go-executor:latest",
-	}
+// MetricsReporter is implemented by backends with metrics of their own,
+// which /api/metrics reports next to the shared ones
+type MetricsReporter interface {
+	Metrics() any
 }
 
-// ExecuteCode runs Go code in a secure Docker container
-func (s *CodeExecutionService) ExecuteCode(code string) (*CodeExecutionResponse, error) {
-	// Create a context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Run the Docker container
-	cmd := exec.CommandContext(ctx, "docker", "run", "--rm", "-i", s.dockerImage)
-	
-	// Set up stdin to send the code
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create stdin pipe: %v", err)
-	}
-
-	// Set up stdout and stderr capture
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+// LimitsReporter is implemented by backends that cannot enforce some of the
+// configured ResourceLimits. UnenforcedLimits names the settings it ignores.
+type LimitsReporter interface {
+	UnenforcedLimits() []string
+}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start command: %v", err)
+// pipeWaitDelay bounds how long Wait waits for the output of a program
+// that was killed or has exited, which a child it forked may still hold
+// open. Without it a run could outlast its time limit and keep its slot.
+const pipeWaitDelay = 3 * time.Second
+
+// sandboxInitCommand is the hidden first argument that makes the server
+// binary act as the init process of the namespace sandbox
+const sandboxInitCommand = "__sandbox_init"
+
+// sandboxSetupExitCode is the exit status of a sandbox init that could not
+// build the sandbox
+const sandboxSetupExitCode = 125
+
+// NewExecutor creates the execution backend selected in the configuration.
+// cache may be nil to compile every request from scratch.
+func NewExecutor(cfg ExecutorConfig, cache *BuildCache) (Executor, error) {
+	switch cfg.Backend {
+	case "docker":
+		return NewDockerExecutor(cfg.DockerImage, cfg.Modules, cache, cfg.DockerPool, cfg.Limits, cfg.Toolchains.Default), nil
+	case "sandbox":
+		return newSandboxExecutor(cfg.Sandbox, cfg.Limits, cfg.Modules, cache, cfg.Toolchains)
+	case "local":
+		return NewLocalExecutor(cfg.Modules, cache, cfg.Limits, cfg.Toolchains), nil
+	case "fake":
+		return NewFakeExecutor(), nil
+	default:
+		return nil, fmt.Errorf("unknown executor backend %q", cfg.Backend)
 	}
+}
 
-	// Send the code to stdin
-	go func() {
-		defer stdin.Close()
-		io.WriteString(stdin, code)
-	}()
-
-	// Wait for completion
-	err = cmd.Wait()
-
-	// Prepare response
-	response := &CodeExecutionResponse{
-		Output: stdout.String(),
+// warnAboutExecutor logs loudly at startup what the backend does not
+// protect against, so that a weak setup is never mistaken for a safe one
+func warnAboutExecutor(e Executor) {
+	if e.Name() == "local" {
+		log.Printf("⚠️  WARNING: the local executor runs learner code on the host without any isolation; set EXECUTOR_BACKEND=sandbox or docker for anything but development")
 	}
-
-	// Handle errors
-	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			response.Error = "Execution timeout exceeded"
-		} else {
-			response.Error = fmt.Sprintf("Execution error: %v", err)
+	if reporter, ok := e.(LimitsReporter); ok {
+		for _, setting := range reporter.UnenforcedLimits() {
+			log.Printf("⚠️  WARNING: the %s executor cannot enforce %s on %s, programs are not limited by it", e.Name(), setting, runtime.GOOS)
 		}
-		response.Output += stderr.String()
 	}
-
-	return response, nil
 }
 
-// ExecuteCodeFallback provides a fallback execution method for development
-func (s *CodeExecutionService) ExecuteCodeFallback(code string) (*CodeExecutionResponse, error) {
-	// For development, we'll use a simple approach
-	// In production, this should always use Docker
-	
-	// Create a temporary file
-	tmpFile := "/tmp/go_code_" + fmt.Sprintf("%d", time.Now().UnixNano()) + ".go"
-	
-	// Write code to file
-	if err := os.WriteFile(tmpFile, []byte(code), 0644); err != nil {
-		return nil, fmt.Errorf("failed to write temp file: %v", err)
-	}
-	defer os.Remove(tmpFile)
-
-	// Execute with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "go", "run", tmpFile)
-	output, err := cmd.CombinedOutput()
-
-	response := &CodeExecutionResponse{
-		Output: string(output),
+// executionError describes why a run ended unsuccessfully
+func executionError(ctx context.Context, err error) string {
+	if ctx.Err() == context.DeadlineExceeded {
+		return "Execution timeout exceeded"
 	}
-
-	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			response.Error = "Execution timeout exceeded"
-		} else {
-			response.Error = fmt.Sprintf("Execution error: %v", err)
-		}
-	}
-
-	return response, nil
+	return fmt.Sprintf("Execution error: %v", err)
 }
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DockerExecutor compiles code in one throwaway container and runs the
+// binary in another, both built from docker/Dockerfile and taken from pools
+// of pre-started containers. No learner code runs in the compile container,
+// so it may share the warm GOCACHE; the run container only sees the binary.
+// Both run without network or capabilities, cannot gain privileges, and
+// can only write to their /tmp tmpfs and their input and output volumes.
+type DockerExecutor struct {
+	image   string
+	modules ModulesConfig
+	cache   *BuildCache
+	limits  ResourceLimits
+
+	buildPool *containerPool
+	runPool   *containerPool
+
+	imageIDOnce sync.Once
+	imageID     string
+
+	// The releases installed in the image, looked up on first use
+	defaultGoVersion string
+	toolchainsMu     sync.Mutex
+	toolchains       *Toolchains
+}
+
+// executorInput is the envelope copied into the container for the docker
+// execute helper, leaving the container's stdin to the program
+type executorInput struct {
+	Phase  string            `json:"phase"` // "build", "run", "check" or "toolchains"
+	Files  map[string]string `json:"files,omitempty"`
+	Tests  bool              `json:"tests,omitempty"`
+	GoRoot string            `json:"goroot,omitempty"` // release to build and run tests with
+	Limits helperLimits      `json:"limits"`
+}
+
+// helperLimits are the limits the execute helper applies to the program
+type helperLimits struct {
+	MemoryMB    int `json:"memory_mb"`
+	CPUSeconds  int `json:"cpu_seconds"`
+	WallSeconds int `json:"wall_seconds"`
+	OutputBytes int `json:"output_bytes"`
+}
+
+// Paths inside the containers. The input and output directories are
+// volumes, since docker cp cannot write to a read-only root or reach into a
+// tmpfs.
+const (
+	containerInputDir  = "/app/input"
+	containerOutputDir = "/app/output"
+	containerOutput    = containerOutputDir + "/prog"
+	containerModuleDir = "/app/modules"
+	containerGoCache   = "/app/gocache"
+)
+
+// Sizes of the writable /tmp of the containers. Builds keep the module and
+// the go command's work files there.
+const (
+	buildContainerTmpfs = "/tmp:rw,nosuid,size=256m"
+	runContainerTmpfs   = "/tmp:rw,nosuid,noexec,size=64m"
+)
+
+// NewDockerExecutor creates a docker backend using the given sandbox image
+// and starts filling its container pools. The module cache is mounted
+// read-only into every compile container. defaultGoVersion selects the
+// default among the image's Go releases; empty means the image's own.
+func NewDockerExecutor(image string, modules ModulesConfig, cache *BuildCache, pool DockerPoolConfig, limits ResourceLimits, defaultGoVersion string) *DockerExecutor {
+	e := &DockerExecutor{image: image, modules: modules, cache: cache, limits: limits, defaultGoVersion: defaultGoVersion}
+	e.buildPool = newContainerPool("build", image, e.buildArgs(), pool)
+	e.runPool = newContainerPool("run", image, e.runArgs(), pool)
+	return e
+}
+
+// Metrics implements MetricsReporter
+func (e *DockerExecutor) Metrics() any {
+	return map[string]ContainerPoolStats{
+		"build_pool": e.buildPool.Stats(),
+		"run_pool":   e.runPool.Stats(),
+	}
+}
+
+// Name implements Executor
+func (e *DockerExecutor) Name() string {
+	return "docker"
+}
+
+// Check implements Executor by making sure the sandbox image is available
+func (e *DockerExecutor) Check(ctx context.Context) error {
+	out, err := exec.CommandContext(ctx, "docker", "image", "inspect", "--format", "{{.Id}}", e.image).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("sandbox image %s unavailable: %v %s", e.image, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// helperEvent is one JSON line printed by the execute helper
+type helperEvent struct {
+	Event      string `json:"event"` // "compile", "output", "exit", "check" or "toolchains"
+	OK         bool   `json:"ok"`
+	Stream     string `json:"stream"`
+	Output     string `json:"output"`
+	TimeMs     int64  `json:"time_ms"`
+	DurationMs int64  `json:"duration_ms"`
+	ExitCode   *int   `json:"exit_code"`
+	Timeout    bool   `json:"timeout"`
+	Error      string `json:"error"`
+
+	PeakMemoryBytes int64 `json:"peak_memory_bytes"`
+	CPUTimeMs       int64 `json:"cpu_time_ms"`
+	Killed          bool  `json:"killed"`
+
+	Format     string      `json:"format"`
+	Toolchains []Toolchain `json:"toolchains"`
+}
+
+// Toolchains implements Executor by asking the execute helper in a compile
+// container which releases the image has. A failed lookup is retried on the
+// next call.
+func (e *DockerExecutor) Toolchains(ctx context.Context) (*Toolchains, error) {
+	e.toolchainsMu.Lock()
+	defer e.toolchainsMu.Unlock()
+
+	if e.toolchains != nil {
+		return e.toolchains, nil
+	}
+
+	container, err := e.buildPool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer e.buildPool.Discard(container)
+
+	jobDir, err := newDockerJobDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(jobDir)
+
+	var found []Toolchain
+	reported := false
+	result, err := e.execHelper(ctx, container, jobDir, executorInput{Phase: "toolchains"}, nil, func(event *helperEvent) {
+		if event.Event == "toolchains" {
+			found, reported = event.Toolchains, true
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !reported {
+		return nil, fmt.Errorf("execute helper failed: %v %s", result.err, strings.TrimSpace(result.stderr))
+	}
+
+	toolchains, err := newToolchains(found, e.defaultGoVersion)
+	if err != nil {
+		return nil, err
+	}
+	e.toolchains = toolchains
+	return toolchains, nil
+}
+
+// Execute implements Executor
+func (e *DockerExecutor) Execute(ctx context.Context, req *CodeExecutionRequest, streams *ExecutionStreams) (*CodeExecutionResponse, error) {
+	toolchains, err := e.Toolchains(ctx)
+	if err != nil {
+		return nil, err
+	}
+	toolchain, err := toolchains.Select(req.GoVersion)
+	if err != nil {
+		return nil, err
+	}
+	response, err := e.execute(ctx, req.withToolchain(toolchain), toolchain, streams)
+	if response != nil {
+		response.GoVersion = toolchain.Version
+	}
+	return response, err
+}
+
+// Vet implements Executor by running gofmt and go vet in a compile container
+func (e *DockerExecutor) Vet(ctx context.Context, req *CodeExecutionRequest) (*CheckResponse, error) {
+	toolchains, err := e.Toolchains(ctx)
+	if err != nil {
+		return nil, err
+	}
+	toolchain, err := toolchains.Select(req.GoVersion)
+	if err != nil {
+		return nil, err
+	}
+	files, err := req.withToolchain(toolchain).workspaceFiles()
+	if err != nil {
+		return nil, err
+	}
+	if rejected := e.modules.checkImports(files); rejected != nil {
+		return rejectedCheck(rejected), nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+
+	jobDir, err := newDockerJobDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(jobDir)
+
+	timedOut := &CheckResponse{Findings: []Finding{}, Error: "Check timeout exceeded", GoVersion: toolchain.Version}
+	container, err := e.buildPool.Get(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return timedOut, nil
+		}
+		return nil, err
+	}
+	defer e.buildPool.Discard(container)
+
+	var check *helperEvent
+	result, err := e.execHelper(ctx, container, jobDir, executorInput{Phase: "check", Files: files, GoRoot: toolchain.GoRoot}, nil, func(event *helperEvent) {
+		if event.Event == "check" {
+			check = event
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if check == nil {
+		if ctx.Err() != nil {
+			return timedOut, nil
+		}
+		return nil, fmt.Errorf("execute helper failed: %v %s", result.err, strings.TrimSpace(result.stderr))
+	}
+	if check.Timeout {
+		return timedOut, nil
+	}
+
+	response := newCheckResponse(files, check.Format, check.Output, !check.OK)
+	response.GoVersion = toolchain.Version
+	return response, nil
+}
+
+// execute builds the request with the given release in a compile container
+// and runs it in a run container
+func (e *DockerExecutor) execute(ctx context.Context, req *CodeExecutionRequest, toolchain Toolchain, streams *ExecutionStreams) (*CodeExecutionResponse, error) {
+	// Create a context with timeout that covers container start, compile and run
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second+e.limits.forTests().wallTime())
+	defer cancel()
+
+	files, err := req.workspaceFiles()
+	if err != nil {
+		return nil, err
+	}
+	if rejected := e.modules.checkImports(files); rejected != nil {
+		return rejected, nil
+	}
+
+	jobDir, err := newDockerJobDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(jobDir)
+
+	// The binary ends up in the input directory of the run container
+	tests := req.TestCode != ""
+	binary := filepath.Join(jobDir, "input", "prog")
+	key := e.cache.Key(e.cacheSalt(ctx, toolchain), files, tests)
+	compile := &helperEvent{OK: true}
+	cached := e.cache.Load(key, binary)
+	if !cached {
+		compile, err = e.compile(ctx, jobDir, files, tests, toolchain)
+		if err != nil {
+			return nil, err
+		}
+		if compile == nil {
+			return &CodeExecutionResponse{Error: executionError(ctx, ctx.Err())}, nil
+		}
+		if !compile.OK {
+			return compileFailure(&buildResult{
+				output:   compile.Output,
+				duration: time.Duration(compile.DurationMs) * time.Millisecond,
+				err:      errors.New("compilation failed"),
+				timedOut: compile.Timeout,
+			}), nil
+		}
+		if err := e.cache.Store(key, binary); err != nil {
+			log.Printf("⚠️  %v", err)
+		}
+	}
+
+	response, err := e.run(ctx, jobDir, req, toolchain, streams)
+	if err != nil {
+		return nil, err
+	}
+	if response == nil {
+		return &CodeExecutionResponse{Error: executionError(ctx, ctx.Err())}, nil
+	}
+	response.CompileOutput = compile.Output
+	response.CompileTimeMs = compile.DurationMs
+	response.Cached = cached
+	if !tests {
+		response.setPanic(files)
+	}
+
+	return response, nil
+}
+
+// compile builds the workspace in a compile container and copies the binary
+// out of it. It returns a nil event when ctx ended before the compiler
+// finished.
+func (e *DockerExecutor) compile(ctx context.Context, jobDir string, files map[string]string, tests bool, toolchain Toolchain) (*helperEvent, error) {
+	container, err := e.buildPool.Get(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer e.buildPool.Discard(container)
+
+	input := executorInput{Phase: "build", Files: files, Tests: tests, GoRoot: toolchain.GoRoot}
+	var compile *helperEvent
+	result, err := e.execHelper(ctx, container, jobDir, input, nil, func(event *helperEvent) {
+		if event.Event == "compile" {
+			compile = event
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if compile == nil {
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("execute helper failed: %v %s", result.err, strings.TrimSpace(result.stderr))
+	}
+
+	if compile.OK {
+		binary := filepath.Join(jobDir, "input", "prog")
+		out, err := exec.CommandContext(ctx, "docker", "cp", container+":"+containerOutput, binary).CombinedOutput()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("failed to copy binary out of container: %v %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	return compile, nil
+}
+
+// run executes the compiled binary in a run container. It returns a nil
+// response when ctx ended while waiting for a container.
+func (e *DockerExecutor) run(ctx context.Context, jobDir string, req *CodeExecutionRequest, toolchain Toolchain, streams *ExecutionStreams) (*CodeExecutionResponse, error) {
+	container, err := e.runPool.Get(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer e.runPool.Discard(container)
+
+	// Test output is only parsed once the run is over
+	tests := req.TestCode != ""
+	limits := e.limits
+	sink := streams.output()
+	if tests {
+		limits = limits.forTests()
+		sink = nil
+	}
+
+	// Removing the container is how a program over its output limit is
+	// stopped
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	var exit *helperEvent
+	output := newOutputCollector(sink, limits.outputBytes(), stop)
+	attach := func(cmd *exec.Cmd) error {
+		return attachStdin(cmd, req, streams)
+	}
+	input := executorInput{
+		Phase:  "run",
+		Tests:  tests,
+		GoRoot: toolchain.GoRoot,
+		Limits: helperLimits{
+			MemoryMB:    limits.MemoryMB,
+			CPUSeconds:  limits.CPUSeconds,
+			WallSeconds: limits.WallSeconds,
+			OutputBytes: limits.outputBytes(),
+		},
+	}
+	result, err := e.execHelper(runCtx, container, jobDir, input, attach, func(event *helperEvent) {
+		switch event.Event {
+		case "output":
+			output.writeAt(event.Stream, []byte(event.Output), time.Duration(event.TimeMs)*time.Millisecond)
+		case "exit":
+			exit = event
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if exit == nil {
+		// The container was killed while the program was running, or the
+		// helper never got to start it
+		if runCtx.Err() == nil && result.stderr != "" {
+			return nil, fmt.Errorf("execute helper failed: %v %s", result.err, strings.TrimSpace(result.stderr))
+		}
+		response := &CodeExecutionResponse{}
+		output.setOutput(response)
+		response.Error = executionError(ctx, result.err)
+		response.checkLimits(limits, runOutcome{
+			failed:         true,
+			outputExceeded: output.Exceeded(),
+			output:         response.Output,
+		})
+		return response, nil
+	}
+
+	var response *CodeExecutionResponse
+	if tests {
+		response = buildTestResponse(ctx, []byte(output.String()), "", exit.runError(), 0)
+	} else {
+		response = &CodeExecutionResponse{}
+		output.setOutput(response)
+		if exit.Error != "" {
+			response.Error = fmt.Sprintf("Execution error: %s", exit.Error)
+		}
+	}
+	response.RunTimeMs = exit.DurationMs
+	response.ExitCode = exit.ExitCode
+	if exit.Timeout {
+		response.Error = "Execution timeout exceeded"
+	}
+	response.checkLimits(limits, runOutcome{
+		usage: resourceUsage{
+			peakMemory: exit.PeakMemoryBytes,
+			cpuTime:    time.Duration(exit.CPUTimeMs) * time.Millisecond,
+		},
+		failed:         exit.Error != "" || exit.Timeout,
+		killed:         exit.Killed,
+		timedOut:       exit.Timeout,
+		outputExceeded: output.Exceeded(),
+		output:         response.Output,
+	})
+	return response, nil
+}
+
+// runError turns the error reported in an exit event back into an error
+func (e *helperEvent) runError() error {
+	if e.Error == "" {
+		return nil
+	}
+	return errors.New(e.Error)
+}
+
+// cacheSalt identifies the image and the release in it that built a binary,
+// together with the allowed modules
+func (e *DockerExecutor) cacheSalt(ctx context.Context, toolchain Toolchain) string {
+	e.imageIDOnce.Do(func() {
+		out, err := exec.CommandContext(ctx, "docker", "image", "inspect", "--format", "{{.Id}}", e.image).Output()
+		if err != nil {
+			// Fall back to the name; the cache is then not invalidated when
+			// the image is rebuilt
+			e.imageID = e.image
+			return
+		}
+		e.imageID = strings.TrimSpace(string(out))
+	})
+	return strings.Join([]string{e.imageID, toolchain.Version, strings.Join(e.modules.Allowed, ",")}, "\x00")
+}
+
+// containerResult holds what the execute helper printed on stderr and how
+// it exited
+type containerResult struct {
+	stderr string
+	err    error
+}
+
+// execHelper copies the job's input directory into the container, runs the
+// execute helper there and calls onEvent for every event it prints, as soon
+// as it is printed. attach, when set, connects the helper's stdin. The
+// returned error is set only if the helper could not be started at all.
+func (e *DockerExecutor) execHelper(ctx context.Context, container, jobDir string, input executorInput, attach func(*exec.Cmd) error, onEvent func(*helperEvent)) (*containerResult, error) {
+	payload, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode input: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(jobDir, "input", "request.json"), payload, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write input: %v", err)
+	}
+	out, err := exec.CommandContext(ctx, "docker", "cp", filepath.Join(jobDir, "input")+"/.", container+":"+containerInputDir).CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			return &containerResult{err: ctx.Err()}, nil
+		}
+		return nil, fmt.Errorf("failed to copy input into container: %v %s", err, strings.TrimSpace(string(out)))
+	}
+
+	cmd := exec.CommandContext(ctx, "docker", "exec", "-i", container, "/app/execute")
+	// Killing the docker client does not stop the helper; removing the
+	// container does
+	cmd.Cancel = func() error {
+		exec.Command("docker", "rm", "-f", container).Run()
+		return cmd.Process.Kill()
+	}
+	cmd.WaitDelay = pipeWaitDelay
+	if attach != nil {
+		if err := attach(cmd); err != nil {
+			return nil, err
+		}
+	}
+
+	// Events are read from stdout while the helper runs
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	// Start the command
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start command: %v", err)
+	}
+
+	decoder := json.NewDecoder(stdout)
+	for {
+		event := &helperEvent{}
+		if err := decoder.Decode(event); err != nil {
+			break
+		}
+		onEvent(event)
+	}
+	// Drain anything after a malformed line so the helper never blocks
+	io.Copy(io.Discard, stdout)
+
+	// Wait for completion
+	err = cmd.Wait()
+
+	return &containerResult{stderr: stderr.String(), err: err}, nil
+}
+
+// newDockerJobDir creates the host directory of one execution. Its input
+// directory is copied into each container and receives the binary from the
+// compile container.
+func newDockerJobDir() (string, error) {
+	dir, err := os.MkdirTemp("", "go_exec_")
+	if err != nil {
+		return "", fmt.Errorf("failed to create job dir: %v", err)
+	}
+	for _, sub := range []string{"", "input"} {
+		// The run container's helper is an unprivileged user
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("failed to create job dir: %v", err)
+		}
+		if err := os.Chmod(filepath.Join(dir, sub), 0755); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("failed to prepare job dir: %v", err)
+		}
+	}
+	return dir, nil
+}
+
+// runArgs returns the docker run flags of a run container. The container
+// limits back up the rlimits the helper applies to the program; the
+// process limit leaves room for the helper itself.
+func (e *DockerExecutor) runArgs() []string {
+	args := []string{"--tmpfs", runContainerTmpfs, "-v", containerInputDir}
+	if e.limits.MemoryMB > 0 {
+		memory := fmt.Sprintf("%dm", e.limits.MemoryMB+64)
+		args = append(args, "--memory", memory, "--memory-swap", memory)
+	}
+	if e.limits.Processes > 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(e.limits.Processes+32))
+	}
+	if e.limits.CPUs > 0 {
+		args = append(args, "--cpus", strconv.Itoa(e.limits.CPUs))
+	}
+	return args
+}
+
+// buildArgs returns the docker run flags of a compile container. It runs as
+// the server's user so that the shared GOCACHE stays owned by it, and reads
+// modules from the read-only module cache mount.
+func (e *DockerExecutor) buildArgs() []string {
+	args := []string{
+		"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		"-e", "HOME=/tmp",
+		"--tmpfs", buildContainerTmpfs,
+		"-v", containerInputDir,
+		"-v", containerOutputDir,
+	}
+	if dir, err := filepath.Abs(e.modules.Dir); err == nil {
+		if _, err := os.Stat(dir); err == nil {
+			args = append(args, "-v", dir+":"+containerModuleDir+":ro")
+		}
+	}
+	for _, env := range (ModulesConfig{Dir: containerModuleDir}).buildEnv() {
+		args = append(args, "-e", env)
+	}
+	if goCache := e.cache.GoCacheDir(); goCache != "" {
+		args = append(args, "-v", goCache+":"+containerGoCache, "-e", "GOCACHE="+containerGoCache)
+	}
+	return args
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// FakeExecutor is an in-process backend that never runs code. It records the
+// requests it receives and answers them with Respond, which makes it useful
+// for tests and for working on the frontend without a go toolchain.
+type FakeExecutor struct {
+	// Respond produces the response for a request. When nil every request
+	// succeeds with empty output.
+	Respond func(req *CodeExecutionRequest) (*CodeExecutionResponse, error)
+
+	mu       sync.Mutex
+	requests []CodeExecutionRequest
+}
+
+// NewFakeExecutor creates a fake backend that succeeds with empty output
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{}
+}
+
+// Name implements Executor
+func (e *FakeExecutor) Name() string {
+	return "fake"
+}
+
+// Check implements Executor; the fake backend is always available
+func (e *FakeExecutor) Check(ctx context.Context) error {
+	return nil
+}
+
+// Toolchains implements Executor; the fake backend claims the release the
+// server was built with
+func (e *FakeExecutor) Toolchains(ctx context.Context) (*Toolchains, error) {
+	return newToolchains([]Toolchain{{Version: runtime.Version()}}, "")
+}
+
+// Vet implements Executor; the fake backend finds nothing
+func (e *FakeExecutor) Vet(ctx context.Context, req *CodeExecutionRequest) (*CheckResponse, error) {
+	return &CheckResponse{Formatted: true, VetClean: true, Findings: []Finding{}}, nil
+}
+
+// Execute implements Executor
+func (e *FakeExecutor) Execute(ctx context.Context, req *CodeExecutionRequest, streams *ExecutionStreams) (*CodeExecutionResponse, error) {
+	e.mu.Lock()
+	e.requests = append(e.requests, *req)
+	e.mu.Unlock()
+
+	response := &CodeExecutionResponse{}
+	if e.Respond != nil {
+		var err error
+		if response, err = e.Respond(req); err != nil {
+			return nil, err
+		}
+	}
+
+	// Replay the canned output to a live client as a single chunk
+	if sink := streams.output(); sink != nil && response.Output != "" {
+		sink(OutputChunk{Stream: "stdout", Data: response.Output})
+	}
+	return response, nil
+}
+
+// Requests returns a copy of every request executed so far
+func (e *FakeExecutor) Requests() []CodeExecutionRequest {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]CodeExecutionRequest(nil), e.requests...)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// LocalExecutor runs code with the go toolchain on the host. It offers no
+// isolation and is meant for development only.
+type LocalExecutor struct {
+	hostBuilder
+	limits ResourceLimits
+}
+
+// NewLocalExecutor creates a backend that runs code on the host
+func NewLocalExecutor(modules ModulesConfig, cache *BuildCache, limits ResourceLimits, toolchains ToolchainConfig) *LocalExecutor {
+	return &LocalExecutor{hostBuilder: hostBuilder{modules: modules, cache: cache, toolchainConfig: toolchains}, limits: limits}
+}
+
+// Name implements Executor
+func (e *LocalExecutor) Name() string {
+	return "local"
+}
+
+// UnenforcedLimits implements LimitsReporter. The local backend has no way
+// to cap processes or CPUs, and only limits memory and CPU time where
+// limitProcess can.
+func (e *LocalExecutor) UnenforcedLimits() []string {
+	var settings []string
+	if !canLimitProcess && e.limits.MemoryMB > 0 {
+		settings = append(settings, "LIMIT_MEMORY_MB")
+	}
+	if !canLimitProcess && e.limits.CPUSeconds > 0 {
+		settings = append(settings, "LIMIT_CPU_SECONDS")
+	}
+	if e.limits.Processes > 0 {
+		settings = append(settings, "LIMIT_PROCESSES")
+	}
+	if e.limits.CPUs > 0 {
+		settings = append(settings, "LIMIT_CPUS")
+	}
+	return settings
+}
+
+// Check implements Executor by making sure the Go toolchains still run
+func (e *LocalExecutor) Check(ctx context.Context) error {
+	return e.checkToolchains(ctx)
+}
+
+// Execute implements Executor
+func (e *LocalExecutor) Execute(ctx context.Context, req *CodeExecutionRequest, streams *ExecutionStreams) (*CodeExecutionResponse, error) {
+	toolchain, err := e.toolchain(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	response, err := e.execute(ctx, req.withToolchain(toolchain), toolchain, streams)
+	if response != nil {
+		response.GoVersion = toolchain.Version
+	}
+	return response, err
+}
+
+// execute builds and runs the request with the given release
+func (e *LocalExecutor) execute(ctx context.Context, req *CodeExecutionRequest, toolchain Toolchain, streams *ExecutionStreams) (*CodeExecutionResponse, error) {
+	files, err := req.workspaceFiles()
+	if err != nil {
+		return nil, err
+	}
+	if rejected := e.modules.checkImports(files); rejected != nil {
+		return rejected, nil
+	}
+
+	tmpDir, err := os.MkdirTemp("", "go_code_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// The binary is kept out of the source tree, where it could clash with
+	// one of the learner's files
+	srcDir := filepath.Join(tmpDir, "src")
+	if err := writeWorkspace(srcDir, files); err != nil {
+		return nil, err
+	}
+
+	tests := req.TestCode != ""
+	binary := filepath.Join(tmpDir, "prog")
+	build := e.build(ctx, toolchain, files, srcDir, binary, tests)
+	if build.err != nil {
+		return compileFailure(build), nil
+	}
+
+	var response *CodeExecutionResponse
+	if tests {
+		response, err = e.runTests(ctx, srcDir, binary, toolchain)
+	} else {
+		response, err = e.run(ctx, srcDir, binary, req, streams)
+	}
+	if err != nil {
+		return nil, err
+	}
+	response.CompileOutput = build.output
+	response.CompileTimeMs = build.duration.Milliseconds()
+	response.Cached = build.cached
+	if !tests {
+		response.setPanic(files)
+	}
+
+	return response, nil
+}
+
+// run executes the compiled program under the resource limits
+func (e *LocalExecutor) run(ctx context.Context, dir, binary string, req *CodeExecutionRequest, streams *ExecutionStreams) (*CodeExecutionResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.limits.wallTime())
+	defer cancel()
+
+	output := newOutputCollector(streams.output(), e.limits.outputBytes(), cancel)
+	cmd := exec.CommandContext(ctx, binary)
+	cmd.WaitDelay = pipeWaitDelay
+	cmd.Dir = dir
+	cmd.Stdout = output.Stream("stdout")
+	cmd.Stderr = output.Stream("stderr")
+	if err := attachStdin(cmd, req, streams); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start program: %v", err)
+	}
+	if err := limitProcess(cmd.Process.Pid, e.limits); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("failed to limit program: %v", err)
+	}
+	err := cmd.Wait()
+
+	response := &CodeExecutionResponse{}
+	output.setOutput(response)
+	response.setRunResult(ctx, err, time.Since(start))
+
+	outcome := outcomeOf(ctx, err, cmd.ProcessState)
+	outcome.outputExceeded = output.Exceeded()
+	outcome.output = response.Output
+	response.checkLimits(e.limits, outcome)
+
+	return response, nil
+}
+
+// runTests executes the compiled test binary under the resource limits and
+// converts its output to the go test -json format
+func (e *LocalExecutor) runTests(ctx context.Context, dir, binary string, toolchain Toolchain) (*CodeExecutionResponse, error) {
+	limits := e.limits.forTests()
+	ctx, cancel := context.WithTimeout(ctx, limits.wallTime())
+	defer cancel()
+
+	raw := newOutputCollector(nil, limits.outputBytes(), cancel)
+	stderr := newOutputCollector(nil, limits.outputBytes(), cancel)
+	cmd := exec.CommandContext(ctx, binary, "-test.v=test2json")
+	cmd.WaitDelay = pipeWaitDelay
+	cmd.Dir = dir
+	cmd.Stdout = raw.Stream("stdout")
+	cmd.Stderr = stderr.Stream("stderr")
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start tests: %v", err)
+	}
+	if err := limitProcess(cmd.Process.Pid, limits); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("failed to limit tests: %v", err)
+	}
+	runErr := cmd.Wait()
+	duration := time.Since(start)
+
+	stream, err := convertTestOutput(toolchain, raw.String())
+	if err != nil {
+		return nil, err
+	}
+
+	response := buildTestResponse(ctx, stream, stderr.String(), runErr, duration)
+	outcome := outcomeOf(ctx, runErr, cmd.ProcessState)
+	outcome.outputExceeded = raw.Exceeded() || stderr.Exceeded()
+	outcome.output = response.Output
+	response.checkLimits(limits, outcome)
+	return response, nil
+}
@@ -0,0 +1,688 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FormatRequest carries the source to format, either a single main.go in
+// Code or the files of a module, like an execution request
+type FormatRequest struct {
+	Code  string            `json:"code"`
+	Files map[string]string `json:"files,omitempty"`
+}
+
+// FormatResponse holds the formatted source in the shape it was sent in.
+// Code that does not parse is returned with an error and its position
+// instead.
+type FormatResponse struct {
+	Code    string            `json:"code,omitempty"`
+	Files   map[string]string `json:"files,omitempty"`
+	Changed bool              `json:"changed"`
+
+	// Warning tells that missing imports could not be added
+	Warning string `json:"warning,omitempty"`
+
+	Error       string       `json:"error,omitempty"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+}
+
+// Global formatter behind the format endpoint
+var formatter *Formatter
+
+// formatCode handles requests to format code. It runs in the server process
+// and does not wait for an execution slot, so editors can call it on every
+// save.
+func formatCode(c *gin.Context) {
+	var req FormatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	files, err := (&CodeExecutionRequest{Code: req.Code, Files: req.Files}).workspaceFiles()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	formatted, diagnostics := formatter.Format(files)
+	if len(diagnostics) > 0 {
+		c.JSON(http.StatusOK, FormatResponse{Error: "Syntax error", Diagnostics: diagnostics})
+		return
+	}
+
+	response := FormatResponse{}
+	if err := formatter.Check(); err != nil {
+		response.Warning = "Missing imports cannot be added: " + err.Error()
+	}
+	if req.Code != "" {
+		response.Code = formatted["main.go"]
+		response.Changed = response.Code != req.Code
+	}
+	if len(req.Files) > 0 {
+		response.Files = make(map[string]string, len(req.Files))
+		for name, content := range req.Files {
+			if result, ok := formatted[name]; ok {
+				content = result
+			}
+			response.Files[name] = content
+			response.Changed = response.Changed || content != req.Files[name]
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Formatter formats Go files like gofmt and fixes their imports like
+// goimports: unused imports are removed, and missing ones are added from the
+// module's own packages, the standard library and the allowed modules
+type Formatter struct {
+	goroot  string
+	modules []string
+
+	// stdPackages maps a package name to the standard library packages of
+	// that name, best candidate first; stdNames maps their paths back
+	indexOnce   sync.Once
+	stdPackages map[string][]string
+	stdNames    map[string]string
+	stdErr      error
+
+	exportsMu sync.Mutex
+	exports   map[string]map[string]bool
+}
+
+// preferredStdPackages win over other standard library packages of the same
+// name that export the same symbols
+var preferredStdPackages = map[string]bool{
+	"math/rand":     true,
+	"text/template": true,
+}
+
+// NewFormatter creates a formatter that finds the standard library in the
+// GOROOT the server runs with
+func NewFormatter(modules ModulesConfig) *Formatter {
+	return &Formatter{
+		goroot:  build.Default.GOROOT,
+		modules: modules.modulePaths(),
+		exports: make(map[string]map[string]bool),
+	}
+}
+
+// Check reports why the standard library could not be indexed, in which
+// case no missing standard library import is added
+func (f *Formatter) Check() error {
+	f.indexStd()
+	return f.stdErr
+}
+
+// Format formats every Go file of a workspace and fixes its imports. Files
+// that do not parse are reported as diagnostics and nothing is formatted.
+func (f *Formatter) Format(files map[string]string) (map[string]string, []Diagnostic) {
+	fset := token.NewFileSet()
+	parsed := make(map[string]*ast.File)
+	var diagnostics []Diagnostic
+	for _, name := range goFileNames(files) {
+		file, err := parser.ParseFile(fset, name, files[name], parser.ParseComments)
+		if err != nil {
+			diagnostics = append(diagnostics, parseErrorDiagnostics(err)...)
+			continue
+		}
+		parsed[name] = file
+	}
+	if len(diagnostics) > 0 {
+		return nil, diagnostics
+	}
+
+	// Names declared at the top level of each package directory, which
+	// selectors in other files of the package may refer to, and the names of
+	// the module's own packages
+	declared := make(map[string]map[string]bool)
+	localPackages := make(map[string]string)
+	for name, file := range parsed {
+		dir := path.Dir(name)
+		if declared[dir] == nil {
+			declared[dir] = make(map[string]bool)
+		}
+		for _, decl := range file.Decls {
+			for _, ident := range declaredNames(decl) {
+				declared[dir][ident] = true
+			}
+		}
+		if !strings.HasSuffix(name, "_test.go") {
+			localPackages[dir] = file.Name.Name
+		}
+	}
+
+	imports := &importResolver{
+		formatter:     f,
+		modulePath:    workspaceModulePath(files["go.mod"]),
+		localPackages: localPackages,
+	}
+	formatted := make(map[string]string, len(parsed))
+	for name, file := range parsed {
+		src := imports.fix(fset, file, files[name], declared[path.Dir(name)])
+		out, err := format.Source([]byte(src))
+		if err != nil {
+			// The import fixes broke the file; format it as it was sent
+			log.Printf("⚠️  Fixing imports of %s failed: %v", name, err)
+			if out, err = format.Source([]byte(files[name])); err != nil {
+				return nil, parseErrorDiagnostics(err)
+			}
+		}
+		formatted[name] = string(out)
+	}
+	return formatted, nil
+}
+
+// parseErrorDiagnostics converts the errors of the parser
+func parseErrorDiagnostics(err error) []Diagnostic {
+	list, ok := err.(scanner.ErrorList)
+	if !ok {
+		return []Diagnostic{{Message: err.Error()}}
+	}
+	diagnostics := make([]Diagnostic, 0, len(list))
+	for _, e := range list {
+		diagnostics = append(diagnostics, Diagnostic{
+			File:    e.Pos.Filename,
+			Line:    e.Pos.Line,
+			Column:  e.Pos.Column,
+			Message: e.Msg,
+		})
+	}
+	return diagnostics
+}
+
+// declaredNames returns the names a top-level declaration introduces
+func declaredNames(decl ast.Decl) []string {
+	var names []string
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		if decl.Recv == nil {
+			names = append(names, decl.Name.Name)
+		}
+	case *ast.GenDecl:
+		for _, spec := range decl.Specs {
+			switch spec := spec.(type) {
+			case *ast.TypeSpec:
+				names = append(names, spec.Name.Name)
+			case *ast.ValueSpec:
+				for _, ident := range spec.Names {
+					names = append(names, ident.Name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// importResolver decides which package an import name refers to
+type importResolver struct {
+	formatter     *Formatter
+	modulePath    string
+	localPackages map[string]string // package name by directory
+}
+
+// packageName returns the name a package is imported under by default
+func (r *importResolver) packageName(importPath string) string {
+	if dir, ok := strings.CutPrefix(importPath, r.modulePath+"/"); ok && r.modulePath != "" {
+		if name, ok := r.localPackages[dir]; ok {
+			return name
+		}
+	}
+	if name, ok := r.formatter.stdPackageName(importPath); ok {
+		return name
+	}
+
+	// Like goimports, guess the name of other packages from their path, as
+	// in gopkg.in/yaml.v3 or github.com/mattn/go-sqlite3
+	name := path.Base(importPath)
+	if major := strings.TrimPrefix(name, "v"); major != name && isDigits(major) {
+		name = path.Base(path.Dir(importPath))
+	}
+	name, _, _ = strings.Cut(name, ".")
+	name = strings.TrimPrefix(name, "go-")
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+// isDigits reports whether s is a non-empty run of decimal digits
+func isDigits(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+// find returns the import path of a package named name that exports every
+// symbol in symbols, preferring the module's own packages, then the
+// standard library, then the allowed modules
+func (r *importResolver) find(name string, symbols map[string]bool, fromDir string) string {
+	if r.modulePath != "" {
+		dirs := make([]string, 0, len(r.localPackages))
+		for dir, pkg := range r.localPackages {
+			if pkg == name && dir != fromDir && dir != "." {
+				dirs = append(dirs, dir)
+			}
+		}
+		sort.Strings(dirs)
+		if len(dirs) > 0 {
+			return r.modulePath + "/" + dirs[0]
+		}
+	}
+	for _, candidate := range r.formatter.stdCandidates(name) {
+		if r.formatter.exportsAll(candidate, symbols) {
+			return candidate
+		}
+	}
+	for _, module := range r.formatter.modules {
+		if r.packageName(module) == name {
+			return module
+		}
+	}
+	return ""
+}
+
+// fix removes the unused imports of a file and adds the missing ones. File
+// is the parsed src; declared holds the names declared in its package. The
+// source is edited as text, so that everything else is kept as written.
+func (r *importResolver) fix(fset *token.FileSet, file *ast.File, src string, declared map[string]bool) string {
+	// Selectors on names that are declared nowhere refer to packages
+	refs := make(map[string]map[string]bool)
+	ast.Inspect(file, func(node ast.Node) bool {
+		selector, ok := node.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if ident, ok := selector.X.(*ast.Ident); ok && ident.Obj == nil && !declared[ident.Name] {
+			if refs[ident.Name] == nil {
+				refs[ident.Name] = make(map[string]bool)
+			}
+			refs[ident.Name][selector.Sel.Name] = true
+		}
+		return true
+	})
+
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+
+	// Find the imports nothing refers to
+	imported := make(map[string]bool)
+	var importDecls []*ast.GenDecl
+	unused := make(map[*ast.GenDecl][]*ast.ImportSpec)
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ast.GenDecl)
+		if !ok || decl.Tok != token.IMPORT {
+			continue
+		}
+		importDecls = append(importDecls, decl)
+		for _, spec := range decl.Specs {
+			spec := spec.(*ast.ImportSpec)
+			importPath, _ := strconv.Unquote(spec.Path.Value)
+			name := r.packageName(importPath)
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			if name == "_" || name == "." || importPath == "C" || refs[name] != nil {
+				imported[name] = true
+				continue
+			}
+			unused[decl] = append(unused[decl], spec)
+		}
+	}
+
+	// Find the missing ones
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var added []string
+	for _, name := range names {
+		if imported[name] {
+			continue
+		}
+		if importPath := r.find(name, refs[name], path.Dir(fset.Position(file.Package).Filename)); importPath != "" {
+			added = append(added, strconv.Quote(importPath))
+		}
+	}
+	if len(added) == 0 && len(unused) == 0 {
+		return src
+	}
+
+	specText := func(spec *ast.ImportSpec) string {
+		end := spec.End()
+		if spec.Comment != nil {
+			end = spec.Comment.End()
+		}
+		return src[offset(spec.Pos()):offset(end)]
+	}
+	remaining := func(decl *ast.GenDecl) []*ast.ImportSpec {
+		var kept []*ast.ImportSpec
+		for _, spec := range decl.Specs {
+			if !slices.Contains(unused[decl], spec.(*ast.ImportSpec)) {
+				kept = append(kept, spec.(*ast.ImportSpec))
+			}
+		}
+		return kept
+	}
+
+	// Added imports go into the first parenthesized import declaration, the
+	// standard library ones first and the others last, or into a new
+	// declaration replacing the first single import or after the package
+	// clause
+	var edits []sourceEdit
+	var target *ast.GenDecl
+	if len(added) > 0 {
+		for _, decl := range importDecls {
+			if decl.Lparen.IsValid() {
+				target = decl
+				break
+			}
+		}
+		if target == nil && len(importDecls) > 0 {
+			target = importDecls[0]
+		}
+
+		var kept []*ast.ImportSpec
+		if target != nil {
+			kept = remaining(target)
+		}
+		switch {
+		case target == nil:
+			pos := offset(file.Name.End())
+			edits = append(edits, sourceEdit{pos, pos, "\n\n" + r.importDecl(nil, added)})
+		case !target.Lparen.IsValid() || len(kept)+len(added) == 1:
+			specs := make([]string, 0, len(kept))
+			for _, spec := range kept {
+				specs = append(specs, specText(spec))
+			}
+			edits = append(edits, sourceEdit{offset(target.Pos()), offset(target.End()), r.importDecl(specs, added)})
+			delete(unused, target)
+		default:
+			std, other := r.splitStdImports(added)
+			keptStd, keptOther := false, false
+			for _, spec := range kept {
+				if r.isStdImport(spec.Path.Value) {
+					keptStd = true
+				} else {
+					keptOther = true
+				}
+			}
+			if len(std) > 0 {
+				pos := offset(target.Lparen) + 1
+				edits = append(edits, sourceEdit{pos, pos, "\n\t" + strings.Join(std, "\n\t")})
+			}
+			if len(other) > 0 {
+				text := "\t" + strings.Join(other, "\n\t") + "\n"
+				if (keptStd || len(std) > 0) && !keptOther {
+					text = "\n" + text
+				}
+				pos := offset(target.Rparen)
+				if strings.TrimSpace(src[lineStart(src, pos):pos]) == "" {
+					pos = lineStart(src, pos)
+				} else {
+					text = "\n" + text
+				}
+				edits = append(edits, sourceEdit{pos, pos, text})
+			}
+		}
+	}
+
+	// Remove the unused imports and the declarations they leave empty,
+	// unless the added imports go there. A parenthesized declaration left
+	// with one import becomes a single import.
+	for decl, specs := range unused {
+		kept := remaining(decl)
+		switch {
+		case len(kept) == 0 && decl != target:
+			edits = append(edits, removeLines(src, offset(decl.Pos()), offset(decl.End())))
+			continue
+		case len(kept) == 1 && decl != target && decl.Lparen.IsValid() && kept[0].Doc == nil:
+			edits = append(edits, sourceEdit{offset(decl.Pos()), offset(decl.End()), "import " + specText(kept[0])})
+			continue
+		}
+		for _, spec := range specs {
+			start, end := spec.Pos(), spec.End()
+			if spec.Doc != nil {
+				start = spec.Doc.Pos()
+			}
+			if spec.Comment != nil {
+				end = spec.Comment.End()
+			}
+			edits = append(edits, removeLines(src, offset(start), offset(end)))
+		}
+	}
+	return applySourceEdits(src, edits)
+}
+
+// importDecl writes an import declaration of the kept import specs and the
+// added import paths, with the standard library group first
+func (r *importResolver) importDecl(kept, added []string) string {
+	std, other := r.splitStdImports(added)
+	for _, spec := range kept {
+		_, importPath, _ := strings.Cut(spec, `"`)
+		if r.isStdImport(`"` + importPath) {
+			std = append(std, spec)
+		} else {
+			other = append(other, spec)
+		}
+	}
+	if len(std)+len(other) == 1 {
+		return "import " + strings.Join(append(std, other...), "")
+	}
+
+	var groups []string
+	for _, group := range [][]string{std, other} {
+		if len(group) > 0 {
+			groups = append(groups, "\t"+strings.Join(group, "\n\t"))
+		}
+	}
+	return "import (\n" + strings.Join(groups, "\n\n") + "\n)"
+}
+
+// splitStdImports separates quoted standard library import paths from the
+// others
+func (r *importResolver) splitStdImports(paths []string) (std, other []string) {
+	for _, importPath := range paths {
+		if r.isStdImport(importPath) {
+			std = append(std, importPath)
+		} else {
+			other = append(other, importPath)
+		}
+	}
+	return std, other
+}
+
+// isStdImport reports whether a quoted import path belongs to the standard
+// library. Without the sources of the standard library, paths whose first
+// element has no dot are taken to belong to it.
+func (r *importResolver) isStdImport(quoted string) bool {
+	importPath, _ := strconv.Unquote(quoted)
+	if _, ok := r.formatter.stdPackageName(importPath); ok {
+		return true
+	}
+	if r.modulePath != "" && (importPath == r.modulePath || strings.HasPrefix(importPath, r.modulePath+"/")) {
+		return false
+	}
+	first, _, _ := strings.Cut(importPath, "/")
+	return len(r.formatter.stdNames) == 0 && !strings.Contains(first, ".")
+}
+
+// sourceEdit replaces src[start:end] with text
+type sourceEdit struct {
+	start, end int
+	text       string
+}
+
+// applySourceEdits applies edits that do not overlap, except for empty ones
+func applySourceEdits(src string, edits []sourceEdit) string {
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	for _, edit := range edits {
+		src = src[:edit.start] + edit.text + src[edit.end:]
+	}
+	return src
+}
+
+// removeLines returns the edit removing src[start:end], together with the
+// lines it is on when nothing else is on them
+func removeLines(src string, start, end int) sourceEdit {
+	lineStart := strings.LastIndexByte(src[:start], '\n') + 1
+	lineEnd := len(src)
+	if next := strings.IndexByte(src[end:], '\n'); next >= 0 {
+		lineEnd = end + next + 1
+	}
+	if strings.TrimSpace(src[lineStart:start]) != "" || strings.TrimSpace(src[end:lineEnd]) != "" {
+		return sourceEdit{start, end, ""}
+	}
+	return sourceEdit{lineStart, lineEnd, ""}
+}
+
+// lineStart returns the offset of the line that offset is on
+func lineStart(src string, offset int) int {
+	return strings.LastIndexByte(src[:offset], '\n') + 1
+}
+
+// indexStd lists the packages of the standard library once, from the
+// sources in GOROOT
+func (f *Formatter) indexStd() {
+	f.indexOnce.Do(func() {
+		f.stdPackages = make(map[string][]string)
+		f.stdNames = make(map[string]string)
+		root := filepath.Join(f.goroot, "src")
+		err := filepath.WalkDir(root, func(dir string, entry os.DirEntry, err error) error {
+			if err != nil {
+				// A missing root ends the walk; unreadable directories below it
+				// are skipped
+				if dir == root {
+					return err
+				}
+				return nil
+			}
+			if !entry.IsDir() {
+				return nil
+			}
+			rel, _ := filepath.Rel(root, dir)
+			rel = filepath.ToSlash(rel)
+			switch base := entry.Name(); {
+			case rel == "cmd" || rel == "builtin" || base == "internal" || base == "vendor" || base == "testdata":
+				return filepath.SkipDir
+			case rel == ".":
+				return nil
+			}
+			if name := stdPackageClause(dir); name != "" && name != "main" {
+				f.stdPackages[name] = append(f.stdPackages[name], rel)
+				f.stdNames[rel] = name
+			}
+			return nil
+		})
+		if err == nil && len(f.stdNames) == 0 {
+			err = fmt.Errorf("no packages in %s", root)
+		}
+		if err != nil {
+			f.stdErr = fmt.Errorf("standard library not found: %v", err)
+			return
+		}
+
+		for _, paths := range f.stdPackages {
+			sort.Slice(paths, func(i, j int) bool {
+				a, b := paths[i], paths[j]
+				if depthA, depthB := strings.Count(a, "/"), strings.Count(b, "/"); depthA != depthB {
+					return depthA < depthB
+				}
+				if preferredStdPackages[a] != preferredStdPackages[b] {
+					return preferredStdPackages[a]
+				}
+				return a < b
+			})
+		}
+	})
+}
+
+// stdPackageClause returns the package name of the first non-test file in
+// dir, or "" when it has none
+func stdPackageClause(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, name), nil, parser.PackageClauseOnly)
+		if err == nil && file.Name.Name != "documentation" {
+			return file.Name.Name
+		}
+	}
+	return ""
+}
+
+// stdPackageName returns the name of a standard library package
+func (f *Formatter) stdPackageName(importPath string) (string, bool) {
+	f.indexStd()
+	name, ok := f.stdNames[importPath]
+	return name, ok
+}
+
+// stdCandidates returns the standard library packages named name
+func (f *Formatter) stdCandidates(name string) []string {
+	f.indexStd()
+	return f.stdPackages[name]
+}
+
+// exportsAll reports whether a standard library package exports every
+// symbol in symbols. The exports of a package are read from its sources
+// the first time they are needed.
+func (f *Formatter) exportsAll(importPath string, symbols map[string]bool) bool {
+	f.exportsMu.Lock()
+	exports, ok := f.exports[importPath]
+	if !ok {
+		exports = readExports(filepath.Join(f.goroot, "src", filepath.FromSlash(importPath)), f.stdNames[importPath])
+		f.exports[importPath] = exports
+	}
+	f.exportsMu.Unlock()
+
+	for symbol := range symbols {
+		if !exports[symbol] {
+			return false
+		}
+	}
+	return true
+}
+
+// readExports collects the exported top-level names of the package named
+// name in dir, across the files for every platform
+func readExports(dir, name string) map[string]bool {
+	exports := make(map[string]bool)
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		filename := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(filename, ".go") || strings.HasSuffix(filename, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, filename), nil, parser.SkipObjectResolution)
+		if err != nil || file.Name.Name != name {
+			continue
+		}
+		for _, decl := range file.Decls {
+			for _, ident := range declaredNames(decl) {
+				if token.IsExported(ident) {
+					exports[ident] = true
+				}
+			}
+		}
+	}
+	return exports
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// lines joins source lines, ending the last one too
+func lines(src ...string) string {
+	return strings.Join(src, "\n") + "\n"
+}
+
+func TestFormatterImports(t *testing.T) {
+	greet := lines(
+		"package greet",
+		"",
+		"func Hello() string { return \"hello\" }",
+	)
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			name: "adds a missing import",
+			files: map[string]string{"main.go": lines(
+				"package main",
+				"",
+				"func main() {",
+				"\tfmt.Println(strings.ToUpper(\"hi\"))",
+				"}",
+			)},
+			want: lines(
+				"package main",
+				"",
+				"import (",
+				"\t\"fmt\"",
+				"\t\"strings\"",
+				")",
+				"",
+				"func main() {",
+				"\tfmt.Println(strings.ToUpper(\"hi\"))",
+				"}",
+			),
+		},
+		{
+			name: "removes an unused import",
+			files: map[string]string{"main.go": lines(
+				"package main",
+				"",
+				"import \"os\"",
+				"",
+				"func main() {}",
+			)},
+			want: lines(
+				"package main",
+				"",
+				"func main() {}",
+			),
+		},
+		{
+			name: "parenthesized import left with one becomes single",
+			files: map[string]string{"main.go": lines(
+				"package main",
+				"",
+				"import (",
+				"\t\"fmt\"",
+				"\t\"os\"",
+				")",
+				"",
+				"func main() { fmt.Println() }",
+			)},
+			want: lines(
+				"package main",
+				"",
+				"import \"fmt\"",
+				"",
+				"func main() { fmt.Println() }",
+			),
+		},
+		{
+			name: "single import grows into a parenthesized one",
+			files: map[string]string{"main.go": lines(
+				"package main",
+				"",
+				"import \"fmt\"",
+				"",
+				"func main() { fmt.Println(os.Args) }",
+			)},
+			want: lines(
+				"package main",
+				"",
+				"import (",
+				"\t\"fmt\"",
+				"\t\"os\"",
+				")",
+				"",
+				"func main() { fmt.Println(os.Args) }",
+			),
+		},
+		{
+			name: "adds to the groups of a parenthesized import",
+			files: map[string]string{
+				"main.go": lines(
+					"package main",
+					"",
+					"import (",
+					"\t\"fmt\"",
+					"",
+					"\t\"github.com/google/uuid\"",
+					")",
+					"",
+					"func main() {",
+					"\tfmt.Println(uuid.New(), strings.ToUpper(greet.Hello()))",
+					"}",
+				),
+				"greet/greet.go": greet,
+			},
+			want: lines(
+				"package main",
+				"",
+				"import (",
+				"\t\"fmt\"",
+				"\t\"strings\"",
+				"",
+				"\t\"example/greet\"",
+				"\t\"github.com/google/uuid\"",
+				")",
+				"",
+				"func main() {",
+				"\tfmt.Println(uuid.New(), strings.ToUpper(greet.Hello()))",
+				"}",
+			),
+		},
+		{
+			name: "new import of both groups",
+			files: map[string]string{"main.go": lines(
+				"package main",
+				"",
+				"func main() {",
+				"\tfmt.Println(decimal.NewFromInt(1))",
+				"\tcolor.Red(\"error\")",
+				"}",
+			)},
+			want: lines(
+				"package main",
+				"",
+				"import (",
+				"\t\"fmt\"",
+				"",
+				"\t\"github.com/fatih/color\"",
+				"\t\"github.com/shopspring/decimal\"",
+				")",
+				"",
+				"func main() {",
+				"\tfmt.Println(decimal.NewFromInt(1))",
+				"\tcolor.Red(\"error\")",
+				"}",
+			),
+		},
+		{
+			name: "prefers the package that exports every name used",
+			files: map[string]string{"main.go": lines(
+				"package main",
+				"",
+				"func main() {",
+				"\t_ = rand.Intn(6)",
+				"\t_ = template.HTMLEscapeString(\"<b>\")",
+				"}",
+			)},
+			want: lines(
+				"package main",
+				"",
+				"import (",
+				"\t\"math/rand\"",
+				"\t\"text/template\"",
+				")",
+				"",
+				"func main() {",
+				"\t_ = rand.Intn(6)",
+				"\t_ = template.HTMLEscapeString(\"<b>\")",
+				"}",
+			),
+		},
+		{
+			name: "keeps named, blank and used imports with their comments",
+			files: map[string]string{"main.go": lines(
+				"package main",
+				"",
+				"import (",
+				"\t_ \"embed\"",
+				"\tstr \"strings\" // renamed",
+				"\t\"os\"",
+				")",
+				"",
+				"func main() { _ = str.ToLower(\"A\") }",
+			)},
+			want: lines(
+				"package main",
+				"",
+				"import (",
+				"\t_ \"embed\"",
+				"\tstr \"strings\" // renamed",
+				")",
+				"",
+				"func main() { _ = str.ToLower(\"A\") }",
+			),
+		},
+		{
+			name: "local names are not imports",
+			files: map[string]string{"main.go": lines(
+				"package main",
+				"",
+				"type point struct{ x int }",
+				"",
+				"func main() {",
+				"\tfmt := point{}",
+				"\t_ = fmt.x",
+				"}",
+			)},
+			want: lines(
+				"package main",
+				"",
+				"type point struct{ x int }",
+				"",
+				"func main() {",
+				"\tfmt := point{}",
+				"\t_ = fmt.x",
+				"}",
+			),
+		},
+	}
+
+	f := NewFormatter(ModulesConfig{Allowed: defaultAllowedModules})
+	if err := f.Check(); err != nil {
+		t.Skipf("standard library sources are needed: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.files["go.mod"] = "module example\n\ngo 1.21\n"
+			formatted, diagnostics := f.Format(tt.files)
+			if len(diagnostics) > 0 {
+				t.Fatalf("diagnostics: %+v", diagnostics)
+			}
+			if got := formatted["main.go"]; got != tt.want {
+				t.Errorf("formatted main.go:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatterWithoutStandardLibrary(t *testing.T) {
+	f := NewFormatter(ModulesConfig{})
+	f.goroot = t.TempDir()
+	if err := f.Check(); err == nil {
+		t.Fatal("Check() found a standard library in an empty GOROOT")
+	}
+
+	// Unused imports are still removed, only missing ones cannot be added
+	src := lines(
+		"package main",
+		"",
+		"import \"os\"",
+		"",
+		"func main() { fmt.Println() }",
+	)
+	want := lines(
+		"package main",
+		"",
+		"func main() { fmt.Println() }",
+	)
+	formatted, diagnostics := f.Format(map[string]string{"main.go": src})
+	if len(diagnostics) > 0 {
+		t.Fatalf("diagnostics: %+v", diagnostics)
+	}
+	if got := formatted["main.go"]; got != want {
+		t.Errorf("formatted main.go:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The format endpoint tells the editor why nothing was imported
+	previous := formatter
+	formatter = f
+	t.Cleanup(func() { formatter = previous })
+	var response FormatResponse
+	serve(t, http.MethodPost, "/api/format", gin.H{"code": src}, "", &response)
+	if response.Code != want || response.Warning == "" {
+		t.Errorf("response = %+v, want the formatted code with a warning", response)
+	}
+}
+
+func TestFormatterSyntaxError(t *testing.T) {
+	f := NewFormatter(ModulesConfig{})
+	_, diagnostics := f.Format(map[string]string{"main.go": "package main\n\nfunc main() {\n"})
+	if len(diagnostics) != 1 || diagnostics[0].File != "main.go" || diagnostics[0].Line != 3 {
+		t.Errorf("diagnostics = %+v, want one at the end of main.go", diagnostics)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CodeSubmissionRequest represents a learner's attempt at a lesson exercise
+type CodeSubmissionRequest struct {
+	LessonID int    `json:"lesson_id" binding:"required"`
+	Code     string `json:"code"`
+
+	// Files holds the other files of a multi-file exercise, keyed by path
+	Files map[string]string `json:"files,omitempty"`
+
+	// GoVersion overrides the Go release the lesson is built with
+	GoVersion string `json:"go_version,omitempty"`
+
+	// ProgressNonce identifies the browser of an anonymous learner, whose
+	// passes get a progress token only it can merge
+	ProgressNonce string `json:"progress_nonce,omitempty"`
+}
+
+// CodeSubmissionResponse represents the grading result of a submission
+type CodeSubmissionResponse struct {
+	Passed      bool         `json:"passed"`
+	Output      string       `json:"output"`
+	Expected    string       `json:"expected,omitempty"`
+	Diff        string       `json:"diff,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+	Tests       []TestResult `json:"tests,omitempty"`
+	GoVersion   string       `json:"go_version,omitempty"`
+
+	// ExecutionID identifies the submission in the user's history
+	ExecutionID int64 `json:"execution_id,omitempty"`
+
+	// ProgressToken proves an anonymous pass, so that it can be merged into
+	// an account once the learner registers or logs in
+	ProgressToken string `json:"progress_token,omitempty"`
+
+	// Vet holds what go vet found in a submission to a lesson that requires
+	// a clean vet result
+	Vet []Finding `json:"vet,omitempty"`
+}
+
+// submitCode grades a submission for a lesson and records the lesson as
+// completed when it passes. Anonymous learners get a progress token instead.
+func submitCode(c *gin.Context) {
+	var req CodeSubmissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	submission := &CodeExecutionRequest{Code: req.Code, Files: req.Files, UserID: currentUserID(c), LessonID: req.LessonID}
+	if _, err := submission.workspaceFiles(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	lesson, err := database.GetLesson(req.LessonID)
+	if err != nil {
+		log.Printf("Error getting lesson %d: %v", req.LessonID, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
+		return
+	}
+	if lesson.RetiredAt != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "Lesson has been retired"})
+		return
+	}
+	submission.GoVersion = req.GoVersion
+	if submission.GoVersion == "" {
+		submission.GoVersion = lesson.GoVersion
+	}
+	if err := resolveGoVersion(c.Request.Context(), submission); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Grading may run both the solution and the submission in one slot
+	release, err := scheduler.Acquire(c.Request.Context(), requestUser(c), nil)
+	if err != nil {
+		respondNotScheduled(c, err)
+		return
+	}
+	defer release()
+
+	start := time.Now()
+	result, err := gradeSubmission(c.Request.Context(), lesson, submission)
+	if err != nil {
+		respondExecutionError(c, err)
+		return
+	}
+
+	execution := newExecution(submission, "submit", time.Since(start))
+	execution.Output = result.Output
+	execution.Error = result.Error
+	execution.Passed = &result.Passed
+	result.ExecutionID = recordExecution(execution)
+
+	if result.Passed && submission.UserID == "" {
+		if req.ProgressNonce != "" {
+			result.ProgressToken = tokens.progress(req.LessonID, lesson.Version, time.Now().UTC().Format(time.RFC3339), req.ProgressNonce)
+		}
+	} else if result.Passed {
+		completedAt := time.Now().UTC().Format(time.RFC3339)
+		progress := UserProgress{
+			UserID:        submission.UserID,
+			LessonID:      req.LessonID,
+			Completed:     true,
+			CompletedAt:   &completedAt,
+			LessonVersion: lesson.Version,
+		}
+		if err := database.UpdateUserProgress(progress); err != nil {
+			log.Printf("Error updating user progress: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update progress"})
+			return
+		}
+		log.Printf("Lesson %d passed by user %s", req.LessonID, submission.UserID)
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// gradeSubmission runs the lesson's hidden tests against the learner's code,
+// or when the lesson has none, runs both the learner's code and the lesson
+// solution and compares their normalized output. Lessons that require it
+// also need go vet to find nothing in a passing submission.
+func gradeSubmission(ctx context.Context, lesson *Lesson, submission *CodeExecutionRequest) (*CodeSubmissionResponse, error) {
+	grade := gradeWithSolution
+	if lesson.TestCode != "" {
+		grade = gradeWithTests
+	}
+	result, err := grade(ctx, lesson, submission)
+	if err != nil || !result.Passed || !lesson.RequireVet {
+		return result, err
+	}
+
+	check, err := executor.Vet(ctx, submission)
+	if err != nil {
+		return nil, err
+	}
+	if check.VetClean {
+		return result, nil
+	}
+	result.Passed = false
+	result.Error = "The program works, but go vet reports problems that need fixing"
+	if check.Error != "" {
+		result.Error = check.Error
+		result.Diagnostics = check.Diagnostics
+	}
+	result.Vet = []Finding{}
+	for _, finding := range check.Findings {
+		if finding.Tool == "vet" {
+			result.Vet = append(result.Vet, finding)
+		}
+	}
+	return result, nil
+}
+
+// gradeWithSolution passes a submission whose output matches the solution's
+func gradeWithSolution(ctx context.Context, lesson *Lesson, submission *CodeExecutionRequest) (*CodeSubmissionResponse, error) {
+	// The solution is built with the same release as the submission
+	solution := &CodeExecutionRequest{Code: lesson.Solution, Files: lesson.SolutionFiles, GoVersion: submission.GoVersion}
+	expected, err := executor.Execute(ctx, solution, nil)
+	if err != nil {
+		return nil, err
+	}
+	if expected.Error != "" {
+		log.Printf("Solution for lesson %d failed to run: %s", lesson.ID, expected.Error)
+		return &CodeSubmissionResponse{Error: "Lesson solution could not be run"}, nil
+	}
+
+	actual, err := executor.Execute(ctx, submission, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CodeSubmissionResponse{
+		Output:      actual.Output,
+		Expected:    expected.Output,
+		Error:       actual.Error,
+		Diagnostics: actual.Diagnostics,
+		GoVersion:   actual.GoVersion,
+	}
+	if actual.Error != "" {
+		return result, nil
+	}
+
+	want := normalizeOutput(expected.Output)
+	got := normalizeOutput(actual.Output)
+	result.Passed = want == got
+	if !result.Passed {
+		result.Diff = diffLines(want, got)
+	}
+
+	return result, nil
+}
+
+// gradeWithTests passes a submission when every hidden test passes
+func gradeWithTests(ctx context.Context, lesson *Lesson, submission *CodeExecutionRequest) (*CodeSubmissionResponse, error) {
+	expected, err := hiddenTestNames(lesson.TestCode)
+	if err == nil && len(expected) == 0 {
+		err = errors.New("no tests are declared")
+	}
+	if err != nil {
+		log.Printf("Tests for lesson %d cannot be graded: %v", lesson.ID, err)
+		return &CodeSubmissionResponse{Error: "Lesson tests could not be run"}, nil
+	}
+
+	tests := *submission
+	tests.TestCode = lesson.TestCode
+	run, err := executor.Execute(ctx, &tests, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CodeSubmissionResponse{
+		Output:      run.Output,
+		Error:       run.Error,
+		Diagnostics: run.Diagnostics,
+		Tests:       run.Tests,
+		GoVersion:   run.GoVersion,
+	}
+	if result.Error == "" {
+		result.Error = checkHiddenTests(expected, run.Tests)
+	}
+	result.Passed = result.Error == ""
+	return result, nil
+}
+
+// normalizeOutput makes program output comparable by unifying line endings,
+// collapsing runs of spaces and tabs and dropping blank lines at either end
+func normalizeOutput(output string) string {
+	output = strings.ReplaceAll(output, "\r\n", "\n")
+
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// The line diff keeps a table of (len(a)+1)×(len(b)+1) ints in the server,
+// so outputs too long to diff within maxDiffCells are only previewed, with at
+// most maxDiffPreview lines of each
+const (
+	maxDiffCells   = 1 << 20
+	maxDiffPreview = 50
+)
+
+// diffLines returns a unified-style line diff between the expected and the
+// actual output, where "-" lines are missing and "+" lines are unexpected
+func diffLines(expected, actual string) string {
+	a := strings.Split(expected, "\n")
+	b := strings.Split(actual, "\n")
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		return previewLines(a, b)
+	}
+
+	// lcs[i][j] holds the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			diff.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			diff.WriteString("+ " + b[j] + "\n")
+			j++
+		default:
+			diff.WriteString("- " + a[i] + "\n")
+			i++
+		}
+	}
+
+	return diff.String()
+}
+
+// previewLines stands in for the diff of outputs too long to compare line by
+// line, showing the start of each
+func previewLines(expected, actual []string) string {
+	var diff strings.Builder
+	diff.WriteString("Output differs and is too long to compare line by line\n")
+	for _, side := range []struct {
+		prefix string
+		lines  []string
+	}{{"- ", expected}, {"+ ", actual}} {
+		for i, line := range side.lines {
+			if i == maxDiffPreview {
+				fmt.Fprintf(&diff, "%s... %d more lines\n", side.prefix, len(side.lines)-i)
+				break
+			}
+			diff.WriteString(side.prefix + line + "\n")
+		}
+	}
+	return diff.String()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		actual   string
+		want     string
+	}{
+		{
+			name:     "same output",
+			expected: "a\nb",
+			actual:   "a\nb",
+			want:     "  a\n  b\n",
+		},
+		{
+			name:     "changed line",
+			expected: "a\nb\nc",
+			actual:   "a\nx\nc",
+			want:     "  a\n- b\n+ x\n  c\n",
+		},
+		{
+			name:     "missing and extra lines",
+			expected: "a\nb",
+			actual:   "b\nc",
+			want:     "- a\n  b\n+ c\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines(tt.expected, tt.actual); got != tt.want {
+				t.Errorf("diffLines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesTooLong(t *testing.T) {
+	// Half a million lines against a few would need a table of gigabytes
+	actual := strings.Repeat("x\n", 500000)
+	diff := diffLines("a\nb\nc", actual)
+
+	lines := strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+	if want := 1 + 3 + maxDiffPreview + 1; len(lines) != want {
+		t.Fatalf("diff has %d lines, want %d", len(lines), want)
+	}
+	if !strings.HasPrefix(lines[0], "Output differs") {
+		t.Errorf("first line %q, want a note that the output differs", lines[0])
+	}
+	if last := lines[len(lines)-1]; last != "+ ... 499951 more lines" {
+		t.Errorf("last line %q, want the number of lines left out", last)
+	}
+}
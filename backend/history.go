@@ -0,0 +1,155 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Execution is a run or submission in a user's history
+type Execution struct {
+	ID       int64  `json:"id"`
+	UserID   string `json:"user_id"`
+	LessonID int    `json:"lesson_id"`
+
+	// Kind is "run" for executions and "submit" for graded submissions
+	Kind string `json:"kind"`
+
+	// The code as it was sent, left out of history listings
+	Code      string            `json:"code,omitempty"`
+	Files     map[string]string `json:"files,omitempty"`
+	Stdin     string            `json:"stdin,omitempty"`
+	GoVersion string            `json:"go_version,omitempty"`
+
+	// Output is left out of history listings as well
+	Output   string `json:"output,omitempty"`
+	Error    string `json:"error,omitempty"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+
+	// Passed is set for submissions only
+	Passed *bool `json:"passed,omitempty"`
+
+	DurationMs int64  `json:"duration_ms"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// Page sizes of history listings
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+)
+
+// newExecution starts the history record of a request that took duration
+func newExecution(req *CodeExecutionRequest, kind string, duration time.Duration) *Execution {
+	return &Execution{
+		UserID:     req.UserID,
+		LessonID:   req.LessonID,
+		Kind:       kind,
+		Code:       req.Code,
+		Files:      req.Files,
+		Stdin:      req.Stdin,
+		GoVersion:  req.GoVersion,
+		DurationMs: duration.Milliseconds(),
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// recordRun records an execution of req and returns its ID, or zero when
+// the user is anonymous or recording failed
+func recordRun(req *CodeExecutionRequest, response *CodeExecutionResponse, duration time.Duration) int64 {
+	execution := newExecution(req, "run", duration)
+	execution.GoVersion = response.GoVersion
+	execution.Output = response.Output
+	execution.Error = response.Error
+	execution.ExitCode = response.ExitCode
+	return recordExecution(execution)
+}
+
+// recordExecution stores an execution in its user's history. History is a
+// convenience, so failing to record one only gets logged.
+func recordExecution(execution *Execution) int64 {
+	if execution.UserID == "" {
+		return 0
+	}
+	id, err := database.RecordExecution(execution)
+	if err != nil {
+		log.Printf("⚠️  Failed to record execution for user %s: %v", execution.UserID, err)
+	}
+	return id
+}
+
+// getExecutionHistory lists the logged-in user's executions, newest first
+func getExecutionHistory(c *gin.Context) {
+	if executions, ok := executionHistory(c, currentUserID(c)); ok {
+		c.JSON(http.StatusOK, executions)
+	}
+}
+
+// getExecution loads one of the logged-in user's executions with its code,
+// to show it or load it back into the editor
+func getExecution(c *gin.Context) {
+	if execution, ok := executionByID(c, currentUserID(c)); ok {
+		c.JSON(http.StatusOK, execution)
+	}
+}
+
+// executionHistory lists a user's executions, newest first, answering the
+// request itself when that fails. The lesson_id query parameter narrows
+// them to one lesson, with 0 for code outside of lessons, and before and
+// limit page through them.
+func executionHistory(c *gin.Context, userID string) ([]Execution, bool) {
+	var lessonID *int
+	if value := c.Query("lesson_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid lesson ID"})
+			return nil, false
+		}
+		lessonID = &id
+	}
+	before, err := strconv.ParseInt(c.DefaultQuery("before", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid before"})
+		return nil, false
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultHistoryLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return nil, false
+	}
+
+	executions, err := database.GetExecutions(userID, lessonID, before, min(limit, maxHistoryLimit))
+	if err != nil {
+		log.Printf("Error getting execution history: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get execution history"})
+		return nil, false
+	}
+	return executions, true
+}
+
+// executionByID loads the user's execution named in the route, answering
+// the request itself when that fails
+func executionByID(c *gin.Context, userID string) (*Execution, bool) {
+	id, err := strconv.ParseInt(c.Param("execution_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid execution ID"})
+		return nil, false
+	}
+
+	execution, err := database.GetExecution(userID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Execution not found"})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error getting execution %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get execution"})
+		return nil, false
+	}
+	return execution, true
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// errLessonNotFound is returned when looking up an unknown lesson or
+	// lesson version
+	errLessonNotFound = errors.New("lesson not found")
+
+	// errLessonVersionConflict is returned when a lesson is edited from a
+	// version that is no longer its latest
+	errLessonVersionConflict = errors.New("lesson was changed since the version the edit is based on")
+)
+
+// LessonContent is a lesson as authors see it, with its hidden tests. It is
+// what lesson versions store.
+type LessonContent struct {
+	Lesson
+	TestCode string `json:"test_code"`
+}
+
+// LessonRequest creates or edits a lesson. BaseVersion is the version the
+// edit was made from; when set, the edit fails if the lesson has moved on.
+type LessonRequest struct {
+	LessonContent
+	BaseVersion int `json:"base_version"`
+}
+
+// LessonVersion is a recorded version of a lesson
+type LessonVersion struct {
+	LessonID  int            `json:"lesson_id"`
+	Version   int            `json:"version"`
+	AuthorID  string         `json:"author_id,omitempty"`
+	CreatedAt string         `json:"created_at"`
+	Lesson    *LessonContent `json:"lesson,omitempty"`
+}
+
+// LessonOrderRequest lists lesson IDs in their new order
+type LessonOrderRequest struct {
+	LessonIDs []int `json:"lesson_ids" binding:"required"`
+}
+
+// lesson returns the request as a lesson with its hidden tests
+func (r *LessonRequest) lesson() *Lesson {
+	lesson := r.Lesson
+	lesson.TestCode = r.TestCode
+	lesson.Title = strings.TrimSpace(lesson.Title)
+	if lesson.Variants == nil {
+		lesson.Variants = []string{}
+	}
+	return &lesson
+}
+
+// validateLesson checks that a lesson can be shown and graded with a Go
+// release the server has
+func validateLesson(c *gin.Context, lesson *Lesson) error {
+	if err := lesson.validate(); err != nil {
+		return err
+	}
+	if lesson.GoVersion != "" {
+		solution := &CodeExecutionRequest{GoVersion: lesson.GoVersion}
+		if err := resolveGoVersion(c.Request.Context(), solution); err != nil {
+			return fmt.Errorf("go_version: %v", err)
+		}
+	}
+	return nil
+}
+
+// lessonIDParam parses a lesson ID route parameter
+func lessonIDParam(c *gin.Context, name string) (int, bool) {
+	id, err := strconv.Atoi(c.Param(name))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + strings.ReplaceAll(name, "_", " ")})
+		return 0, false
+	}
+	return id, true
+}
+
+// respondLessonError answers with the status matching a lesson error
+func respondLessonError(c *gin.Context, err error, action string) {
+	switch {
+	case errors.Is(err, errLessonNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
+	case errors.Is(err, errLessonVersionConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error trying to %s: %v", action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action})
+	}
+}
+
+// getAllLessons lists every lesson, retired ones included, with hidden tests
+func getAllLessons(c *gin.Context) {
+	lessons, err := database.GetLessons(true)
+	if err != nil {
+		respondLessonError(c, err, "get lessons")
+		return
+	}
+
+	contents := make([]LessonContent, len(lessons))
+	for i, lesson := range lessons {
+		contents[i] = LessonContent{Lesson: lesson, TestCode: lesson.TestCode}
+	}
+	recordAudit(c, "lessons.view_all", "", map[string]any{"lessons": len(contents)})
+	c.JSON(http.StatusOK, contents)
+}
+
+// createLesson adds a lesson
+func createLesson(c *gin.Context) {
+	var req LessonRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	lesson := req.lesson()
+	if err := validateLesson(c, lesson); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := database.CreateLesson(lesson, currentUserID(c))
+	if err != nil {
+		respondLessonError(c, err, "create lesson")
+		return
+	}
+	recordAudit(c, "lesson.create", strconv.Itoa(created.ID), map[string]any{"title": created.Title})
+
+	c.JSON(http.StatusCreated, LessonContent{Lesson: *created, TestCode: created.TestCode})
+}
+
+// updateLesson saves an edit of a lesson as its next version
+func updateLesson(c *gin.Context) {
+	id, ok := lessonIDParam(c, "id")
+	if !ok {
+		return
+	}
+	var req LessonRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	lesson := req.lesson()
+	lesson.ID = id
+	if err := validateLesson(c, lesson); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := database.UpdateLesson(lesson, req.BaseVersion, currentUserID(c))
+	if err != nil {
+		respondLessonError(c, err, "update lesson")
+		return
+	}
+	recordAudit(c, "lesson.update", strconv.Itoa(id), map[string]any{"version": updated.Version})
+
+	c.JSON(http.StatusOK, LessonContent{Lesson: *updated, TestCode: updated.TestCode})
+}
+
+// reorderLessons changes the order lessons are taken in
+func reorderLessons(c *gin.Context) {
+	var req LessonOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	seen := make(map[int]bool, len(req.LessonIDs))
+	for _, id := range req.LessonIDs {
+		if seen[id] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Lesson %d is listed twice", id)})
+			return
+		}
+		seen[id] = true
+	}
+
+	if err := database.ReorderLessons(req.LessonIDs); err != nil {
+		if errors.Is(err, errLessonNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		respondLessonError(c, err, "reorder lessons")
+		return
+	}
+	recordAudit(c, "lesson.reorder", "", map[string]any{"lesson_ids": req.LessonIDs})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Lessons reordered"})
+}
+
+// retireLesson withdraws a lesson from learners. Its versions, and the
+// progress and history of learners who took it, are kept.
+func retireLesson(c *gin.Context) {
+	setLessonRetired(c, true, "lesson.retire")
+}
+
+// restoreLesson brings a retired lesson back
+func restoreLesson(c *gin.Context) {
+	setLessonRetired(c, false, "lesson.restore")
+}
+
+// setLessonRetired retires or restores the lesson named in the route
+func setLessonRetired(c *gin.Context, retired bool, action string) {
+	id, ok := lessonIDParam(c, "id")
+	if !ok {
+		return
+	}
+	if err := database.SetLessonRetired(id, retired); err != nil {
+		respondLessonError(c, err, "change lesson")
+		return
+	}
+	recordAudit(c, action, strconv.Itoa(id), nil)
+
+	lesson, err := database.GetLesson(id)
+	if err != nil {
+		respondLessonError(c, err, "get lesson")
+		return
+	}
+	c.JSON(http.StatusOK, LessonContent{Lesson: *lesson, TestCode: lesson.TestCode})
+}
+
+// getLessonVersions lists the versions of a lesson
+func getLessonVersions(c *gin.Context) {
+	id, ok := lessonIDParam(c, "id")
+	if !ok {
+		return
+	}
+	versions, err := database.GetLessonVersions(id)
+	if err == nil && len(versions) == 0 {
+		err = errLessonNotFound
+	}
+	if err != nil {
+		respondLessonError(c, err, "get lesson versions")
+		return
+	}
+	recordAudit(c, "lesson.versions.view", strconv.Itoa(id), map[string]any{"versions": len(versions)})
+
+	c.JSON(http.StatusOK, versions)
+}
+
+// getLessonVersion returns a version of a lesson with its content
+func getLessonVersion(c *gin.Context) {
+	id, ok := lessonIDParam(c, "id")
+	if !ok {
+		return
+	}
+	number, ok := lessonIDParam(c, "version")
+	if !ok {
+		return
+	}
+	version, err := database.GetLessonVersion(id, number)
+	if err != nil {
+		respondLessonError(c, err, "get lesson version")
+		return
+	}
+	recordAudit(c, "lesson.version.view", strconv.Itoa(id), map[string]any{"version": number})
+
+	c.JSON(http.StatusOK, version)
+}
+
+// revertLesson makes an earlier version of a lesson its latest, as a new
+// version so that the edits since are kept
+func revertLesson(c *gin.Context) {
+	id, ok := lessonIDParam(c, "id")
+	if !ok {
+		return
+	}
+	number, ok := lessonIDParam(c, "version")
+	if !ok {
+		return
+	}
+	version, err := database.GetLessonVersion(id, number)
+	if err != nil {
+		respondLessonError(c, err, "get lesson version")
+		return
+	}
+
+	updated, err := database.UpdateLesson(&version.Lesson.Lesson, 0, currentUserID(c))
+	if err != nil {
+		respondLessonError(c, err, "revert lesson")
+		return
+	}
+	recordAudit(c, "lesson.revert", strconv.Itoa(id), map[string]any{"from": number, "version": updated.Version})
+
+	c.JSON(http.StatusOK, LessonContent{Lesson: *updated, TestCode: updated.TestCode})
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// validateLessonsCommand is the first argument that makes the server binary
+// check the lesson files and exit, failing when any of them is invalid
+const validateLessonsCommand = "validate-lessons"
+
+// lessonFilesAuthor is the author of lesson versions loaded from the lesson
+// files. Versions by any other author were edited through the API.
+const lessonFilesAuthor = "files"
+
+// LessonFrontMatter is the YAML header of a lesson file
+type LessonFrontMatter struct {
+	ID          int    `yaml:"id"`
+	Title       string `yaml:"title"`
+	Description string `yaml:"description"`
+	Difficulty  string `yaml:"difficulty"`
+	Category    string `yaml:"category"`
+	Order       int    `yaml:"order"`
+	GoVersion   string `yaml:"go_version"`
+	RequireVet  bool   `yaml:"require_vet"`
+}
+
+// lessonSections are the level-2 headings a lesson file may have after its
+// content. Code sections hold fenced blocks; file sections hold one fenced
+// block per file, with the path after the language, as in ```go main.go.
+var lessonSections = map[string]struct {
+	code   bool
+	single bool
+}{
+	"Explanation":    {},
+	"Variants":       {code: true},
+	"Exercise":       {code: true, single: true},
+	"Solution":       {code: true, single: true},
+	"Tests":          {code: true, single: true},
+	"Starter files":  {code: true},
+	"Solution files": {code: true},
+}
+
+// lessonBlock is a fenced code block of a lesson file
+type lessonBlock struct {
+	info string
+	code string
+	line int
+}
+
+// lessonSection is the text and code blocks under a heading
+type lessonSection struct {
+	line   int
+	text   []string
+	blocks []lessonBlock
+}
+
+// loadLessonFiles reads every *.md lesson file in dir, ordered by the
+// lessons' order. All problems are reported together, each naming its file.
+func loadLessonFiles(dir string) ([]Lesson, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.md"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no lesson files in %s", dir)
+	}
+	sort.Strings(paths)
+
+	var lessons []Lesson
+	var errs []error
+	files := make(map[int]string)
+	for _, path := range paths {
+		lesson, err := loadLessonFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if other, ok := files[lesson.ID]; ok {
+			errs = append(errs, fmt.Errorf("%s: id %d is already used by %s", path, lesson.ID, other))
+			continue
+		}
+		files[lesson.ID] = path
+		lessons = append(lessons, *lesson)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	sort.SliceStable(lessons, func(i, j int) bool { return lessons[i].Order < lessons[j].Order })
+	return lessons, nil
+}
+
+// loadLessonFile reads and validates a lesson file
+func loadLessonFile(path string) (*Lesson, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lesson, err := parseLessonFile(data)
+	if err == nil {
+		err = lesson.validateFile()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return lesson, nil
+}
+
+// parseLessonFile parses a lesson from its front matter and Markdown body
+func parseLessonFile(data []byte) (*Lesson, error) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if len(lines) == 0 || lines[0] != "---" {
+		return nil, errors.New("missing front matter: the file must start with a --- line")
+	}
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if lines[i] == "---" {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, errors.New("front matter is not closed by a --- line")
+	}
+
+	var front LessonFrontMatter
+	decoder := yaml.NewDecoder(bytes.NewReader([]byte(strings.Join(lines[1:end], "\n"))))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&front); err != nil {
+		return nil, fmt.Errorf("front matter: %v", err)
+	}
+
+	sections, err := splitLessonSections(lines[end+1:], end+2)
+	if err != nil {
+		return nil, err
+	}
+
+	lesson := &Lesson{
+		ID:          front.ID,
+		Title:       front.Title,
+		Description: front.Description,
+		Difficulty:  front.Difficulty,
+		Category:    front.Category,
+		Order:       front.Order,
+		GoVersion:   front.GoVersion,
+		RequireVet:  front.RequireVet,
+		Content:     sectionText(sections[""]),
+		Explanation: sectionText(sections["Explanation"]),
+		Variants:    []string{},
+	}
+	if section := sections["Variants"]; section != nil {
+		for _, block := range section.blocks {
+			lesson.Variants = append(lesson.Variants, block.code)
+		}
+	}
+	lesson.Exercise = sectionCode(sections["Exercise"])
+	lesson.Solution = sectionCode(sections["Solution"])
+	lesson.TestCode = sectionCode(sections["Tests"])
+	if lesson.StarterFiles, err = sectionFiles(sections["Starter files"]); err != nil {
+		return nil, err
+	}
+	if lesson.SolutionFiles, err = sectionFiles(sections["Solution files"]); err != nil {
+		return nil, err
+	}
+	return lesson, nil
+}
+
+// splitLessonSections splits the body of a lesson file at its level-2
+// headings, collecting the fenced code blocks of each section. The content
+// before the first heading is the section "". first is the line number of
+// the first body line.
+func splitLessonSections(lines []string, first int) (map[string]*lessonSection, error) {
+	sections := map[string]*lessonSection{"": {line: first}}
+	name, current := "", sections[""]
+	for i := 0; i < len(lines); i++ {
+		line, number := lines[i], first+i
+
+		if heading, ok := strings.CutPrefix(line, "## "); ok {
+			name = strings.TrimSpace(heading)
+			if _, known := lessonSections[name]; !known {
+				return nil, fmt.Errorf("line %d: unknown section %q", number, name)
+			}
+			if sections[name] != nil {
+				return nil, fmt.Errorf("line %d: section %q appears twice", number, name)
+			}
+			current = &lessonSection{line: number}
+			sections[name] = current
+			continue
+		}
+
+		fence, info, ok := openingFence(line)
+		if !ok || !lessonSections[name].code {
+			if strings.TrimSpace(line) != "" && lessonSections[name].code {
+				return nil, fmt.Errorf("line %d: section %q may only hold fenced code blocks", number, name)
+			}
+			current.text = append(current.text, line)
+			continue
+		}
+
+		closed := false
+		var code []string
+		for i++; i < len(lines); i++ {
+			if isClosingFence(lines[i], fence) {
+				closed = true
+				break
+			}
+			code = append(code, lines[i])
+		}
+		if !closed {
+			return nil, fmt.Errorf("line %d: code block is not closed", number)
+		}
+		current.blocks = append(current.blocks, lessonBlock{info: info, code: strings.Join(code, "\n"), line: number})
+	}
+
+	for name, section := range sections {
+		if lessonSections[name].single && len(section.blocks) != 1 {
+			return nil, fmt.Errorf("line %d: section %q must hold exactly one code block", section.line, name)
+		}
+	}
+	return sections, nil
+}
+
+// openingFence reports whether line opens a fenced code block, returning
+// the fence and the info string after it
+func openingFence(line string) (string, string, bool) {
+	for _, char := range []string{"`", "~"} {
+		fence := line[:len(line)-len(strings.TrimLeft(line, char))]
+		if len(fence) >= 3 {
+			info := strings.TrimSpace(line[len(fence):])
+			if char == "`" && strings.Contains(info, "`") {
+				return "", "", false
+			}
+			return fence, info, true
+		}
+	}
+	return "", "", false
+}
+
+// isClosingFence reports whether line closes a block opened by fence: a
+// fence of the same character at least as long, and nothing else
+func isClosingFence(line, fence string) bool {
+	line = strings.TrimRight(line, " \t")
+	return len(line) >= len(fence) && strings.Trim(line, fence[:1]) == ""
+}
+
+// sectionText returns the text of a section without surrounding blank lines
+func sectionText(section *lessonSection) string {
+	if section == nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.Join(section.text, "\n"))
+}
+
+// sectionCode returns the code block of a single-block section
+func sectionCode(section *lessonSection) string {
+	if section == nil || len(section.blocks) == 0 {
+		return ""
+	}
+	return section.blocks[0].code
+}
+
+// sectionFiles returns the files of a file section keyed by path. Files end
+// with a newline, like files saved by an editor.
+func sectionFiles(section *lessonSection) (map[string]string, error) {
+	if section == nil {
+		return nil, nil
+	}
+	files := make(map[string]string, len(section.blocks))
+	for _, block := range section.blocks {
+		fields := strings.Fields(block.info)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: file blocks need a language and a path, as in ```go main.go", block.line)
+		}
+		path := fields[1]
+		if _, ok := files[path]; ok {
+			return nil, fmt.Errorf("line %d: file %s appears twice", block.line, path)
+		}
+		files[path] = block.code + "\n"
+	}
+	return files, nil
+}
+
+// validateFile checks a lesson loaded from a file, which unlike lessons
+// written through the API must name its ID, order and category
+func (l *Lesson) validateFile() error {
+	var problems []string
+	if l.ID <= 0 {
+		problems = append(problems, "id must be a positive number")
+	}
+	if l.Order <= 0 {
+		problems = append(problems, "order must be a positive number")
+	}
+	if l.Description == "" {
+		problems = append(problems, "description is required")
+	}
+	if l.Category == "" {
+		problems = append(problems, "category is required")
+	}
+	if l.Content == "" {
+		problems = append(problems, "content before the first section is required")
+	}
+	if l.Exercise == "" {
+		problems = append(problems, "exercise is required")
+	}
+	if err := l.validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// runValidateLessons checks the lesson files in dir, or in the configured
+// directory when no argument is given
+func runValidateLessons(cfg DatabaseConfig, args []string) error {
+	dir := cfg.LessonsDir
+	if len(args) > 1 {
+		return fmt.Errorf("usage: %s [dir]", validateLessonsCommand)
+	}
+	if len(args) == 1 {
+		dir = args[0]
+	}
+
+	lessons, err := loadLessonFiles(dir)
+	if err != nil {
+		return err
+	}
+	log.Printf("📚 %d lessons in %s", len(lessons), dir)
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// lessonFile returns a valid lesson file for id, with extra front matter
+// lines and extra sections
+func lessonFile(id, front, sections string) string {
+	return "---\n" +
+		"id: " + id + "\n" +
+		"title: Lesson " + id + "\n" +
+		"description: A lesson\n" +
+		"difficulty: beginner\n" +
+		"category: basics\n" +
+		"order: " + id + "\n" +
+		front +
+		"---\n\n" +
+		"What the lesson teaches.\n\n" +
+		"## Exercise\n\n```go\npackage main\n```\n\n" +
+		"## Solution\n\n```go\npackage main\n\nfunc main() {}\n```\n" +
+		sections
+}
+
+// writeLessonFiles lays out lesson files by name in a temporary directory
+func writeLessonFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadLessonFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+
+		// wantErrors are all expected in the error, which names the file
+		wantErrors []string
+	}{
+		{
+			name:       "missing front matter",
+			file:       "# Lesson\n\nNo front matter here.\n",
+			wantErrors: []string{"missing front matter"},
+		},
+		{
+			name:       "front matter that is not closed",
+			file:       "---\nid: 2\ntitle: Open\n",
+			wantErrors: []string{"front matter is not closed"},
+		},
+		{
+			name:       "unknown front matter key",
+			file:       lessonFile("2", "author: someone\n", ""),
+			wantErrors: []string{"field author not found"},
+		},
+		{
+			name:       "unknown section",
+			file:       lessonFile("2", "", "\n## Hints\n\nTry harder.\n"),
+			wantErrors: []string{`unknown section "Hints"`},
+		},
+		{
+			name:       "duplicate file block",
+			file:       lessonFile("2", "", "\n## Starter files\n\n```go main.go\npackage main\n```\n\n```go main.go\npackage main\n```\n"),
+			wantErrors: []string{"file main.go appears twice"},
+		},
+		{
+			name:       "file block without a path",
+			file:       lessonFile("2", "", "\n## Starter files\n\n```go\npackage main\n```\n"),
+			wantErrors: []string{"file blocks need a language and a path"},
+		},
+		{
+			name: "every problem of a lesson",
+			file: strings.Replace(strings.Replace(lessonFile("2", "", ""), "title: Lesson 2", `title: ""`, 1),
+				"difficulty: beginner", "difficulty: banana", 1),
+			wantErrors: []string{"title is required", "difficulty must be one of"},
+		},
+		{
+			name:       "missing fields of a lesson file",
+			file:       strings.Replace(strings.Replace(lessonFile("2", "", ""), "category: basics\n", "", 1), "order: 2\n", "", 1),
+			wantErrors: []string{"order must be a positive number", "category is required"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeLessonFiles(t, map[string]string{"01-first.md": lessonFile("1", "", ""), "02-broken.md": tt.file})
+			_, err := loadLessonFiles(dir)
+			if err == nil {
+				t.Fatal("loadLessonFiles() succeeded, want an error")
+			}
+			if !strings.Contains(err.Error(), "02-broken.md") || strings.Contains(err.Error(), "01-first.md") {
+				t.Errorf("error %q does not name only the broken file", err)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not report %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadLessonFilesDuplicateID(t *testing.T) {
+	dir := writeLessonFiles(t, map[string]string{
+		"01-first.md":  lessonFile("1", "", ""),
+		"02-second.md": lessonFile("1", "", ""),
+		"03-third.md":  lessonFile("3", "", ""),
+	})
+	_, err := loadLessonFiles(dir)
+	if err == nil || !strings.Contains(err.Error(), "02-second.md: id 1 is already used by") {
+		t.Errorf("loadLessonFiles() error = %v, want the duplicate id of 02-second.md", err)
+	}
+}
+
+func TestLoadLessonFilesOrder(t *testing.T) {
+	dir := writeLessonFiles(t, map[string]string{
+		"a.md": lessonFile("2", "", ""),
+		"b.md": lessonFile("1", "go_version: \"1.22\"\nrequire_vet: true\n",
+			"\n## Starter files\n\n```go main.go\npackage main\n```\n\n```text go.mod\nmodule shapes\n```\n"),
+	})
+	lessons, err := loadLessonFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lessons) != 2 || lessons[0].ID != 1 || lessons[1].ID != 2 {
+		t.Fatalf("lessons = %+v, want lessons 1 and 2 by order", lessons)
+	}
+	first := lessons[0]
+	if first.GoVersion != "1.22" || !first.RequireVet || first.StarterFiles["go.mod"] != "module shapes\n" || len(first.StarterFiles) != 2 {
+		t.Errorf("lesson 1 = %+v, want its front matter and starter files", first)
+	}
+}
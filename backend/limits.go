@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ResourceLimits bound what a learner's program may use. Every backend
+// enforces them, except that the local backend cannot limit processes and
+// only the docker backend limits CPUs; backends report the limits they
+// ignore through LimitsReporter, which are warned about at startup.
+type ResourceLimits struct {
+	MemoryMB    int
+	CPUSeconds  int
+	WallSeconds int
+	Processes   int
+	OutputKB    int
+	CPUs        int
+}
+
+// Names of the limits as reported in CodeExecutionResponse.LimitExceeded
+const (
+	limitMemory    = "memory"
+	limitCPUTime   = "cpu_time"
+	limitWallTime  = "wall_time"
+	limitProcesses = "processes"
+	limitOutput    = "output"
+)
+
+// forTests returns the limits of a test run, which gets twice the wall time
+// of a program
+func (l ResourceLimits) forTests() ResourceLimits {
+	l.WallSeconds *= 2
+	return l
+}
+
+// wallTime returns how long a program may run
+func (l ResourceLimits) wallTime() time.Duration {
+	return time.Duration(l.WallSeconds) * time.Second
+}
+
+// outputBytes returns the output limit in bytes, zero meaning no limit
+func (l ResourceLimits) outputBytes() int {
+	return l.OutputKB << 10
+}
+
+// resourceUsage is what a finished program used
+type resourceUsage struct {
+	peakMemory int64
+	cpuTime    time.Duration
+}
+
+// runOutcome describes how a run ended, for telling which limit, if any,
+// stopped it
+type runOutcome struct {
+	usage resourceUsage
+
+	// failed is set when the program did not exit with status zero, and
+	// killed when a signal ended it
+	failed bool
+	killed bool
+
+	timedOut       bool
+	outputExceeded bool
+
+	// output is what the program printed, where the Go runtime reports
+	// failed allocations and thread creation
+	output string
+}
+
+// outcomeOf fills in how a program started with exec ended
+func outcomeOf(ctx context.Context, err error, state *os.ProcessState) runOutcome {
+	outcome := runOutcome{
+		failed:   err != nil,
+		timedOut: ctx.Err() == context.DeadlineExceeded,
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == -1 {
+		outcome.killed = true
+	}
+	if state != nil {
+		outcome.usage = processUsage(state)
+	}
+	return outcome
+}
+
+// setUsage reports the program's peak memory and CPU time
+func (r *CodeExecutionResponse) setUsage(usage resourceUsage) {
+	r.PeakMemoryBytes = usage.peakMemory
+	r.CPUTimeMs = usage.cpuTime.Milliseconds()
+}
+
+// checkLimits records the usage of a run and, when it was stopped by one
+// of the limits, names that limit in place of the generic error
+func (r *CodeExecutionResponse) checkLimits(limits ResourceLimits, outcome runOutcome) {
+	r.setUsage(outcome.usage)
+	r.Truncated = outcome.outputExceeded
+	if !outcome.failed && !outcome.outputExceeded {
+		return
+	}
+
+	limit, message := exceededLimit(limits, outcome)
+	if limit == "" {
+		return
+	}
+	r.LimitExceeded = limit
+	r.Error = message
+}
+
+// exceededLimit returns the name of the limit a failed run hit and a
+// message naming its value, or empty strings when no limit explains it
+func exceededLimit(limits ResourceLimits, outcome runOutcome) (string, string) {
+	cpuLimit := time.Duration(limits.CPUSeconds) * time.Second
+	memoryLimit := int64(limits.MemoryMB) << 20
+
+	memoryExceeded := limits.MemoryMB > 0 && strings.Contains(outcome.output, "runtime: out of memory")
+	processesExceeded := limits.Processes > 0 && (strings.Contains(outcome.output, "failed to create new OS thread") ||
+		strings.Contains(outcome.output, "fork/exec") && strings.Contains(outcome.output, "resource temporarily unavailable"))
+
+	// The Go runtime's own reports come first, as the crash dump that
+	// follows them may well exceed the output limit
+	switch {
+	case memoryExceeded:
+		return limitMemory, fmt.Sprintf("memory limit %s exceeded", formatBytes(memoryLimit))
+	case processesExceeded:
+		return limitProcesses, fmt.Sprintf("process limit %d exceeded", limits.Processes)
+	case outcome.outputExceeded:
+		return limitOutput, fmt.Sprintf("output limit %s exceeded", formatBytes(int64(limits.outputBytes())))
+	// The kernel kills a program at its CPU limit; allow for accounting
+	// that lags behind a little
+	case limits.CPUSeconds > 0 && (outcome.killed || outcome.timedOut) && outcome.usage.cpuTime >= cpuLimit*9/10:
+		return limitCPUTime, fmt.Sprintf("CPU time limit %ds exceeded", limits.CPUSeconds)
+	case outcome.timedOut:
+		return limitWallTime, fmt.Sprintf("wall time limit %ds exceeded", limits.WallSeconds)
+	// A container's memory limit kills the program without a word
+	case limits.MemoryMB > 0 && outcome.killed && outcome.usage.peakMemory >= memoryLimit*9/10:
+		return limitMemory, fmt.Sprintf("memory limit %s exceeded", formatBytes(memoryLimit))
+	}
+	return "", ""
+}
+
+// formatBytes prints a size in the largest binary unit that divides it
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30 && n%(1<<30) == 0:
+		return fmt.Sprintf("%dGiB", n>>30)
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMiB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKiB", n>>10)
+	}
+	return fmt.Sprintf("%dB", n)
+}
@@ -0,0 +1,30 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// canLimitProcess reports whether limitProcess applies the memory and CPU
+// limits on this platform
+const canLimitProcess = true
+
+// limitProcess applies the memory and CPU limits to a process the local
+// backend has just started. There is a short window before they take
+// effect, which is fine for a backend meant for development.
+func limitProcess(pid int, limits ResourceLimits) error {
+	rlimits := []struct {
+		resource int
+		value    uint64
+	}{
+		{unix.RLIMIT_DATA, uint64(limits.MemoryMB) << 20},
+		{unix.RLIMIT_CPU, uint64(limits.CPUSeconds)},
+	}
+	for _, limit := range rlimits {
+		if limit.value == 0 {
+			continue
+		}
+		rlimit := unix.Rlimit{Cur: limit.value, Max: limit.value}
+		if err := unix.Prlimit(pid, limit.resource, &rlimit, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+// canLimitProcess reports whether limitProcess applies the memory and CPU
+// limits on this platform
+const canLimitProcess = false
+
+// limitProcess cannot limit another process on this platform; the local
+// backend then only enforces the wall time and output limits
+func limitProcess(pid int, limits ResourceLimits) error {
+	return nil
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// lintChecks are the built-in analyzers, modelled on the staticcheck checks
+// named in their comments. They only look at the syntax of a file, so they
+// also run on code that does not compile.
+var lintChecks = []struct {
+	name string
+	run  func(pass *lintPass, node ast.Node)
+}{
+	{"bool-compare", lintBoolCompare},         // S1002
+	{"if-return", lintIfReturn},               // S1008
+	{"error-strings", lintErrorStrings},       // ST1005
+	{"underscore-names", lintUnderscoreNames}, // ST1003
+	{"blank-range", lintBlankRange},           // S1005
+	{"sprintf-constant", lintSprintfConstant}, // S1039
+	{"index-contains", lintIndexContains},     // S1003
+	{"increment", lintIncrement},              // revive increment-decrement
+	{"empty-branch", lintEmptyBranch},         // SA9003
+	{"receiver-names", lintReceiverNames},     // ST1006
+}
+
+// lintFiles runs the built-in analyzers on every Go file of a workspace.
+// Files that do not parse are left to go vet to report.
+func lintFiles(files map[string]string) []Finding {
+	findings := []Finding{}
+	for _, name := range goFileNames(files) {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, name, files[name], parser.ParseComments)
+		if err != nil {
+			continue
+		}
+		pass := &lintPass{fset: fset, file: file, name: name, src: files[name]}
+		ast.Inspect(file, func(node ast.Node) bool {
+			if node == nil {
+				return false
+			}
+			for _, check := range lintChecks {
+				pass.check = check.name
+				check.run(pass, node)
+			}
+			return true
+		})
+		findings = append(findings, pass.findings...)
+	}
+	return findings
+}
+
+// lintPass is the state of the analyzers inspecting one file
+type lintPass struct {
+	fset     *token.FileSet
+	file     *ast.File
+	name     string
+	src      string
+	check    string
+	findings []Finding
+}
+
+// report records a finding spanning node, with an optional fix
+func (p *lintPass) report(node ast.Node, message string, fix *SuggestedFix) {
+	start, end := p.fset.Position(node.Pos()), p.fset.Position(node.End())
+	finding := Finding{
+		Tool:      "lint",
+		Check:     p.check,
+		File:      p.name,
+		Line:      start.Line,
+		Column:    start.Column,
+		EndLine:   end.Line,
+		EndColumn: end.Column,
+		Message:   message,
+	}
+	if fix != nil {
+		finding.SuggestedFixes = []SuggestedFix{*fix}
+	}
+	p.findings = append(p.findings, finding)
+}
+
+// fix builds a fix replacing the source from one position up to another
+func (p *lintPass) fix(message string, from, to token.Pos, newText string) *SuggestedFix {
+	edit := textEdit(p.name, p.src, p.offset(from), p.offset(to), newText)
+	return &SuggestedFix{Message: message, Edits: []TextEdit{edit}}
+}
+
+// offset returns the byte offset of a position in the file
+func (p *lintPass) offset(pos token.Pos) int {
+	return p.fset.Position(pos).Offset
+}
+
+// text returns the source of a node
+func (p *lintPass) text(node ast.Node) string {
+	return p.src[p.offset(node.Pos()):p.offset(node.End())]
+}
+
+// isCall reports whether call calls the function name of the package with
+// the given import path
+func (p *lintPass) isCall(call *ast.CallExpr, path, name string) bool {
+	selector, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || selector.Sel.Name != name {
+		return false
+	}
+	pkg, ok := selector.X.(*ast.Ident)
+	return ok && p.importName(path) == pkg.Name
+}
+
+// importName returns the name the file imports a package under, or an empty
+// string when it does not import it
+func (p *lintPass) importName(path string) string {
+	for _, spec := range p.file.Imports {
+		if imported, _ := strconv.Unquote(spec.Path.Value); imported != path {
+			continue
+		}
+		if spec.Name != nil {
+			return spec.Name.Name
+		}
+		return path[strings.LastIndex(path, "/")+1:]
+	}
+	return ""
+}
+
+// isBool reports whether expr is the constant true or false, and which
+func isBool(expr ast.Expr) (value, ok bool) {
+	ident, isIdent := expr.(*ast.Ident)
+	if !isIdent || (ident.Name != "true" && ident.Name != "false") {
+		return false, false
+	}
+	return ident.Name == "true", true
+}
+
+// negate returns the source of an expression negated with !
+func (p *lintPass) negate(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.Ident, *ast.CallExpr, *ast.SelectorExpr, *ast.IndexExpr, *ast.ParenExpr:
+		return "!" + p.text(expr)
+	case *ast.UnaryExpr:
+		if expr.Op == token.NOT {
+			return p.text(expr.X)
+		}
+	}
+	return "!(" + p.text(expr) + ")"
+}
+
+// lintBoolCompare flags comparisons to true and false, as in "if ok == true"
+func lintBoolCompare(p *lintPass, node ast.Node) {
+	binary, ok := node.(*ast.BinaryExpr)
+	if !ok || (binary.Op != token.EQL && binary.Op != token.NEQ) {
+		return
+	}
+	value, ok := isBool(binary.Y)
+	other := binary.X
+	if !ok {
+		if value, ok = isBool(binary.X); !ok {
+			return
+		}
+		other = binary.Y
+	}
+
+	simplified := p.text(other)
+	if value != (binary.Op == token.EQL) {
+		simplified = p.negate(other)
+	}
+	p.report(binary, fmt.Sprintf("should omit comparison to bool constant, can be simplified to %s", simplified),
+		p.fix("Simplify comparison", binary.Pos(), binary.End(), simplified))
+}
+
+// lintIfReturn flags "if cond { return true }; return false", which is
+// "return cond"
+func lintIfReturn(p *lintPass, node ast.Node) {
+	var list []ast.Stmt
+	switch block := node.(type) {
+	case *ast.BlockStmt:
+		list = block.List
+	case *ast.CaseClause:
+		list = block.Body
+	case *ast.CommClause:
+		list = block.Body
+	default:
+		return
+	}
+
+	for i, stmt := range list {
+		ifStmt, ok := stmt.(*ast.IfStmt)
+		if !ok || ifStmt.Init != nil {
+			continue
+		}
+		then, ok := singleBoolReturn(ifStmt.Body.List)
+		if !ok {
+			continue
+		}
+
+		// The other result comes from an else branch or the next statement
+		var otherwise bool
+		end := ifStmt.End()
+		switch {
+		case ifStmt.Else != nil:
+			block, isBlock := ifStmt.Else.(*ast.BlockStmt)
+			if !isBlock {
+				continue
+			}
+			if otherwise, ok = singleBoolReturn(block.List); !ok {
+				continue
+			}
+		case i+1 < len(list):
+			if otherwise, ok = singleBoolReturn(list[i+1 : i+2]); !ok {
+				continue
+			}
+			end = list[i+1].End()
+		default:
+			continue
+		}
+		if then == otherwise {
+			continue
+		}
+
+		result := p.text(ifStmt.Cond)
+		if !then {
+			result = p.negate(ifStmt.Cond)
+		}
+		p.report(ifStmt, fmt.Sprintf("should use 'return %s' instead of 'if %s { return %t }; return %t'", result, p.text(ifStmt.Cond), then, otherwise),
+			p.fix("Return the condition", ifStmt.Pos(), end, "return "+result))
+	}
+}
+
+// singleBoolReturn reports whether stmts is a lone "return true" or
+// "return false", and which
+func singleBoolReturn(stmts []ast.Stmt) (value, ok bool) {
+	if len(stmts) != 1 {
+		return false, false
+	}
+	ret, isReturn := stmts[0].(*ast.ReturnStmt)
+	if !isReturn || len(ret.Results) != 1 {
+		return false, false
+	}
+	return isBool(ret.Results[0])
+}
+
+// lintErrorStrings flags error messages that start with a capital letter or
+// end with punctuation, as they are usually wrapped into other messages
+func lintErrorStrings(p *lintPass, node ast.Node) {
+	call, ok := node.(*ast.CallExpr)
+	if !ok || len(call.Args) == 0 || !(p.isCall(call, "errors", "New") || p.isCall(call, "fmt", "Errorf")) {
+		return
+	}
+	literal, ok := call.Args[0].(*ast.BasicLit)
+	if !ok || literal.Kind != token.STRING {
+		return
+	}
+	message, err := strconv.Unquote(literal.Value)
+	if err != nil || message == "" {
+		return
+	}
+
+	var problems []string
+	fixed := message
+	first, size := utf8.DecodeRuneInString(message)
+	second, _ := utf8.DecodeRuneInString(message[size:])
+	// A leading acronym such as "HTTP" stays as it is
+	if unicode.IsUpper(first) && !unicode.IsUpper(second) {
+		problems = append(problems, "be capitalized")
+		fixed = string(unicode.ToLower(first)) + fixed[size:]
+	}
+	if trimmed := strings.TrimRight(fixed, ".!:\n"); trimmed != fixed {
+		problems = append(problems, "end with punctuation or newlines")
+		fixed = trimmed
+	}
+	if len(problems) == 0 {
+		return
+	}
+
+	replacement := strconv.Quote(fixed)
+	if strings.HasPrefix(literal.Value, "`") {
+		replacement = "`" + fixed + "`"
+	}
+	p.report(literal, "error strings should not "+strings.Join(problems, " or "),
+		p.fix("Fix error string", literal.Pos(), literal.End(), replacement))
+}
+
+// lintUnderscoreNames flags declared names with underscores, which Go
+// spells in mixedCaps
+func lintUnderscoreNames(p *lintPass, node ast.Node) {
+	var names []*ast.Ident
+	switch decl := node.(type) {
+	case *ast.FuncDecl:
+		// Test functions may use underscores, as in TestParse_empty
+		for _, prefix := range []string{"Test", "Benchmark", "Example", "Fuzz"} {
+			if strings.HasPrefix(decl.Name.Name, prefix) {
+				return
+			}
+		}
+		names = append(names, decl.Name)
+	case *ast.ValueSpec:
+		names = decl.Names
+	case *ast.TypeSpec:
+		names = append(names, decl.Name)
+	case *ast.Field:
+		names = decl.Names
+	case *ast.AssignStmt:
+		if decl.Tok != token.DEFINE {
+			return
+		}
+		for _, lhs := range decl.Lhs {
+			if ident, ok := lhs.(*ast.Ident); ok {
+				names = append(names, ident)
+			}
+		}
+	case *ast.RangeStmt:
+		if decl.Tok != token.DEFINE {
+			return
+		}
+		for _, expr := range []ast.Expr{decl.Key, decl.Value} {
+			if ident, ok := expr.(*ast.Ident); ok {
+				names = append(names, ident)
+			}
+		}
+	default:
+		return
+	}
+
+	for _, name := range names {
+		if !strings.Contains(strings.Trim(name.Name, "_"), "_") {
+			continue
+		}
+		if strings.ToUpper(name.Name) == name.Name {
+			p.report(name, fmt.Sprintf("should not use ALL_CAPS in Go names; use CamelCase instead of %s", name.Name), nil)
+			continue
+		}
+		p.report(name, fmt.Sprintf("should not use underscores in Go names; %s should be %s", name.Name, mixedCaps(name.Name)), nil)
+	}
+}
+
+// mixedCaps joins the words of an underscored name in mixedCaps
+func mixedCaps(name string) string {
+	words := strings.Split(name, "_")
+	var out strings.Builder
+	out.WriteString(words[0])
+	for _, word := range words[1:] {
+		if word == "" {
+			continue
+		}
+		first, size := utf8.DecodeRuneInString(word)
+		out.WriteRune(unicode.ToUpper(first))
+		out.WriteString(word[size:])
+	}
+	return out.String()
+}
+
+// lintBlankRange flags range loops assigning to the blank identifier, as in
+// "for i, _ := range s" and "for _ = range s"
+func lintBlankRange(p *lintPass, node ast.Node) {
+	loop, ok := node.(*ast.RangeStmt)
+	if !ok || loop.Key == nil {
+		return
+	}
+	blank := func(expr ast.Expr) bool {
+		ident, ok := expr.(*ast.Ident)
+		return ok && ident.Name == "_"
+	}
+
+	switch {
+	case blank(loop.Key) && (loop.Value == nil || blank(loop.Value)):
+		p.report(loop, "should omit values from range; this loop is equivalent to 'for range ...'",
+			p.fix("Remove blank identifiers", loop.Key.Pos(), loop.Range, ""))
+	case loop.Value != nil && blank(loop.Value):
+		p.report(loop, fmt.Sprintf("should omit 2nd value from range; this loop is equivalent to 'for %s %s range ...'", p.text(loop.Key), loop.Tok),
+			p.fix("Remove blank identifier", loop.Key.End(), loop.Value.End(), ""))
+	}
+}
+
+// lintSprintfConstant flags fmt.Sprintf calls that format nothing
+func lintSprintfConstant(p *lintPass, node ast.Node) {
+	call, ok := node.(*ast.CallExpr)
+	if !ok || len(call.Args) != 1 || !p.isCall(call, "fmt", "Sprintf") {
+		return
+	}
+	literal, ok := call.Args[0].(*ast.BasicLit)
+	if !ok || literal.Kind != token.STRING || strings.Contains(literal.Value, "%") {
+		return
+	}
+	p.report(call, "unnecessary use of fmt.Sprintf",
+		p.fix("Use the string directly", call.Pos(), call.End(), literal.Value))
+}
+
+// lintIndexContains flags strings.Index and bytes.Index compared with -1 or
+// 0, which are strings.Contains and bytes.Contains
+func lintIndexContains(p *lintPass, node ast.Node) {
+	binary, ok := node.(*ast.BinaryExpr)
+	if !ok {
+		return
+	}
+	call, ok := binary.X.(*ast.CallExpr)
+	if !ok || len(call.Args) != 2 {
+		return
+	}
+	pkg := ""
+	for _, path := range []string{"strings", "bytes"} {
+		if p.isCall(call, path, "Index") {
+			pkg = p.importName(path)
+		}
+	}
+	if pkg == "" {
+		return
+	}
+
+	var contains bool
+	switch bound := p.text(binary.Y); {
+	case bound == "-1" && binary.Op == token.NEQ, bound == "-1" && binary.Op == token.GTR, bound == "0" && binary.Op == token.GEQ:
+		contains = true
+	case bound == "-1" && binary.Op == token.EQL, bound == "0" && binary.Op == token.LSS:
+		contains = false
+	default:
+		return
+	}
+
+	replacement := fmt.Sprintf("%s.Contains(%s, %s)", pkg, p.text(call.Args[0]), p.text(call.Args[1]))
+	if !contains {
+		replacement = "!" + replacement
+	}
+	p.report(binary, fmt.Sprintf("should use %s instead", replacement),
+		p.fix("Use Contains", binary.Pos(), binary.End(), replacement))
+}
+
+// lintIncrement flags "x += 1" and "x -= 1", which are "x++" and "x--"
+func lintIncrement(p *lintPass, node ast.Node) {
+	assign, ok := node.(*ast.AssignStmt)
+	if !ok || len(assign.Lhs) != 1 || len(assign.Rhs) != 1 {
+		return
+	}
+	literal, ok := assign.Rhs[0].(*ast.BasicLit)
+	if !ok || literal.Kind != token.INT || literal.Value != "1" {
+		return
+	}
+
+	var op string
+	switch assign.Tok {
+	case token.ADD_ASSIGN:
+		op = "++"
+	case token.SUB_ASSIGN:
+		op = "--"
+	default:
+		return
+	}
+	replacement := p.text(assign.Lhs[0]) + op
+	p.report(assign, fmt.Sprintf("should replace %s with %s", p.text(assign), replacement),
+		p.fix("Use "+op, assign.Pos(), assign.End(), replacement))
+}
+
+// lintEmptyBranch flags if and else blocks with neither statements nor
+// comments
+func lintEmptyBranch(p *lintPass, node ast.Node) {
+	ifStmt, ok := node.(*ast.IfStmt)
+	if !ok {
+		return
+	}
+	if p.emptyBlock(ifStmt.Body) {
+		p.report(ifStmt.Body, "empty branch", nil)
+	}
+	if block, ok := ifStmt.Else.(*ast.BlockStmt); ok && p.emptyBlock(block) {
+		p.report(block, "empty branch", nil)
+	}
+}
+
+// emptyBlock reports whether a block has neither statements nor comments
+func (p *lintPass) emptyBlock(block *ast.BlockStmt) bool {
+	if len(block.List) > 0 {
+		return false
+	}
+	for _, group := range p.file.Comments {
+		if group.Pos() > block.Lbrace && group.End() < block.Rbrace {
+			return false
+		}
+	}
+	return true
+}
+
+// lintReceiverNames flags receivers named this or self
+func lintReceiverNames(p *lintPass, node ast.Node) {
+	decl, ok := node.(*ast.FuncDecl)
+	if !ok || decl.Recv == nil {
+		return
+	}
+	for _, field := range decl.Recv.List {
+		for _, name := range field.Names {
+			if name.Name == "this" || name.Name == "self" {
+				p.report(name, "receiver name should be a reflection of its identity; don't use generic names such as \"this\" or \"self\"", nil)
+			}
+		}
+	}
+}
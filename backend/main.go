@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -13,12 +17,70 @@ import (
 // CodeExecutionRequest represents a request to execute Go code
 type CodeExecutionRequest struct {
 	Code string `json:"code"`
+
+	// Files holds further files of the module keyed by their path, such as
+	// "go.mod" or "greet/greet.go". Code, when set, is main.go.
+	Files map[string]string `json:"files,omitempty"`
+
+	// Stdin is fed to the program before any input typed over the WebSocket
+	Stdin string `json:"stdin,omitempty"`
+
+	// GoVersion selects the Go release to build with, such as "1.22" or
+	// "1.22.5"; empty means the default release
+	GoVersion string `json:"go_version,omitempty"`
+
+	// LessonID records the execution under a lesson in the user's history;
+	// it is zero for code outside of a lesson
+	LessonID int `json:"lesson_id,omitempty"`
+
+	// UserID is the logged-in user, taken from the request's token
+	UserID string `json:"-"`
+
+	// TestCode is a hidden main_test.go to run instead of the program. It is
+	// filled in by the server from the lesson and never accepted from clients.
+	TestCode string `json:"-"`
 }
 
 // CodeExecutionResponse represents the response from code execution
 type CodeExecutionResponse struct {
+	// Output interleaves stdout and stderr as they were written
 	Output string `json:"output"`
 	Error  string `json:"error,omitempty"`
+
+	// GoVersion is the release the program was built with
+	GoVersion string `json:"go_version,omitempty"`
+
+	// ExecutionID identifies the execution in the user's history
+	ExecutionID int64 `json:"execution_id,omitempty"`
+
+	// The program's output per stream, and as a timeline of chunks
+	Stdout   string        `json:"stdout"`
+	Stderr   string        `json:"stderr"`
+	Timeline []OutputChunk `json:"timeline,omitempty"`
+
+	// Truncated is set when the program wrote more than the output limit;
+	// Output then holds only its beginning and end
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Compile phase; Cached is set when the binary came from the build cache
+	CompileOutput string       `json:"compile_output,omitempty"`
+	Diagnostics   []Diagnostic `json:"diagnostics,omitempty"`
+	CompileTimeMs int64        `json:"compile_time_ms"`
+	Cached        bool         `json:"cached,omitempty"`
+
+	// Run phase; ExitCode is unset if the program never ran or was killed
+	RunTimeMs int64 `json:"run_time_ms"`
+	ExitCode  *int  `json:"exit_code,omitempty"`
+
+	// Resources the program used, and the limit that stopped it, if any
+	PeakMemoryBytes int64  `json:"peak_memory_bytes,omitempty"`
+	CPUTimeMs       int64  `json:"cpu_time_ms,omitempty"`
+	LimitExceeded   string `json:"limit_exceeded,omitempty"`
+
+	// Panic describes the panic or fatal error that ended the program
+	Panic *PanicInfo `json:"panic,omitempty"`
+
+	Tests []TestResult `json:"tests,omitempty"`
 }
 
 // UserProgress represents user's learning progress
@@ -27,6 +89,9 @@ type UserProgress struct {
 	LessonID    int     `json:"lesson_id"`
 	Completed   bool    `json:"completed"`
 	CompletedAt *string `json:"completed_at,omitempty"`
+
+	// LessonVersion is the version of the lesson that was completed
+	LessonVersion int `json:"lesson_version,omitempty"`
 }
 
 // WebSocket connection manager
@@ -37,19 +102,121 @@ var upgrader = websocket.Upgrader{
 }
 
 func main() {
-	// Initialize execution service
-	executionService = NewCodeExecutionService()
+	// The namespace sandbox re-executes this binary as its init process
+	if len(os.Args) > 1 && os.Args[1] == sandboxInitCommand {
+		runSandboxInit(os.Args[2:])
+		return
+	}
 
-	// Initialize database
+	cfg := LoadConfig()
+
+	if len(os.Args) > 1 && os.Args[1] == seedModulesCommand {
+		log.Printf("📦 Seeding module cache %s", cfg.Executor.Modules.Dir)
+		if err := seedModules(context.Background(), cfg.Executor.Modules); err != nil {
+			log.Fatalf("Failed to seed module cache: %v", err)
+		}
+		log.Println("✅ Module cache seeded")
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == mockOIDCCommand {
+		log.Fatal(runMockOIDC(getEnv("MOCK_OIDC_ADDR", "localhost:9096")))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == validateLessonsCommand {
+		if err := runValidateLessons(cfg.Database, os.Args[2:]); err != nil {
+			log.Fatalf("Invalid lessons: %v", err)
+		}
+		log.Println("✅ Lessons are valid")
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == setRoleCommand {
+		if err := runSetRole(cfg.Database, os.Args[2:]); err != nil {
+			log.Fatalf("Failed to set role: %v", err)
+		}
+		log.Printf("✅ Role of %s set to %s", os.Args[2], os.Args[3])
+		return
+	}
+
+	// Initialize code executor
 	var err error
-	database, err = NewDatabase()
+	buildCache, err = NewBuildCache(cfg.Executor.BuildCache)
+	if err != nil {
+		log.Fatalf("Failed to initialize build cache: %v", err)
+	}
+	executor, err = NewExecutor(cfg.Executor, buildCache)
+	if err != nil {
+		log.Fatalf("Failed to initialize executor: %v", err)
+	}
+	warnAboutExecutor(executor)
+	if err := executor.Check(context.Background()); err != nil {
+		log.Printf("⚠️  Executor %s is not working: %v", executor.Name(), err)
+	} else {
+		log.Printf("✅ Executor backend: %s", executor.Name())
+	}
+	if _, err := os.Stat(cfg.Executor.Modules.Dir); err != nil {
+		log.Printf("⚠️  Module cache %s is missing, only the standard library can be imported; run with %s to fill it", cfg.Executor.Modules.Dir, seedModulesCommand)
+	}
+
+	// Index the standard library for the formatter before the first save
+	formatter = NewFormatter(cfg.Executor.Modules)
+	go func() {
+		if err := formatter.Check(); err != nil {
+			log.Printf("⚠️  Formatting will not add missing imports: %v", err)
+		}
+	}()
+
+	scheduler = NewScheduler(cfg.Scheduler)
+	log.Printf("✅ Running up to %d programs at once, queueing %d more", cfg.Scheduler.Workers, cfg.Scheduler.QueueSize)
+
+	// Initialize database
+	database, err = NewDatabase(cfg.Database)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer database.Close()
 
-	// Initialize Gin router
-	r := gin.Default()
+	tokens, err = NewTokenSigner(cfg.Auth)
+	if err != nil {
+		log.Fatalf("Failed to initialize token signer: %v", err)
+	}
+	if cfg.Auth.OIDC.Issuer != "" {
+		oidc, err = NewOIDCProvider(cfg.Auth.OIDC, cfg.Auth.FrontendURL)
+		if err != nil {
+			log.Fatalf("Failed to initialize single sign-on: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if _, err := oidc.discover(ctx); err != nil {
+			log.Printf("⚠️  Single sign-on with %s is not working yet: %v", cfg.Auth.OIDC.Issuer, err)
+		} else {
+			log.Printf("✅ Single sign-on with %s", cfg.Auth.OIDC.Issuer)
+		}
+		cancel()
+	}
+
+	// Compile the lesson solutions in the background so that they, and the
+	// standard library in GOCACHE, are ready before the first learner asks
+	go warmBuildCache(context.Background())
+
+	r, err := newRouter(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Failed to set trusted proxies: %v", err)
+	}
+
+	// Start server
+	log.Printf("🚀 Go Tutorial Server starting on port %s", cfg.Port)
+	log.Fatal(r.Run(":" + cfg.Port))
+}
+
+// newRouter sets up the API routes. Client addresses are only taken from
+// forwarding headers set by one of the trusted proxies.
+func newRouter(trustedProxies []string) (*gin.Engine, error) {
+	r := gin.New()
+	r.Use(gin.LoggerWithFormatter(logRequest), gin.Recovery())
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, err
+	}
 
 	// Configure CORS
 	config := cors.DefaultConfig()
@@ -60,46 +227,110 @@ func main() {
 
 	// API routes
 	api := r.Group("/api")
+	api.Use(authenticate)
 	{
 		// Health check
-		api.GET("/health", func(c *gin.Context) {
-			c.JSON(http.StatusOK, gin.H{"status": "healthy"})
-		})
-
-		// Code execution endpoint
-		api.POST("/execute", executeCode)
+		api.GET("/health", healthCheck)
+		api.GET("/metrics", getMetrics)
+
+		// Code execution endpoints
+		api.POST("/execute", limitRequestBody, executeCode)
+		api.POST("/submit", limitRequestBody, submitCode)
+		api.POST("/check", limitRequestBody, checkCode)
+		api.POST("/format", limitRequestBody, formatCode)
+
+		// Account endpoints
+		api.POST("/auth/register", register)
+		api.POST("/auth/login", login)
+		api.GET("/auth/me", requireUser, getCurrentUser)
+		api.GET("/auth/providers", getAuthProviders)
+		if oidc != nil {
+			api.GET("/auth/oidc/login", oidcLogin)
+			api.GET("/auth/oidc/callback", oidcCallback)
+		}
 
 		// Lessons endpoints
 		api.GET("/lessons", getLessons)
 		api.GET("/lessons/:id", getLesson)
 
 		// Progress endpoints
-		api.GET("/progress/:user_id", getUserProgress)
-		api.POST("/progress", updateProgress)
+		api.GET("/progress", requireUser, getUserProgress)
+		api.POST("/progress/merge", requireUser, mergeProgressTokens)
+
+		// Execution history endpoints
+		api.GET("/history", requireUser, getExecutionHistory)
+		api.GET("/history/:execution_id", requireUser, getExecution)
+
+		// Instructor endpoints
+		students := api.Group("/students", authorize(PermViewStudents))
+		students.GET("", getStudents)
+		students.GET("/:user_id/progress", getStudentProgress)
+		students.GET("/:user_id/history", getStudentHistory)
+		students.GET("/:user_id/history/:execution_id", getStudentExecution)
+
+		// Admin endpoints
+		admin := api.Group("/admin", requireRole(RoleAdmin))
+		admin.GET("/users", authorize(PermManageUsers), getUsers)
+		admin.PUT("/users/:user_id/role", authorize(PermManageUsers), updateUserRole)
+		admin.GET("/instructors/:user_id/students", authorize(PermManageUsers), getInstructorStudents)
+		admin.PUT("/instructors/:user_id/students/:student_id", authorize(PermManageUsers), addStudent)
+		admin.DELETE("/instructors/:user_id/students/:student_id", authorize(PermManageUsers), removeStudent)
+		admin.GET("/audit", authorize(PermViewAudit), getAuditLog)
+		admin.GET("/lessons", authorize(PermEditLessons), getAllLessons)
+		admin.POST("/lessons", authorize(PermEditLessons), createLesson)
+		admin.PUT("/lessons/order", authorize(PermEditLessons), reorderLessons)
+		admin.PUT("/lessons/:id", authorize(PermEditLessons), updateLesson)
+		admin.DELETE("/lessons/:id", authorize(PermEditLessons), retireLesson)
+		admin.POST("/lessons/:id/restore", authorize(PermEditLessons), restoreLesson)
+		admin.GET("/lessons/:id/versions", authorize(PermEditLessons), getLessonVersions)
+		admin.GET("/lessons/:id/versions/:version", authorize(PermEditLessons), getLessonVersion)
+		admin.POST("/lessons/:id/versions/:version/revert", authorize(PermEditLessons), revertLesson)
 
 		// WebSocket endpoint for real-time features
 		api.GET("/ws", handleWebSocket)
+		api.POST("/ws/ticket", requireUser, issueTicket)
 	}
 
 	// Serve static files (for production)
 	r.Static("/static", "./static")
 
-	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
+	return r, nil
+}
 
-	log.Printf("🚀 Go Tutorial Server starting on port %s", port)
-	log.Fatal(r.Run(":" + port))
+// logRequest formats the access log line of a request like gin's default
+// logger, but leaves out the query, which may carry a WebSocket ticket or
+// OIDC authorization code
+func logRequest(param gin.LogFormatterParams) string {
+	path, _, _ := strings.Cut(param.Path, "?")
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		path,
+		param.ErrorMessage,
+	)
 }
 
-// Global execution service
-var executionService *CodeExecutionService
+// Global code executor
+var executor Executor
 
 // Global database instance
 var database *Database
 
+// Global build cache shared by the executor backends
+var buildCache *BuildCache
+
+// Global scheduler every execution must get a worker slot from
+var scheduler *Scheduler
+
+// limitRequestBody cuts off request bodies larger than a workspace can be
+func limitRequestBody(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxRequestBytes)
+	c.Next()
+}
+
 // executeCode handles Go code execution requests
 func executeCode(c *gin.Context) {
 	var req CodeExecutionRequest
@@ -107,79 +338,118 @@ func executeCode(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	// Execute code using the execution service
-	response, err := executionService.ExecuteCodeFallback(req.Code)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if _, err := req.workspaceFiles(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := resolveGoVersion(c.Request.Context(), &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, response)
-}
-
-// getUserProgress returns user's learning progress
-func getUserProgress(c *gin.Context) {
-	userID := c.Param("user_id")
-
-	// Get progress from database
-	progress, err := database.GetUserProgress(userID)
+	req.UserID = currentUserID(c)
+	release, err := scheduler.Acquire(c.Request.Context(), requestUser(c), nil)
 	if err != nil {
-		log.Printf("Error getting user progress: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user progress"})
+		respondNotScheduled(c, err)
 		return
 	}
+	defer release()
 
-	// Always return an empty array if no progress found
-	if len(progress) == 0 {
-		progress = []UserProgress{}
+	// Execute code using the configured backend
+	start := time.Now()
+	response, err := executor.Execute(c.Request.Context(), &req, nil)
+	if err != nil {
+		respondExecutionError(c, err)
+		return
 	}
+	response.ExecutionID = recordRun(&req, response, time.Since(start))
 
-	c.JSON(http.StatusOK, progress)
+	c.JSON(http.StatusOK, response)
 }
 
-// updateProgress updates user's learning progress
-func updateProgress(c *gin.Context) {
-	var progress UserProgress
-	if err := c.ShouldBindJSON(&progress); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+// healthCheck reports the server status and whether the executor works
+func healthCheck(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	status := "healthy"
+	executorStatus := gin.H{"backend": executor.Name(), "healthy": true}
+	if err := executor.Check(ctx); err != nil {
+		status = "degraded"
+		executorStatus["healthy"] = false
+		executorStatus["error"] = err.Error()
 	}
-
-	// Update progress in database
-	if err := database.UpdateUserProgress(progress); err != nil {
-		log.Printf("Error updating user progress: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update progress"})
-		return
+	if toolchains, err := executor.Toolchains(ctx); err == nil {
+		executorStatus["go_versions"] = toolchains.Versions()
+		executorStatus["default_go_version"] = toolchains.Default().Version
+	} else if status == "healthy" {
+		status = "degraded"
+		executorStatus["healthy"] = false
+		executorStatus["error"] = err.Error()
 	}
 
-	log.Printf("Progress updated for user %s, lesson %d: completed=%v",
-		progress.UserID, progress.LessonID, progress.Completed)
+	c.JSON(http.StatusOK, gin.H{"status": status, "executor": executorStatus})
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Progress updated successfully"})
+// getMetrics reports execution counters
+func getMetrics(c *gin.Context) {
+	metrics := gin.H{"build_cache": buildCache.Stats(), "scheduler": scheduler.Stats()}
+	if reporter, ok := executor.(MetricsReporter); ok {
+		metrics["executor"] = reporter.Metrics()
+	}
+	c.JSON(http.StatusOK, metrics)
 }
 
-// handleWebSocket handles WebSocket connections for real-time features
-func handleWebSocket(c *gin.Context) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+// warmBuildCache runs every lesson solution once so that their binaries are
+// cached
+func warmBuildCache(ctx context.Context) {
+	lessons, err := database.GetLessons(false)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		log.Printf("⚠️  Cannot warm build cache: %v", err)
 		return
 	}
-	defer conn.Close()
 
-	// Handle WebSocket messages
-	for {
-		messageType, message, err := conn.ReadMessage()
+	start := time.Now()
+	warmed := 0
+	for _, lesson := range lessons {
+		solution := &CodeExecutionRequest{Code: lesson.Solution, Files: lesson.SolutionFiles, GoVersion: lesson.GoVersion}
+		if err := resolveGoVersion(ctx, solution); err != nil {
+			log.Printf("⚠️  Solution of lesson %d cannot be built: %v", lesson.ID, err)
+			continue
+		}
+		release, err := scheduler.Acquire(ctx, "warmup", nil)
 		if err != nil {
-			log.Printf("WebSocket read error: %v", err)
-			break
+			log.Printf("⚠️  Cannot warm build cache: %v", err)
+			return
 		}
-
-		// Echo the message back (for now)
-		if err := conn.WriteMessage(messageType, message); err != nil {
-			log.Printf("WebSocket write error: %v", err)
-			break
+		response, err := executor.Execute(ctx, solution, nil)
+		release()
+		if err != nil {
+			log.Printf("⚠️  Cannot warm build cache: %v", err)
+			return
+		}
+		if response.Error != "" {
+			log.Printf("⚠️  Solution of lesson %d failed: %s", lesson.ID, response.Error)
+			continue
 		}
+		warmed++
+	}
+	log.Printf("🔥 Build cache warmed with %d lesson solutions in %v", warmed, time.Since(start).Round(time.Millisecond))
+}
+
+// getUserProgress returns the logged-in user's learning progress
+func getUserProgress(c *gin.Context) {
+	progress, err := database.GetUserProgress(currentUserID(c))
+	if err != nil {
+		log.Printf("Error getting user progress: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user progress"})
+		return
 	}
+
+	// Always return an empty array if no progress found
+	if len(progress) == 0 {
+		progress = []UserProgress{}
+	}
+
+	c.JSON(http.StatusOK, progress)
 }
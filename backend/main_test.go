@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestMain runs the handler tests against a fresh database in a temporary
+// directory, with the lessons of the lessons directory
+func TestMain(m *testing.M) {
+	os.Exit(runHandlerTests(m))
+}
+
+func runHandlerTests(m *testing.M) int {
+	lessonsDir, err := filepath.Abs("lessons")
+	if err != nil {
+		log.Fatal(err)
+	}
+	dir, err := os.MkdirTemp("", "go-tutorial-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chdir(dir); err != nil {
+		log.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	log.SetOutput(io.Discard)
+	database, err = NewDatabase(DatabaseConfig{LessonsDir: lessonsDir, HistoryPerLesson: 100})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer database.Close()
+	tokens, err = NewTokenSigner(AuthConfig{Secret: "test secret", TokenTTLHours: 1, ProgressTokenTTLHours: 1})
+	if err != nil {
+		log.Fatal(err)
+	}
+	scheduler = NewScheduler(SchedulerConfig{Workers: 2, QueueSize: 4})
+
+	return m.Run()
+}
+
+// useFakeExecutor makes the handlers run code with a fake backend that
+// answers with respond
+func useFakeExecutor(t *testing.T, respond func(req *CodeExecutionRequest) (*CodeExecutionResponse, error)) *FakeExecutor {
+	t.Helper()
+	fake := NewFakeExecutor()
+	fake.Respond = respond
+	previous := executor
+	executor = fake
+	t.Cleanup(func() { executor = previous })
+	return fake
+}
+
+// newTestUser creates an account with the given role and returns it with a
+// session token
+func newTestUser(t *testing.T, role Role) (*User, string) {
+	t.Helper()
+	user, err := database.CreateUser(newUserID(), "test_"+randomToken()[:12], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != RoleLearner {
+		if err := database.SetUserRole(user.ID, role); err != nil {
+			t.Fatal(err)
+		}
+		user.Role = role
+	}
+	token, _ := tokens.session(user)
+	return user, token
+}
+
+// serve sends a request to the API router and decodes the JSON answer into
+// out, when out is not nil
+func serve(t *testing.T, method, path string, body any, token string, out any) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	router, err := newRouter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.ServeHTTP(recorder, req)
+	if out != nil {
+		if err := json.Unmarshal(recorder.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: cannot decode %q: %v", method, path, recorder.Body.String(), err)
+		}
+	}
+	return recorder
+}
+
+func TestHealth(t *testing.T) {
+	useFakeExecutor(t, nil)
+
+	var health struct {
+		Status   string `json:"status"`
+		Executor struct {
+			Backend    string   `json:"backend"`
+			Healthy    bool     `json:"healthy"`
+			GoVersions []string `json:"go_versions"`
+		} `json:"executor"`
+	}
+	recorder := serve(t, http.MethodGet, "/api/health", nil, "", &health)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", recorder.Code)
+	}
+	if health.Status != "healthy" || health.Executor.Backend != "fake" || !health.Executor.Healthy {
+		t.Errorf("health = %+v, want a healthy fake backend", health)
+	}
+	if len(health.Executor.GoVersions) != 1 {
+		t.Errorf("go_versions = %v, want the fake backend's release", health.Executor.GoVersions)
+	}
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       any
+		respond    func(req *CodeExecutionRequest) (*CodeExecutionResponse, error)
+		wantStatus int
+		wantOutput string
+		wantRun    bool
+	}{
+		{
+			name: "runs code",
+			body: gin.H{"code": "package main"},
+			respond: func(req *CodeExecutionRequest) (*CodeExecutionResponse, error) {
+				return &CodeExecutionResponse{Output: "ran " + req.Code}, nil
+			},
+			wantStatus: http.StatusOK,
+			wantOutput: "ran package main",
+			wantRun:    true,
+		},
+		{
+			name:       "rejects a request without code",
+			body:       gin.H{"stdin": "input"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "rejects an unsafe path",
+			body:       gin.H{"files": gin.H{"../main.go": "package main"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "rejects a body larger than a workspace",
+			body:       gin.H{"code": "package main\n\n//" + strings.Repeat("x", maxRequestBytes)},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "rejects an unknown Go version",
+			body:       gin.H{"code": "package main", "go_version": "1.2"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "reports a failing backend",
+			body: gin.H{"code": "package main"},
+			respond: func(req *CodeExecutionRequest) (*CodeExecutionResponse, error) {
+				return nil, errors.New("backend down")
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantRun:    true,
+		},
+		{
+			name: "asks to retry a busy backend",
+			body: gin.H{"code": "package main"},
+			respond: func(req *CodeExecutionRequest) (*CodeExecutionResponse, error) {
+				return nil, &BusyError{Message: "busy", RetryAfter: 1500 * time.Millisecond}
+			},
+			wantStatus: http.StatusTooManyRequests,
+			wantRun:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeExecutor(t, tt.respond)
+
+			var response CodeExecutionResponse
+			recorder := serve(t, http.MethodPost, "/api/execute", tt.body, "", &response)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			if response.Output != tt.wantOutput {
+				t.Errorf("output %q, want %q", response.Output, tt.wantOutput)
+			}
+			if ran := len(fake.Requests()) > 0; ran != tt.wantRun {
+				t.Errorf("backend ran the code: %v, want %v", ran, tt.wantRun)
+			}
+			if tt.wantStatus == http.StatusTooManyRequests && recorder.Header().Get("Retry-After") != "2" {
+				t.Errorf("Retry-After %q, want 2", recorder.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+// printsHello answers like a program that prints what lesson 1 asks for
+// when its code does
+func printsHello(req *CodeExecutionRequest) (*CodeExecutionResponse, error) {
+	if strings.Contains(req.Code, `"Hello, World!"`) {
+		return &CodeExecutionResponse{Output: "Hello, World!\n"}, nil
+	}
+	return &CodeExecutionResponse{Output: "Hello\n"}, nil
+}
+
+func TestSubmit(t *testing.T) {
+	const solution = "package main\n\nimport \"fmt\"\n\nfunc main() {\n    fmt.Println(\"Hello, World!\")\n}"
+	const wrong = "package main\n\nimport \"fmt\"\n\nfunc main() {\n    fmt.Println(\"Hello\")\n}"
+
+	tests := []struct {
+		name       string
+		body       any
+		wantStatus int
+		wantPassed bool
+		wantDiff   bool
+		wantToken  bool
+	}{
+		{"passes matching output", gin.H{"lesson_id": 1, "code": solution, "progress_nonce": "browser"}, http.StatusOK, true, false, true},
+		{"passes without a progress nonce", gin.H{"lesson_id": 1, "code": solution}, http.StatusOK, true, false, false},
+		{"fails other output", gin.H{"lesson_id": 1, "code": wrong, "progress_nonce": "browser"}, http.StatusOK, false, true, false},
+		{"rejects an unknown lesson", gin.H{"lesson_id": 9999, "code": solution}, http.StatusNotFound, false, false, false},
+		{"rejects a request without a lesson", gin.H{"code": solution}, http.StatusBadRequest, false, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeExecutor(t, printsHello)
+
+			var result CodeSubmissionResponse
+			recorder := serve(t, http.MethodPost, "/api/submit", tt.body, "", &result)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			if result.Passed != tt.wantPassed {
+				t.Errorf("passed %v, want %v", result.Passed, tt.wantPassed)
+			}
+			if (result.Diff != "") != tt.wantDiff {
+				t.Errorf("diff %q, want one: %v", result.Diff, tt.wantDiff)
+			}
+			// Only anonymous passes from a browser with a nonce get a token
+			// to merge later
+			if (result.ProgressToken != "") != tt.wantToken {
+				t.Errorf("progress token %q, want one: %v", result.ProgressToken, tt.wantToken)
+			}
+		})
+	}
+}
+
+func TestSubmitRecordsProgress(t *testing.T) {
+	fake := useFakeExecutor(t, printsHello)
+	user, token := newTestUser(t, RoleLearner)
+
+	body := gin.H{"lesson_id": 1, "code": "package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println(\"Hello, World!\") }"}
+	var result CodeSubmissionResponse
+	if recorder := serve(t, http.MethodPost, "/api/submit", body, token, &result); recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body)
+	}
+	if !result.Passed || result.ProgressToken != "" || result.ExecutionID == 0 {
+		t.Fatalf("result = %+v, want a recorded pass without a progress token", result)
+	}
+
+	// The solution and the submission each run once
+	if requests := fake.Requests(); len(requests) != 2 || requests[1].UserID != user.ID {
+		t.Errorf("backend ran %d requests, want the solution and the user's submission", len(requests))
+	}
+
+	var progress []UserProgress
+	serve(t, http.MethodGet, "/api/progress", nil, token, &progress)
+	if len(progress) != 1 || progress[0].LessonID != 1 || !progress[0].Completed || progress[0].LessonVersion != 1 {
+		t.Errorf("progress = %+v, want lesson 1 version 1 completed", progress)
+	}
+}
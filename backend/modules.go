@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// seedModulesCommand is the first argument that makes the server binary fill
+// the module cache with the allowlisted modules and exit
+const seedModulesCommand = "seed-modules"
+
+// defaultAllowedModules is the curated set of third-party modules learners
+// may import, pinned to the version that is seeded into the module cache
+var defaultAllowedModules = []string{
+	"github.com/google/uuid@v1.6.0",
+	"github.com/fatih/color@v1.18.0",
+	"github.com/shopspring/decimal@v1.4.0",
+	"github.com/spf13/cobra@v1.8.1",
+	"golang.org/x/text@v0.14.0",
+}
+
+// ModulesConfig describes the offline module cache used when compiling
+type ModulesConfig struct {
+	// Dir is a module cache (a GOMODCACHE) seeded with the allowed modules.
+	// Builds read it through a file:// GOPROXY and never reach the network.
+	Dir string
+
+	// Allowed lists the importable modules as "path@version"
+	Allowed []string
+}
+
+// modulePaths returns the allowed module paths without their versions
+func (c ModulesConfig) modulePaths() []string {
+	paths := make([]string, 0, len(c.Allowed))
+	for _, module := range c.Allowed {
+		path, _, _ := strings.Cut(module, "@")
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// buildEnv returns the environment for the go command so that modules are
+// only ever resolved from the seeded cache
+func (c ModulesConfig) buildEnv() []string {
+	dir, err := filepath.Abs(c.Dir)
+	if err != nil {
+		dir = c.Dir
+	}
+	return []string{
+		"GOMODCACHE=" + dir,
+		"GOPROXY=file://" + filepath.ToSlash(filepath.Join(dir, "cache", "download")),
+		"GOSUMDB=off",
+		"GOFLAGS=-mod=mod",
+		"GOTOOLCHAIN=local",
+	}
+}
+
+// checkImports rejects imports of third-party modules that are not on the
+// allowlist before anything is compiled, along with a go.mod that could get
+// around it. Files that do not parse are left to the compiler to report.
+func (c ModulesConfig) checkImports(files map[string]string) *CodeExecutionResponse {
+	allowed := c.modulePaths()
+	modulePath, rejected := checkGoMod(files["go.mod"], allowed)
+	if len(rejected) > 0 {
+		return rejectedModules("Module file not allowed", rejected, allowed)
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fset := token.NewFileSet()
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, files[name], parser.ImportsOnly)
+		if err != nil {
+			continue
+		}
+		for _, spec := range file.Imports {
+			path, err := strconv.Unquote(spec.Path.Value)
+			if err != nil || importAllowed(path, modulePath, allowed) {
+				continue
+			}
+			pos := fset.Position(spec.Path.Pos())
+			rejected = append(rejected, Diagnostic{
+				File:    name,
+				Line:    pos.Line,
+				Column:  pos.Column,
+				Message: fmt.Sprintf("import %q is not allowed: its module is not on the allowlist", path),
+			})
+		}
+	}
+	if len(rejected) == 0 {
+		return nil
+	}
+	return rejectedModules("Import not allowed", rejected, allowed)
+}
+
+// rejectedModules builds the response for a workspace that uses modules it
+// may not use
+func rejectedModules(message string, rejected []Diagnostic, allowed []string) *CodeExecutionResponse {
+	var output strings.Builder
+	for _, d := range rejected {
+		fmt.Fprintf(&output, "%s:%d:%d: %s\n", d.File, d.Line, d.Column, d.Message)
+	}
+	if len(allowed) > 0 {
+		fmt.Fprintf(&output, "allowed third-party modules: %s\n", strings.Join(allowed, ", "))
+	} else {
+		output.WriteString("only the standard library may be imported\n")
+	}
+
+	return &CodeExecutionResponse{
+		Error:         message,
+		CompileOutput: output.String(),
+		Diagnostics:   rejected,
+	}
+}
+
+// checkGoMod parses the go.mod of a workspace and returns its module path
+// along with anything in it that could reach beyond the allowlist: a module
+// path that claims a domain, and with it the cached modules below it, a
+// replace directive, which the host build would resolve from the host's file
+// system, and a requirement of a module that is not allowed.
+func checkGoMod(gomod string, allowed []string) (string, []Diagnostic) {
+	file, err := modfile.Parse("go.mod", []byte(gomod), nil)
+	if err != nil {
+		var errs modfile.ErrorList
+		if errors.As(err, &errs) {
+			rejected := make([]Diagnostic, 0, len(errs))
+			for _, e := range errs {
+				rejected = append(rejected, Diagnostic{File: "go.mod", Line: e.Pos.Line, Message: e.Err.Error()})
+			}
+			return "", rejected
+		}
+		return "", []Diagnostic{{File: "go.mod", Message: err.Error()}}
+	}
+
+	var rejected []Diagnostic
+	reject := func(line *modfile.Line, format string, args ...any) {
+		d := Diagnostic{File: "go.mod", Message: fmt.Sprintf(format, args...)}
+		if line != nil {
+			d.Line = line.Start.Line
+			d.Column = line.Start.LineRune
+		}
+		rejected = append(rejected, d)
+	}
+
+	if file.Module == nil {
+		reject(nil, "go.mod has no module directive")
+		return "", rejected
+	}
+	modulePath := file.Module.Mod.Path
+	if first, _, _ := strings.Cut(modulePath, "/"); strings.Contains(first, ".") {
+		reject(file.Module.Syntax, "module path %q is not allowed: its first element must not contain a dot", modulePath)
+	}
+	for _, replace := range file.Replace {
+		reject(replace.Syntax, "replace directive for %q is not allowed", replace.Old.Path)
+	}
+	for _, require := range file.Require {
+		if !slices.Contains(allowed, require.Mod.Path) {
+			reject(require.Syntax, "requirement %q is not allowed: its module is not on the allowlist", require.Mod.Path)
+		}
+	}
+	return modulePath, rejected
+}
+
+// importAllowed reports whether an import path belongs to the standard
+// library, to the learner's own module or to an allowed module
+func importAllowed(path, modulePath string, allowed []string) bool {
+	if withinModule(path, modulePath) {
+		return true
+	}
+	// Standard library paths have no dot in their first element
+	first, _, _ := strings.Cut(path, "/")
+	if !strings.Contains(first, ".") {
+		return true
+	}
+	for _, module := range allowed {
+		if withinModule(path, module) {
+			return true
+		}
+	}
+	return false
+}
+
+// withinModule reports whether path is module or one of its packages
+func withinModule(path, module string) bool {
+	return path == module || strings.HasPrefix(path, module+"/")
+}
+
+// workspaceModulePath returns the path declared by a go.mod file
+func workspaceModulePath(gomod string) string {
+	for _, line := range strings.Split(gomod, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
+
+// seedModules downloads the allowed modules and their dependencies into the
+// module cache. It is the only step that needs network access.
+func seedModules(ctx context.Context, cfg ModulesConfig) error {
+	dir, err := filepath.Abs(cfg.Dir)
+	if err != nil {
+		return fmt.Errorf("invalid module cache dir: %v", err)
+	}
+
+	work, err := os.MkdirTemp("", "go_seed_")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(work)
+
+	// A throwaway module requiring every allowed module makes go mod download
+	// fetch the whole build list. The old go version turns off module graph
+	// pruning, so that every go.mod file a learner's build may consult is
+	// fetched as well.
+	var gomod strings.Builder
+	gomod.WriteString("module seed\n\ngo 1.16\n\nrequire (\n")
+	for _, module := range cfg.Allowed {
+		path, version, ok := strings.Cut(module, "@")
+		if !ok {
+			return fmt.Errorf("allowed module %q has no version", module)
+		}
+		fmt.Fprintf(&gomod, "\t%s %s\n", path, version)
+	}
+	gomod.WriteString(")\n")
+	if err := os.WriteFile(filepath.Join(work, "go.mod"), []byte(gomod.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write go.mod: %v", err)
+	}
+
+	cmd := exec.CommandContext(ctx, "go", "mod", "download", "all")
+	cmd.Dir = work
+	cmd.Env = append(os.Environ(), "GOMODCACHE="+dir, "GOFLAGS=-mod=mod")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go mod download failed: %v", err)
+	}
+	return nil
+}
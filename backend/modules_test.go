@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestCheckImports(t *testing.T) {
+	modules := ModulesConfig{Allowed: []string{"github.com/google/uuid@v1.6.0", "github.com/fatih/color@v1.18.0"}}
+	const colorMain = "package main\n\nimport _ \"github.com/fatih/color\"\n\nfunc main() {}\n"
+	const sysMain = "package main\n\nimport _ \"golang.org/x/sys/unix\"\n\nfunc main() {}\n"
+
+	tests := []struct {
+		name      string
+		files     map[string]string
+		wantError string
+		wantLine  int
+	}{
+		{
+			name:  "allowed module",
+			files: map[string]string{"go.mod": "module sandbox\n\ngo 1.21\n", "main.go": colorMain},
+		},
+		{
+			name: "package of the learner's module",
+			files: map[string]string{
+				"go.mod":         "module shapes\n\ngo 1.21\n",
+				"main.go":        "package main\n\nimport _ \"shapes/geometry\"\n\nfunc main() {}\n",
+				"geometry/go.go": "package geometry\n",
+			},
+		},
+		{
+			name:  "requirement of an allowed module",
+			files: map[string]string{"go.mod": "module sandbox\n\ngo 1.21\n\nrequire github.com/fatih/color v1.18.0\n", "main.go": colorMain},
+		},
+		{
+			name:      "module that is not allowed",
+			files:     map[string]string{"go.mod": "module sandbox\n\ngo 1.21\n", "main.go": sysMain},
+			wantError: "Import not allowed",
+			wantLine:  3,
+		},
+		{
+			name:      "module path claiming a domain",
+			files:     map[string]string{"go.mod": "module golang.org\n\ngo 1.21\n", "main.go": sysMain},
+			wantError: "Module file not allowed",
+			wantLine:  1,
+		},
+		{
+			name:      "replace directive",
+			files:     map[string]string{"go.mod": "module sandbox\n\ngo 1.21\n\nreplace github.com/fatih/color => /etc\n", "main.go": colorMain},
+			wantError: "Module file not allowed",
+			wantLine:  5,
+		},
+		{
+			name:      "requirement of a module that is not allowed",
+			files:     map[string]string{"go.mod": "module sandbox\n\ngo 1.21\n\nrequire (\n\tgithub.com/fatih/color v1.18.0\n\tgolang.org/x/sys v0.8.0\n)\n", "main.go": colorMain},
+			wantError: "Module file not allowed",
+			wantLine:  7,
+		},
+		{
+			name:      "go.mod that does not parse",
+			files:     map[string]string{"go.mod": "module sandbox\n\nbogus directive\n", "main.go": colorMain},
+			wantError: "Module file not allowed",
+			wantLine:  3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejected := modules.checkImports(tt.files)
+			if tt.wantError == "" {
+				if rejected != nil {
+					t.Fatalf("rejected with %q: %s", rejected.Error, rejected.CompileOutput)
+				}
+				return
+			}
+			if rejected == nil {
+				t.Fatalf("accepted, want %q", tt.wantError)
+			}
+			if rejected.Error != tt.wantError {
+				t.Errorf("error = %q, want %q", rejected.Error, tt.wantError)
+			}
+			if len(rejected.Diagnostics) != 1 || rejected.Diagnostics[0].Line != tt.wantLine {
+				t.Errorf("diagnostics = %+v, want one on line %d", rejected.Diagnostics, tt.wantLine)
+			}
+		})
+	}
+}
@@ -0,0 +1,624 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Global OpenID Connect provider, nil while single sign-on is off
+var oidc *OIDCProvider
+
+// oidcStateCookie binds a login to the browser that started it
+const oidcStateCookie = "oidc_state"
+
+const (
+	// oidcFlowTTL is how long a user may take to log in at the provider
+	oidcFlowTTL = 10 * time.Minute
+
+	// maxOIDCFlows caps the logins waiting for the provider at once
+	maxOIDCFlows = 10000
+
+	// clockSkew is tolerated between our clock and the provider's
+	clockSkew = time.Minute
+
+	// jwksRefreshInterval is the least time between two fetches of the
+	// provider's keys, which are refetched when a token names an unknown key
+	jwksRefreshInterval = time.Minute
+)
+
+// OIDCProvider logs users in with an OpenID Connect provider, using the
+// authorization code flow with PKCE
+type OIDCProvider struct {
+	config      OIDCConfig
+	frontendURL string
+	client      *http.Client
+
+	mu          sync.Mutex
+	metadata    *oidcMetadata
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+	flows       map[string]oidcFlow
+}
+
+// oidcMetadata is the part of the provider's discovery document in use
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcFlow is a login waiting for the provider to send the user back
+type oidcFlow struct {
+	nonce    string
+	verifier string
+	expires  time.Time
+}
+
+// NewOIDCProvider creates the provider of cfg. It only contacts the
+// provider once discover is called or the first user logs in.
+func NewOIDCProvider(cfg OIDCConfig, frontendURL string) (*OIDCProvider, error) {
+	if cfg.ClientID == "" {
+		return nil, errors.New("OIDC_CLIENT_ID is required with OIDC_ISSUER")
+	}
+	if !slices.Contains(cfg.Scopes, "openid") {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	return &OIDCProvider{
+		config:      cfg,
+		frontendURL: frontendURL,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		flows:       make(map[string]oidcFlow),
+	}, nil
+}
+
+// discover fetches the provider's discovery document, once it succeeds
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	metadata := p.metadata
+	p.mu.Unlock()
+	if metadata != nil {
+		return metadata, nil
+	}
+
+	metadata = &oidcMetadata{}
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", metadata); err != nil {
+		return nil, fmt.Errorf("discovery failed: %v", err)
+	}
+	if metadata.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q instead of %q", metadata.Issuer, p.config.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("discovery document lacks the authorization, token or JWKS endpoint")
+	}
+
+	p.mu.Lock()
+	p.metadata = metadata
+	p.mu.Unlock()
+	return metadata, nil
+}
+
+// getJSON decodes the JSON document at url into v
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// AuthCodeURL starts a login. It returns the provider's URL to send the
+// browser to and the state the browser has to bring back.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context) (string, string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, nonce, verifier := randomToken(), randomToken(), randomToken()
+	now := time.Now()
+	p.mu.Lock()
+	for s, flow := range p.flows {
+		if now.After(flow.expires) {
+			delete(p.flows, s)
+		}
+	}
+	if len(p.flows) >= maxOIDCFlows {
+		p.mu.Unlock()
+		return "", "", errors.New("too many logins in progress")
+	}
+	p.flows[state] = oidcFlow{nonce: nonce, verifier: verifier, expires: now.Add(oidcFlowTTL)}
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), state, nil
+}
+
+// Exchange finishes the login of state: it trades the authorization code
+// for the user's ID token and returns the token's validated claims
+func (p *OIDCProvider) Exchange(ctx context.Context, state, code string) (map[string]any, error) {
+	p.mu.Lock()
+	flow, ok := p.flows[state]
+	delete(p.flows, state)
+	p.mu.Unlock()
+	if !ok || time.Now().After(flow.expires) {
+		return nil, errors.New("unknown or expired login")
+	}
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {flow.verifier},
+	}
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("token response is invalid: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request failed with %s: %s %s", resp.Status, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("token response has no ID token")
+	}
+
+	return p.verifyIDToken(ctx, metadata, token.IDToken, flow.nonce)
+}
+
+// verifyIDToken checks the signature of an ID token with the provider's
+// keys and that it was issued by the provider, to us, for this login and
+// is still valid
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, metadata *oidcMetadata, raw, nonce string) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.New("malformed ID token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed ID token signature")
+	}
+	key, err := p.key(ctx, metadata, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.New("malformed ID token claims")
+	}
+	now := time.Now()
+	if claims["iss"] != metadata.Issuer {
+		return nil, fmt.Errorf("ID token is from issuer %v", claims["iss"])
+	}
+	if !slices.Contains(claimStrings(claims["aud"]), p.config.ClientID) {
+		return nil, errors.New("ID token is for another client")
+	}
+	if azp, ok := claims["azp"]; ok && azp != p.config.ClientID {
+		return nil, errors.New("ID token is authorized for another client")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.Add(-clockSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("ID token expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(iat), 0)) {
+		return nil, errors.New("ID token is issued in the future")
+	}
+	if claims["nonce"] != nonce {
+		return nil, errors.New("ID token is for another login")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	return claims, nil
+}
+
+// key returns the provider's public key named kid. Providers rotate their
+// keys, so an unknown key refetches them.
+func (p *OIDCProvider) key(ctx context.Context, metadata *oidcMetadata, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := pickKey(p.keys, kid)
+	fetched := p.keysFetched
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if time.Since(fetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, metadata.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to get signing keys: %v", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		} else {
+			log.Printf("⚠️  Skipping signing key %q of %s: %v", jwk.Kid, p.config.Issuer, err)
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysFetched = time.Now()
+	p.mu.Unlock()
+
+	if key, ok := pickKey(keys, kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// pickKey finds the key named kid; tokens without a kid can only use the
+// provider's single key
+func pickKey(keys map[string]crypto.PublicKey, kid string) (crypto.PublicKey, bool) {
+	if key, ok := keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// jsonWebKey is a public RSA or EC key of a JSON Web Key Set
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey decodes the key
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// signatureHashes maps the supported JWS algorithms to their hash
+var signatureHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// verifySignature checks a JWS signature made with alg. Only asymmetric
+// algorithms are accepted, so that a token cannot pick "none" or an HMAC
+// keyed with the public key.
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	hash, ok := signatureHashes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil {
+			return nil
+		}
+		if strings.HasPrefix(alg, "PS") && rsa.VerifyPSS(key, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if strings.HasPrefix(alg, "ES") && len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(key, digest, r, s) {
+				return nil
+			}
+		}
+	}
+	return errors.New("invalid ID token signature")
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// claimStrings returns the strings of a claim that holds a list of strings,
+// or a single string of space-separated values
+func claimStrings(claim any) []string {
+	switch claim := claim.(type) {
+	case string:
+		return strings.Fields(claim)
+	case []any:
+		var values []string
+		for _, value := range claim {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// user finds or creates the account of the identity in claims, and gives it
+// the role its groups map to
+func (p *OIDCProvider) user(claims map[string]any) (*User, error) {
+	subject := claims["sub"].(string)
+	user, err := database.GetUserByIdentity(p.config.Issuer, subject)
+	if errors.Is(err, errUserNotFound) {
+		user, err = p.createUser(subject, claims)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if role, ok := p.role(claims); ok && role != user.Role {
+		if err := database.SetUserRole(user.ID, role); err != nil {
+			return nil, err
+		}
+		writeAudit(&AuditEntry{ActorID: "oidc", Action: "user.role", Target: user.ID, Details: map[string]any{"from": user.Role, "to": role}})
+		user.Role = role
+	}
+	return user, nil
+}
+
+// createUser creates the account of a new identity. Identities are never
+// linked to existing accounts by name, so a taken username gets a number.
+func (p *OIDCProvider) createUser(subject string, claims map[string]any) (*User, error) {
+	base := p.username(claims)
+	for attempt := 1; ; attempt++ {
+		username := base
+		if attempt > 1 {
+			suffix := fmt.Sprintf("-%d", attempt)
+			username = base[:min(len(base), 32-len(suffix))] + suffix
+		}
+		user, err := database.CreateIdentityUser(newUserID(), username, p.config.Issuer, subject)
+		if !errors.Is(err, errUsernameTaken) || attempt == 20 {
+			return user, err
+		}
+	}
+}
+
+// invalidUsernameChars matches what usernames may not contain
+var invalidUsernameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// username makes a valid username from the configured claim, the email
+// address or the subject, whichever is usable first
+func (p *OIDCProvider) username(claims map[string]any) string {
+	email, _ := claims["email"].(string)
+	email, _, _ = strings.Cut(email, "@")
+	candidates := []any{claims[p.config.UsernameClaim], email, claims["sub"]}
+	for _, candidate := range candidates {
+		name, _ := candidate.(string)
+		name = strings.Trim(invalidUsernameChars.ReplaceAllString(name, "-"), "-")
+		name = name[:min(len(name), 32)]
+		if usernameRegex.MatchString(name) {
+			return name
+		}
+	}
+	return "user"
+}
+
+// role maps the groups of an identity to a role. Without configured groups
+// it reports false, leaving roles to the admins.
+func (p *OIDCProvider) role(claims map[string]any) (Role, bool) {
+	if len(p.config.AdminGroups) == 0 && len(p.config.InstructorGroups) == 0 {
+		return "", false
+	}
+	groups := claimStrings(claims[p.config.GroupsClaim])
+	inAny := func(names []string) bool {
+		return slices.ContainsFunc(groups, func(group string) bool { return slices.Contains(names, group) })
+	}
+
+	switch {
+	case inAny(p.config.AdminGroups):
+		return RoleAdmin, true
+	case inAny(p.config.InstructorGroups):
+		return RoleInstructor, true
+	}
+	return RoleLearner, true
+}
+
+// getAuthProviders tells the frontend which ways of logging in there are
+func getAuthProviders(c *gin.Context) {
+	providers := gin.H{"password": true}
+	if oidc != nil {
+		providers["oidc"] = gin.H{"name": oidc.config.ProviderName}
+	}
+	c.JSON(http.StatusOK, providers)
+}
+
+// oidcLogin sends the browser to the provider to log in
+func oidcLogin(c *gin.Context) {
+	authURL, state, err := oidc.AuthCodeURL(c.Request.Context())
+	if err != nil {
+		log.Printf("⚠️  Cannot start single sign-on: %v", err)
+		oidc.redirectToFrontend(c, url.Values{"error": {"Single sign-on is unavailable"}})
+		return
+	}
+
+	oidc.setStateCookie(c, state, int(oidcFlowTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// oidcCallback finishes a login when the provider sends the browser back,
+// and hands the session token to the frontend in the URL fragment, which
+// browsers do not send to servers
+func oidcCallback(c *gin.Context) {
+	state, _ := c.Cookie(oidcStateCookie)
+	oidc.setStateCookie(c, "", -1)
+
+	if reason := c.Query("error"); reason != "" {
+		log.Printf("Single sign-on refused: %s %s", reason, c.Query("error_description"))
+		oidc.redirectToFrontend(c, url.Values{"error": {"Login was cancelled or refused"}})
+		return
+	}
+	if state == "" || c.Query("state") != state {
+		oidc.redirectToFrontend(c, url.Values{"error": {"Login could not be verified, please try again"}})
+		return
+	}
+
+	claims, err := oidc.Exchange(c.Request.Context(), state, c.Query("code"))
+	if err != nil {
+		log.Printf("⚠️  Single sign-on failed: %v", err)
+		oidc.redirectToFrontend(c, url.Values{"error": {"Login failed"}})
+		return
+	}
+	user, err := oidc.user(claims)
+	if err != nil {
+		log.Printf("Error getting user of %v: %v", claims["sub"], err)
+		oidc.redirectToFrontend(c, url.Values{"error": {"Login failed"}})
+		return
+	}
+	log.Printf("User %s logged in with %s", user.Username, oidc.config.ProviderName)
+
+	token, expires := tokens.session(user)
+	oidc.redirectToFrontend(c, url.Values{"token": {token}, "expires_at": {expires.UTC().Format(time.RFC3339)}})
+}
+
+// setStateCookie stores the state of a login in the browser, where only
+// the callback can read it
+func (p *OIDCProvider) setStateCookie(c *gin.Context, state string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, maxAge, "/api/auth/oidc", "", strings.HasPrefix(p.config.RedirectURL, "https://"), true)
+}
+
+// redirectToFrontend sends the browser back to the frontend with values in
+// the URL fragment
+func (p *OIDCProvider) redirectToFrontend(c *gin.Context, values url.Values) {
+	c.Redirect(http.StatusFound, p.frontendURL+"#"+values.Encode())
+}
+
+// randomToken returns 32 random bytes, base64url encoded
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
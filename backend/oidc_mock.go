@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mockOIDCCommand is the first argument that makes the server binary serve
+// a mock OpenID Connect provider for trying single sign-on locally
+const mockOIDCCommand = "mock-oidc"
+
+// mockOIDC is a minimal OpenID Connect provider. It lets anyone log in as
+// any user with any groups, so it must never face real users.
+type mockOIDC struct {
+	issuer string
+	key    *rsa.PrivateKey
+	kid    string
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+}
+
+// mockGrant is an authorization code waiting to be exchanged
+type mockGrant struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	username    string
+	groups      []string
+	expires     time.Time
+}
+
+// runMockOIDC serves the mock provider on addr, such as "localhost:9096"
+func runMockOIDC(addr string) error {
+	m, err := newMockOIDC("http://" + addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("🔑 Mock OpenID Connect provider at %s, accepting any user and client", m.issuer)
+	return http.ListenAndServe(addr, m.handler())
+}
+
+// newMockOIDC creates a mock provider for issuer with a fresh signing key
+func newMockOIDC(issuer string) (*mockOIDC, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &mockOIDC{issuer: issuer, key: key, kid: randomToken()[:8], grants: make(map[string]mockGrant)}, nil
+}
+
+// handler serves the provider's endpoints
+func (m *mockOIDC) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/jwks", m.jwks)
+	mux.HandleFunc("/authorize", m.authorize)
+	mux.HandleFunc("/token", m.token)
+	return mux
+}
+
+// discovery serves the discovery document
+func (m *mockOIDC) discovery(w http.ResponseWriter, r *http.Request) {
+	writeMockJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                m.issuer,
+		"authorization_endpoint":                m.issuer + "/authorize",
+		"token_endpoint":                        m.issuer + "/token",
+		"jwks_uri":                              m.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "profile", "email"},
+	})
+}
+
+// jwks serves the public signing key
+func (m *mockOIDC) jwks(w http.ResponseWriter, r *http.Request) {
+	writeMockJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": m.kid,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+	}}})
+}
+
+// mockLoginPage asks who to log in as
+var mockLoginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><head><title>Mock OpenID Connect login</title></head>
+<body style="font-family: sans-serif; max-width: 24em; margin: 4em auto">
+<h2>Mock login</h2>
+<p>Log in to <b>{{.Get "client_id"}}</b> as anyone.</p>
+<form method="post">
+{{range $name, $values := .}}<input type="hidden" name="{{$name}}" value="{{index $values 0}}">
+{{end}}<p><label>Username<br><input name="username" value="learner" autofocus></label></p>
+<p><label>Groups, comma-separated<br><input name="groups"></label></p>
+<p><button type="submit">Log in</button></p>
+</form>
+</body></html>
+`))
+
+// authorize shows the login page, and once it is submitted sends the
+// browser back to the client with an authorization code
+func (m *mockOIDC) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params := r.Form
+	redirectURI, err := url.Parse(params.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() || params.Get("client_id") == "" {
+		http.Error(w, "client_id and an absolute redirect_uri are required", http.StatusBadRequest)
+		return
+	}
+	if params.Get("response_type") != "code" || params.Get("code_challenge_method") != "S256" || params.Get("code_challenge") == "" {
+		http.Error(w, "only the code flow with an S256 code_challenge is supported", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		mockLoginPage.Execute(w, params)
+		return
+	}
+
+	var groups []string
+	for _, group := range strings.Split(params.Get("groups"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	code := randomToken()
+	m.mu.Lock()
+	m.grants[code] = mockGrant{
+		clientID:    params.Get("client_id"),
+		redirectURI: params.Get("redirect_uri"),
+		challenge:   params.Get("code_challenge"),
+		nonce:       params.Get("nonce"),
+		username:    params.Get("username"),
+		groups:      groups,
+		expires:     time.Now().Add(time.Minute),
+	}
+	m.mu.Unlock()
+
+	query := redirectURI.Query()
+	query.Set("code", code)
+	query.Set("state", params.Get("state"))
+	redirectURI.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// token exchanges an authorization code for an ID token after checking
+// that the client, the redirect URI and the PKCE verifier match
+func (m *mockOIDC) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeMockJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	clientID := r.PostForm.Get("client_id")
+	if id, _, ok := r.BasicAuth(); ok {
+		clientID, _ = url.QueryUnescape(id)
+	}
+
+	code := r.PostForm.Get("code")
+	m.mu.Lock()
+	grant, ok := m.grants[code]
+	delete(m.grants, code)
+	m.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok || time.Now().After(grant.expires):
+		writeMockJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "unknown or expired code"})
+		return
+	case grant.clientID != clientID || grant.redirectURI != r.PostForm.Get("redirect_uri"):
+		writeMockJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "client or redirect_uri mismatch"})
+		return
+	case base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge:
+		writeMockJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "code_verifier mismatch"})
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"iss":                m.issuer,
+		"sub":                "mock|" + grant.username,
+		"aud":                clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              grant.nonce,
+		"preferred_username": grant.username,
+		"email":              grant.username + "@example.com",
+		"groups":             grant.groups,
+	}
+	writeMockJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomToken(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     m.sign(claims),
+	})
+}
+
+// sign makes an RS256 JWT of claims
+func (m *mockOIDC) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": m.kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		log.Printf("⚠️  Mock OpenID Connect provider cannot sign: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// writeMockJSON answers with v as JSON
+func writeMockJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	oidcTestClientID    = "go-tutorial"
+	oidcTestRedirectURL = "http://localhost:8080/api/auth/oidc/callback"
+	oidcTestFrontendURL = "http://localhost:5173/"
+)
+
+// useMockOIDC serves a mock provider and turns on single sign-on with it
+func useMockOIDC(t *testing.T) (*mockOIDC, *OIDCProvider) {
+	t.Helper()
+	server := httptest.NewUnstartedServer(nil)
+	mock, err := newMockOIDC("http://" + server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Config.Handler = mock.handler()
+	server.Start()
+	t.Cleanup(server.Close)
+
+	provider, err := NewOIDCProvider(OIDCConfig{
+		Issuer:           mock.issuer,
+		ClientID:         oidcTestClientID,
+		RedirectURL:      oidcTestRedirectURL,
+		Scopes:           []string{"openid", "profile"},
+		ProviderName:     "Mock",
+		UsernameClaim:    "preferred_username",
+		GroupsClaim:      "groups",
+		InstructorGroups: []string{"teachers"},
+	}, oidcTestFrontendURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := oidc
+	oidc = provider
+	t.Cleanup(func() { oidc = previous })
+	return mock, provider
+}
+
+// startOIDCLogin starts a login at the API and logs in at the mock
+// provider. It returns the state cookie and the callback URL the provider
+// sends the browser back to.
+func startOIDCLogin(t *testing.T, router http.Handler, username, groups string) (*http.Cookie, *url.URL) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("login status %d, want a redirect to the provider", recorder.Code)
+	}
+	var state *http.Cookie
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			state = cookie
+		}
+	}
+	if state == nil || state.Value == "" {
+		t.Fatal("login set no state cookie")
+	}
+
+	authURL, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := authURL.Query()
+	if form.Get("state") != state.Value || form.Get("nonce") == "" || form.Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorization request %v lacks the state, nonce or PKCE challenge", form)
+	}
+	form.Set("username", username)
+	form.Set("groups", groups)
+	authURL.RawQuery = ""
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.PostForm(authURL.String(), form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound || err != nil {
+		t.Fatalf("provider answered %s, want a redirect to the callback", resp.Status)
+	}
+	return state, callback
+}
+
+// finishOIDCLogin sends the browser back to the callback and returns the
+// values the frontend gets in the URL fragment
+func finishOIDCLogin(t *testing.T, router http.Handler, callback *url.URL, state *http.Cookie) url.Values {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	if state != nil {
+		req.AddCookie(state)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	if recorder.Code != http.StatusFound || err != nil || !strings.HasPrefix(location.String(), oidcTestFrontendURL) {
+		t.Fatalf("callback status %d to %q, want a redirect to the frontend", recorder.Code, location)
+	}
+	values, err := url.ParseQuery(location.Fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestOIDCLogin(t *testing.T) {
+	useMockOIDC(t)
+	router, err := newRouter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, callback := startOIDCLogin(t, router, "oidc-teacher", "teachers")
+	values := finishOIDCLogin(t, router, callback, state)
+	if values.Get("error") != "" || values.Get("token") == "" {
+		t.Fatalf("callback gave the frontend %v, want a session token", values)
+	}
+
+	var user User
+	if recorder := serve(t, http.MethodGet, "/api/auth/me", nil, values.Get("token"), &user); recorder.Code != http.StatusOK {
+		t.Fatalf("me status %d: %s", recorder.Code, recorder.Body)
+	}
+	if user.Username != "oidc-teacher" || user.Role != RoleInstructor {
+		t.Errorf("user = %+v, want oidc-teacher as an instructor", user)
+	}
+
+	// The same identity logs in to the same account, and leaving the group
+	// takes the role away
+	state, callback = startOIDCLogin(t, router, "oidc-teacher", "")
+	values = finishOIDCLogin(t, router, callback, state)
+	var again User
+	serve(t, http.MethodGet, "/api/auth/me", nil, values.Get("token"), &again)
+	if again.ID != user.ID || again.Role != RoleLearner {
+		t.Errorf("second login = %+v, want account %s as a learner", again, user.ID)
+	}
+
+	// A callback cannot be used twice
+	values = finishOIDCLogin(t, router, callback, state)
+	if values.Get("token") != "" || values.Get("error") == "" {
+		t.Errorf("replayed callback gave the frontend %v, want an error", values)
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	_, provider := useMockOIDC(t)
+	router, err := newRouter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(state *http.Cookie, callback *url.URL) *http.Cookie
+	}{
+		{
+			name: "state that does not match the cookie",
+			tamper: func(state *http.Cookie, callback *url.URL) *http.Cookie {
+				query := callback.Query()
+				query.Set("state", randomToken())
+				callback.RawQuery = query.Encode()
+				return state
+			},
+		},
+		{
+			name: "missing state cookie",
+			tamper: func(state *http.Cookie, callback *url.URL) *http.Cookie {
+				return nil
+			},
+		},
+		{
+			name: "state of an unknown login",
+			tamper: func(state *http.Cookie, callback *url.URL) *http.Cookie {
+				forged := randomToken()
+				query := callback.Query()
+				query.Set("state", forged)
+				callback.RawQuery = query.Encode()
+				return &http.Cookie{Name: oidcStateCookie, Value: forged}
+			},
+		},
+		{
+			name: "PKCE verifier that does not match the challenge",
+			tamper: func(state *http.Cookie, callback *url.URL) *http.Cookie {
+				provider.mu.Lock()
+				flow := provider.flows[state.Value]
+				flow.verifier = randomToken()
+				provider.flows[state.Value] = flow
+				provider.mu.Unlock()
+				return state
+			},
+		},
+		{
+			name: "unknown authorization code",
+			tamper: func(state *http.Cookie, callback *url.URL) *http.Cookie {
+				query := callback.Query()
+				query.Set("code", randomToken())
+				callback.RawQuery = query.Encode()
+				return state
+			},
+		},
+		{
+			name: "refused by the provider",
+			tamper: func(state *http.Cookie, callback *url.URL) *http.Cookie {
+				callback.RawQuery = url.Values{"error": {"access_denied"}, "state": {state.Value}}.Encode()
+				return state
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, callback := startOIDCLogin(t, router, "oidc-rejected", "")
+			values := finishOIDCLogin(t, router, callback, tt.tamper(state, callback))
+			if values.Get("token") != "" || values.Get("error") == "" {
+				t.Errorf("callback gave the frontend %v, want an error", values)
+			}
+		})
+	}
+}
+
+// encodeJWT encodes header and claims into a JWT signed by sign
+func encodeJWT(t *testing.T, header, claims map[string]any, sign func(signed string) []byte) string {
+	t.Helper()
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(signed))
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	mock, provider := useMockOIDC(t)
+	ctx := context.Background()
+	metadata, err := provider.discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const nonce = "login nonce"
+	now := time.Now()
+	claims := func(change func(claims map[string]any)) map[string]any {
+		claims := map[string]any{
+			"iss":   mock.issuer,
+			"sub":   "mock|learner",
+			"aud":   oidcTestClientID,
+			"iat":   now.Unix(),
+			"exp":   now.Add(5 * time.Minute).Unix(),
+			"nonce": nonce,
+		}
+		if change != nil {
+			change(claims)
+		}
+		return claims
+	}
+	valid := mock.sign(claims(nil))
+	payload := strings.Split(valid, ".")[1]
+
+	// An HMAC keyed with the provider's public key is what a forger would
+	// sign with when a verifier trusts the token's alg
+	publicKey := mock.key.N.Bytes()
+	hs256 := encodeJWT(t, map[string]any{"alg": "HS256", "kid": mock.kid}, claims(nil), func(signed string) []byte {
+		mac := hmac.New(sha256.New, publicKey)
+		mac.Write([]byte(signed))
+		return mac.Sum(nil)
+	})
+	none := encodeJWT(t, map[string]any{"alg": "none", "kid": mock.kid}, claims(nil), func(string) []byte { return nil })
+
+	tests := []struct {
+		name    string
+		token   string
+		nonce   string
+		wantErr string
+	}{
+		{"valid", valid, nonce, ""},
+		{"audience list with the client", mock.sign(claims(func(c map[string]any) { c["aud"] = []string{"other", oidcTestClientID} })), nonce, ""},
+		{"wrong audience", mock.sign(claims(func(c map[string]any) { c["aud"] = "other-client" })), nonce, "another client"},
+		{"authorized for another client", mock.sign(claims(func(c map[string]any) { c["azp"] = "other-client" })), nonce, "another client"},
+		{"wrong issuer", mock.sign(claims(func(c map[string]any) { c["iss"] = "http://evil.test" })), nonce, "issuer"},
+		{"wrong nonce", mock.sign(claims(func(c map[string]any) { c["nonce"] = "other nonce" })), nonce, "another login"},
+		{"no nonce", mock.sign(claims(func(c map[string]any) { delete(c, "nonce") })), nonce, "another login"},
+		{"replayed in a later login", valid, "later login nonce", "another login"},
+		{"expired", mock.sign(claims(func(c map[string]any) { c["exp"] = now.Add(-2 * clockSkew).Unix() })), nonce, "expired"},
+		{"no expiry", mock.sign(claims(func(c map[string]any) { delete(c, "exp") })), nonce, "expired"},
+		{"issued in the future", mock.sign(claims(func(c map[string]any) { c["iat"] = now.Add(2 * clockSkew).Unix() })), nonce, "future"},
+		{"no subject", mock.sign(claims(func(c map[string]any) { delete(c, "sub") })), nonce, "subject"},
+		{"alg none", none, nonce, "unsupported signing algorithm"},
+		{"HS256 keyed with the public key", hs256, nonce, "unsupported signing algorithm"},
+		{"tampered claims", strings.Replace(valid, payload, base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"mock|admin"}`)), 1), nonce, "signature"},
+		{"malformed", "not.a-token", nonce, "malformed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provider.verifyIDToken(ctx, metadata, tt.token, tt.nonce)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("verifyIDToken() error = %v, want none", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("verifyIDToken() error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthProviders(t *testing.T) {
+	var providers gin.H
+	serve(t, http.MethodGet, "/api/auth/providers", nil, "", &providers)
+	if _, ok := providers["oidc"]; ok {
+		t.Errorf("providers = %v, want no single sign-on while it is off", providers)
+	}
+
+	useMockOIDC(t)
+	serve(t, http.MethodGet, "/api/auth/providers", nil, "", &providers)
+	if _, ok := providers["oidc"]; !ok {
+		t.Errorf("providers = %v, want single sign-on", providers)
+	}
+}
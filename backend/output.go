@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// OutputSink receives program output while the program is running. It is
+// called from the goroutines copying the program's output, one call at a
+// time.
+type OutputSink func(chunk OutputChunk)
+
+// OutputChunk is a piece of a program's output in the order it was written
+type OutputChunk struct {
+	// Stream is "stdout" or "stderr"
+	Stream string `json:"stream"`
+	Data   string `json:"data"`
+
+	// TimeMs is when the chunk was written, in milliseconds since the
+	// program started
+	TimeMs int64 `json:"time_ms"`
+}
+
+// ExecutionStreams connects a running program to a live client. A nil
+// *ExecutionStreams, or nil fields, mean the output is only collected and
+// the program's stdin ends after the request's Stdin.
+type ExecutionStreams struct {
+	Output OutputSink
+
+	// Input is read into the program's stdin after the request's Stdin.
+	// The program sees end of file once Input returns io.EOF.
+	Input io.Reader
+}
+
+// output returns the sink, which may be nil
+func (s *ExecutionStreams) output() OutputSink {
+	if s == nil {
+		return nil
+	}
+	return s.Output
+}
+
+// input returns the live input, which may be nil
+func (s *ExecutionStreams) input() io.Reader {
+	if s == nil {
+		return nil
+	}
+	return s.Input
+}
+
+// attachStdin connects cmd's stdin to the request's Stdin followed by the
+// live input. The live input is copied by a goroutine that Wait does not
+// wait for, so a client that never closes its input cannot hold up the end
+// of a run.
+func attachStdin(cmd *exec.Cmd, req *CodeExecutionRequest, streams *ExecutionStreams) error {
+	batch := strings.NewReader(req.Stdin)
+	live := streams.input()
+	if live == nil {
+		cmd.Stdin = batch
+		return nil
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdin pipe: %v", err)
+	}
+	go func() {
+		defer stdin.Close()
+		io.Copy(stdin, io.MultiReader(batch, live))
+	}()
+	return nil
+}
+
+// outputCollector gathers a program's stdout and stderr as a timeline of
+// chunks and forwards every chunk to an optional sink. Past its limit it
+// keeps only the first and the last half of the limit.
+type outputCollector struct {
+	mu    sync.Mutex
+	sink  OutputSink
+	start time.Time
+
+	// sinkMu keeps the calls to the sink one at a time and in order, without
+	// holding mu while a slow client takes its time
+	sinkMu sync.Mutex
+
+	limit      int
+	exceeded   bool
+	onExceeded func()
+
+	// head holds the output up to the limit, or its first half once the
+	// limit is exceeded; tail then holds the latest output up to the other
+	// half. totals counts every byte written per stream.
+	head      []OutputChunk
+	headBytes int
+	tail      []OutputChunk
+	tailBytes int
+	totals    map[string]int64
+
+	// tailAtLine reports whether the tail starts at the beginning of a line
+	tailAtLine bool
+}
+
+// newOutputCollector creates a collector forwarding to sink, which may be
+// nil. Once more than limit bytes are written, onExceeded is called, which
+// is expected to stop the program. A limit of zero collects everything.
+func newOutputCollector(sink OutputSink, limit int, onExceeded func()) *outputCollector {
+	return &outputCollector{
+		sink:       sink,
+		start:      time.Now(),
+		limit:      limit,
+		onExceeded: onExceeded,
+		totals:     make(map[string]int64),
+	}
+}
+
+// Stream returns a writer for one of the program's output streams
+func (c *outputCollector) Stream(name string) io.Writer {
+	return &streamWriter{collector: c, name: name}
+}
+
+// String returns both streams interleaved as they were written. Truncated
+// output is the head and the tail joined by a line saying how much was left
+// out.
+func (c *outputCollector) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.render("")
+}
+
+// setOutput fills in the output of a run: both streams interleaved, each
+// stream on its own and the timeline
+func (c *outputCollector) setOutput(r *CodeExecutionResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r.Output = c.render("")
+	r.Stdout = c.render("stdout")
+	r.Stderr = c.render("stderr")
+
+	head, tail := c.kept()
+	r.Timeline = append(head, tail...)
+}
+
+// Exceeded reports whether the program wrote more than the limit, and with
+// it whether the output is truncated
+func (c *outputCollector) Exceeded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.exceeded
+}
+
+// write records a chunk written now
+func (c *outputCollector) write(name string, p []byte) {
+	c.writeAt(name, p, time.Since(c.start))
+}
+
+// writeAt records a chunk written at the given time since the program
+// started and forwards it to the sink
+func (c *outputCollector) writeAt(name string, p []byte, at time.Duration) {
+	c.sinkMu.Lock()
+	defer c.sinkMu.Unlock()
+
+	c.mu.Lock()
+	live, exceeded := c.record(name, p, at)
+	c.mu.Unlock()
+
+	if c.sink != nil && live.Data != "" {
+		c.sink(live)
+	}
+	if exceeded && c.onExceeded != nil {
+		c.onExceeded()
+	}
+}
+
+// record keeps a chunk and returns the part of it the client should see
+// live, and whether it took the output past the limit. The caller holds mu.
+func (c *outputCollector) record(name string, p []byte, at time.Duration) (OutputChunk, bool) {
+	c.totals[name] += int64(len(p))
+	chunk := OutputChunk{Stream: name, Data: string(p), TimeMs: at.Milliseconds()}
+	if c.exceeded {
+		c.keepTail(chunk)
+		return OutputChunk{}, false
+	}
+
+	if c.limit > 0 && c.headBytes+len(p) > c.limit {
+		// The client sees up to the limit live
+		c.exceeded = true
+		live := OutputChunk{}
+		if room := c.limit - c.headBytes; room > 0 {
+			live = OutputChunk{Stream: name, Data: chunk.Data[:room], TimeMs: chunk.TimeMs}
+		}
+
+		// Split what was kept so far into the head and the start of the tail
+		chunks := append(c.head, chunk)
+		c.head, c.headBytes = nil, 0
+		for _, chunk := range chunks {
+			if room := c.limit/2 - c.headBytes; room > 0 {
+				if len(chunk.Data) <= room {
+					c.head = appendChunk(c.head, chunk)
+					c.headBytes += len(chunk.Data)
+					continue
+				}
+				first := chunk
+				first.Data = chunk.Data[:room]
+				c.head = appendChunk(c.head, first)
+				c.headBytes += room
+				chunk.Data = chunk.Data[room:]
+			}
+			if len(c.tail) == 0 {
+				c.tailAtLine = strings.HasSuffix(joinChunks(c.head, ""), "\n")
+			}
+			c.keepTail(chunk)
+		}
+		return live, true
+	}
+
+	c.head = appendChunk(c.head, chunk)
+	c.headBytes += len(p)
+	return chunk, false
+}
+
+// keepTail appends a chunk to the tail, dropping all but the last half of
+// the limit. The caller holds mu.
+func (c *outputCollector) keepTail(chunk OutputChunk) {
+	c.tail = appendChunk(c.tail, chunk)
+	c.tailBytes += len(chunk.Data)
+
+	size := c.limit - c.limit/2
+	for c.tailBytes > size {
+		excess := c.tailBytes - size
+		if first := c.tail[0]; len(first.Data) <= excess {
+			c.tail = c.tail[1:]
+			c.tailBytes -= len(first.Data)
+			c.tailAtLine = strings.HasSuffix(first.Data, "\n")
+		} else {
+			c.tail[0].Data = first.Data[excess:]
+			c.tailBytes -= excess
+			c.tailAtLine = first.Data[excess-1] == '\n'
+		}
+	}
+}
+
+// kept returns copies of the head and the tail. The cut between them is
+// moved to line breaks where there are any, and never splits a character.
+// The caller holds mu.
+func (c *outputCollector) kept() ([]OutputChunk, []OutputChunk) {
+	head := append([]OutputChunk(nil), c.head...)
+	if !c.exceeded {
+		return head, nil
+	}
+	tail := append([]OutputChunk(nil), c.tail...)
+
+	headText := joinChunks(head, "")
+	end := strings.LastIndexByte(headText, '\n') + 1
+	if end == 0 {
+		end = len(trimRuneEnd(headText))
+	}
+	tailText := joinChunks(tail, "")
+	begin := 0
+	if !c.tailAtLine {
+		begin = strings.IndexByte(tailText, '\n') + 1
+		if begin == 0 {
+			begin = len(tailText) - len(trimRuneStart(tailText))
+		}
+	}
+	return sliceChunks(head, 0, end), sliceChunks(tail, begin, len(tailText))
+}
+
+// render joins the kept output of one stream, or of both when stream is
+// empty, marking where output was left out. The caller holds mu.
+func (c *outputCollector) render(stream string) string {
+	head, tail := c.kept()
+	text := joinChunks(head, stream)
+	if !c.exceeded {
+		return text
+	}
+
+	var total int64
+	for name, n := range c.totals {
+		if stream == "" || name == stream {
+			total += n
+		}
+	}
+	rest := joinChunks(tail, stream)
+	omitted := total - int64(len(text)) - int64(len(rest))
+	if omitted <= 0 {
+		return text + rest
+	}
+
+	var out strings.Builder
+	out.WriteString(text)
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		out.WriteByte('\n')
+	}
+	fmt.Fprintf(&out, "... output truncated, %d bytes omitted ...\n", omitted)
+	out.WriteString(rest)
+	return out.String()
+}
+
+// appendChunk adds a chunk to a timeline, merging it into the previous one
+// when both were written to the same stream in the same millisecond
+func appendChunk(chunks []OutputChunk, chunk OutputChunk) []OutputChunk {
+	if chunk.Data == "" {
+		return chunks
+	}
+	if n := len(chunks); n > 0 && chunks[n-1].Stream == chunk.Stream && chunks[n-1].TimeMs == chunk.TimeMs {
+		chunks[n-1].Data += chunk.Data
+		return chunks
+	}
+	return append(chunks, chunk)
+}
+
+// joinChunks concatenates the chunks of one stream, or all of them when
+// stream is empty
+func joinChunks(chunks []OutputChunk, stream string) string {
+	var out strings.Builder
+	for _, chunk := range chunks {
+		if stream == "" || chunk.Stream == stream {
+			out.WriteString(chunk.Data)
+		}
+	}
+	return out.String()
+}
+
+// sliceChunks keeps the bytes from begin to end of the chunks' concatenation
+func sliceChunks(chunks []OutputChunk, begin, end int) []OutputChunk {
+	var sliced []OutputChunk
+	offset := 0
+	for _, chunk := range chunks {
+		from, to := max(begin-offset, 0), min(end-offset, len(chunk.Data))
+		offset += len(chunk.Data)
+		if from >= to {
+			continue
+		}
+		chunk.Data = chunk.Data[from:to]
+		sliced = append(sliced, chunk)
+	}
+	return sliced
+}
+
+// trimRuneEnd drops a UTF-8 sequence cut off at the end of s
+func trimRuneEnd(s string) string {
+	for i := len(s) - 1; i >= 0 && i >= len(s)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(s[i]) {
+			if !utf8.FullRuneInString(s[i:]) {
+				return s[:i]
+			}
+			break
+		}
+	}
+	return s
+}
+
+// trimRuneStart drops the continuation bytes of a UTF-8 sequence cut off at
+// the start of s
+func trimRuneStart(s string) string {
+	for i := 0; i < len(s) && i < utf8.UTFMax; i++ {
+		if utf8.RuneStart(s[i]) {
+			return s[i:]
+		}
+	}
+	return s
+}
+
+// streamWriter is the io.Writer handed to exec.Cmd for one stream
+type streamWriter struct {
+	collector *outputCollector
+	name      string
+}
+
+// Write implements io.Writer
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.collector.write(w.name, p)
+	return len(p), nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputCollector(t *testing.T) {
+	var lines []OutputChunk
+	for i := 0; i < 10; i++ {
+		lines = append(lines, OutputChunk{Stream: "stdout", Data: "line" + string(rune('0'+i)) + "\n", TimeMs: int64(i)})
+	}
+
+	tests := []struct {
+		name         string
+		limit        int
+		writes       []OutputChunk
+		wantOutput   string
+		wantStdout   string
+		wantStderr   string
+		wantSink     string
+		wantExceeded bool
+	}{
+		{
+			name:  "no limit keeps everything",
+			limit: 0,
+			writes: []OutputChunk{
+				{Stream: "stdout", Data: "out\n"},
+				{Stream: "stderr", Data: "err\n", TimeMs: 1},
+			},
+			wantOutput: "out\nerr\n",
+			wantStdout: "out\n",
+			wantStderr: "err\n",
+			wantSink:   "out\nerr\n",
+		},
+		{
+			name:       "output up to the limit is kept",
+			limit:      6,
+			writes:     []OutputChunk{{Stream: "stdout", Data: "hello\n"}},
+			wantOutput: "hello\n",
+			wantStdout: "hello\n",
+			wantSink:   "hello\n",
+		},
+		{
+			name:         "cut at line breaks",
+			limit:        20,
+			writes:       lines,
+			wantOutput:   "line0\n... output truncated, 48 bytes omitted ...\nline9\n",
+			wantStdout:   "line0\n... output truncated, 48 bytes omitted ...\nline9\n",
+			wantSink:     "line0\nline1\nline2\nli",
+			wantExceeded: true,
+		},
+		{
+			name:         "cut without line breaks keeps characters whole",
+			limit:        7,
+			writes:       []OutputChunk{{Stream: "stdout", Data: "ééééé"}},
+			wantOutput:   "é\n... output truncated, 4 bytes omitted ...\néé",
+			wantStdout:   "é\n... output truncated, 4 bytes omitted ...\néé",
+			wantSink:     "ééé\xc3",
+			wantExceeded: true,
+		},
+		{
+			name:  "streams are counted separately and a whole last line is kept",
+			limit: 8,
+			writes: []OutputChunk{
+				{Stream: "stdout", Data: "abc\n"},
+				{Stream: "stderr", Data: "panic\n", TimeMs: 1},
+				{Stream: "stdout", Data: "xyz\n", TimeMs: 2},
+			},
+			wantOutput:   "abc\n... output truncated, 6 bytes omitted ...\nxyz\n",
+			wantStdout:   "abc\nxyz\n",
+			wantStderr:   "... output truncated, 6 bytes omitted ...\n",
+			wantSink:     "abc\npani",
+			wantExceeded: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sink strings.Builder
+			stopped := 0
+			collector := newOutputCollector(func(chunk OutputChunk) { sink.WriteString(chunk.Data) }, tt.limit, func() { stopped++ })
+			for _, chunk := range tt.writes {
+				collector.writeAt(chunk.Stream, []byte(chunk.Data), time.Duration(chunk.TimeMs)*time.Millisecond)
+			}
+
+			var response CodeExecutionResponse
+			collector.setOutput(&response)
+			if response.Output != tt.wantOutput {
+				t.Errorf("output = %q, want %q", response.Output, tt.wantOutput)
+			}
+			if response.Stdout != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", response.Stdout, tt.wantStdout)
+			}
+			if response.Stderr != tt.wantStderr {
+				t.Errorf("stderr = %q, want %q", response.Stderr, tt.wantStderr)
+			}
+			if sink.String() != tt.wantSink {
+				t.Errorf("sink got %q, want %q", sink.String(), tt.wantSink)
+			}
+			if collector.Exceeded() != tt.wantExceeded {
+				t.Errorf("exceeded = %v, want %v", collector.Exceeded(), tt.wantExceeded)
+			}
+			if want := map[bool]int{true: 1}[tt.wantExceeded]; stopped != want {
+				t.Errorf("onExceeded called %d times, want %d", stopped, want)
+			}
+		})
+	}
+}
+
+func TestOutputCollectorSlowSink(t *testing.T) {
+	// A sink stuck on a slow client must not hold up reading what was
+	// collected, which is how a run reports its result
+	release := make(chan struct{})
+	collector := newOutputCollector(func(chunk OutputChunk) { <-release }, 0, nil)
+
+	written := make(chan struct{})
+	go func() {
+		collector.Stream("stdout").Write([]byte("hello\n"))
+		close(written)
+	}()
+
+	done := make(chan string)
+	go func() { done <- collector.String() }()
+	select {
+	case output := <-done:
+		if output != "hello\n" && output != "" {
+			t.Errorf("output = %q, want what was written so far", output)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("String() blocked while the sink was busy")
+	}
+	close(release)
+	<-written
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PanicInfo describes a panic or fatal runtime error that ended a program
+type PanicInfo struct {
+	// Message is the panic value or error without Go's "panic: " and
+	// "runtime error: " prefixes
+	Message string `json:"message"`
+
+	// Kind names the runtime error, such as "index_out_of_range" or
+	// "nil_map_write", and is "panic" when the program called panic itself
+	Kind string `json:"kind"`
+
+	// Fatal is set for errors that cannot be recovered, such as a deadlock
+	Fatal bool `json:"fatal,omitempty"`
+
+	// Goroutine is the number of the goroutine that failed
+	Goroutine int `json:"goroutine,omitempty"`
+
+	// Stack holds the frames in the learner's own files, innermost first
+	Stack []StackFrame `json:"stack"`
+}
+
+// StackFrame is one call in the learner's code
+type StackFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+
+	// CreatedBy marks the go statement that started the goroutine
+	CreatedBy bool `json:"created_by,omitempty"`
+}
+
+// runtimeErrorKinds maps the start of a runtime error message to its kind
+var runtimeErrorKinds = []struct {
+	prefix string
+	kind   string
+}{
+	{"index out of range", "index_out_of_range"},
+	{"slice bounds out of range", "slice_bounds_out_of_range"},
+	{"invalid memory address or nil pointer dereference", "nil_pointer_dereference"},
+	{"assignment to entry in nil map", "nil_map_write"},
+	{"integer divide by zero", "integer_divide_by_zero"},
+	{"interface conversion", "type_assertion"},
+	{"close of closed channel", "closed_channel"},
+	{"close of nil channel", "closed_channel"},
+	{"send on closed channel", "closed_channel"},
+	{"all goroutines are asleep - deadlock!", "deadlock"},
+	{"concurrent map", "concurrent_map_access"},
+	{"stack overflow", "stack_overflow"},
+	{"runtime: out of memory", "out_of_memory"},
+}
+
+var (
+	goroutineHeaderRegex = regexp.MustCompile(`^goroutine (\d+)\b.*\[.*\]:$`)
+	frameLocationRegex   = regexp.MustCompile(`^\t(.+):(\d+)(?: \+0x[0-9a-f]+)?$`)
+)
+
+// parsePanic recognizes the report the Go runtime prints when a program
+// panics or dies of a fatal error, and returns nil for any other output.
+// Only frames in the files of the module at modulePath are kept; programs
+// are built with -trimpath, so those files are printed below that path.
+func parsePanic(stderr, modulePath string) *PanicInfo {
+	lines := strings.Split(stderr, "\n")
+
+	// The report starts with the panic line, or several of them when a
+	// panic happened during another, and goes on with the goroutine stacks
+	var info *PanicInfo
+	start := -1
+	for i, line := range lines {
+		line = strings.TrimPrefix(line, "\t")
+		switch {
+		case strings.HasPrefix(line, "panic: "):
+			info = &PanicInfo{Message: strings.TrimSuffix(strings.TrimPrefix(line, "panic: "), " [recovered]")}
+		case strings.HasPrefix(line, "fatal error: "):
+			info = &PanicInfo{Message: strings.TrimPrefix(line, "fatal error: "), Fatal: true}
+		case info != nil && goroutineHeaderRegex.MatchString(line):
+			info.Goroutine, _ = strconv.Atoi(goroutineHeaderRegex.FindStringSubmatch(line)[1])
+			start = i + 1
+		}
+		if start >= 0 {
+			break
+		}
+	}
+	if info == nil || start < 0 {
+		return nil
+	}
+
+	info.Kind = "panic"
+	runtimeError := strings.HasPrefix(info.Message, "runtime error: ")
+	info.Message = strings.TrimPrefix(info.Message, "runtime error: ")
+	for _, known := range runtimeErrorKinds {
+		if strings.HasPrefix(info.Message, known.prefix) {
+			info.Kind = known.kind
+			break
+		}
+	}
+	if info.Kind == "panic" && (runtimeError || info.Fatal) {
+		info.Kind = "runtime_error"
+	}
+
+	info.Stack = []StackFrame{}
+	for i := start; i+1 < len(lines) && lines[i] != ""; i += 2 {
+		function, location := lines[i], frameLocationRegex.FindStringSubmatch(lines[i+1])
+		if location == nil {
+			break
+		}
+		file, ok := strings.CutPrefix(location[1], modulePath+"/")
+		if !ok {
+			continue
+		}
+		line, _ := strconv.Atoi(location[2])
+
+		frame := StackFrame{File: file, Line: line}
+		if creator, ok := strings.CutPrefix(function, "created by "); ok {
+			frame.CreatedBy = true
+			function, _, _ = strings.Cut(creator, " in goroutine ")
+		}
+		// Drop the argument list, which only shows raw words
+		if paren := strings.LastIndex(function, "("); paren > 0 && strings.HasSuffix(function, ")") {
+			function = function[:paren]
+		}
+		frame.Function = function
+		info.Stack = append(info.Stack, frame)
+	}
+	return info
+}
+
+// setPanic attaches the parsed panic of a failed run and names it and the
+// learner's line it happened on in the error. A run stopped by one of the
+// limits keeps the error naming that limit.
+func (r *CodeExecutionResponse) setPanic(files map[string]string) {
+	if r.ExitCode == nil || *r.ExitCode == 0 || r.LimitExceeded != "" {
+		return
+	}
+	modulePath := workspaceModulePath(files["go.mod"])
+	info := parsePanic(r.Stderr, modulePath)
+	if info == nil {
+		return
+	}
+
+	r.Panic = info
+	r.Error = "panic: " + info.Message
+	if info.Fatal {
+		r.Error = "fatal error: " + info.Message
+	}
+	if len(info.Stack) > 0 {
+		r.Error += fmt.Sprintf(" (%s:%d)", info.Stack[0].File, info.Stack[0].Line)
+	}
+}
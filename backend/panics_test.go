@@ -0,0 +1,116 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePanic(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   *PanicInfo
+	}{
+		{
+			name: "runtime error in an inlined call",
+			stderr: "panic: runtime error: index out of range [5] with length 3\n\n" +
+				"goroutine 1 [running]:\n" +
+				"main.get(...)\n" +
+				"\tsandbox/main.go:8\n" +
+				"main.main()\n" +
+				"\tsandbox/main.go:12 +0x1d\n" +
+				"exit status 2\n",
+			want: &PanicInfo{
+				Message:   "index out of range [5] with length 3",
+				Kind:      "index_out_of_range",
+				Goroutine: 1,
+				Stack: []StackFrame{
+					{Function: "main.get", File: "main.go", Line: 8},
+					{Function: "main.main", File: "main.go", Line: 12},
+				},
+			},
+		},
+		{
+			name: "panic called by the program keeps only its own frames",
+			stderr: "panic: something went wrong\n\n" +
+				"goroutine 1 [running]:\n" +
+				"runtime.gopanic({0x4a1f20?, 0x4e3a10?})\n" +
+				"\t/usr/local/go/src/runtime/panic.go:770 +0x132\n" +
+				"example/greet.check(0x0)\n" +
+				"\tsandbox/greet/greet.go:5 +0x45\n" +
+				"main.main()\n" +
+				"\tsandbox/main.go:9 +0x18\n",
+			want: &PanicInfo{
+				Message:   "something went wrong",
+				Kind:      "panic",
+				Goroutine: 1,
+				Stack: []StackFrame{
+					{Function: "example/greet.check", File: "greet/greet.go", Line: 5},
+					{Function: "main.main", File: "main.go", Line: 9},
+				},
+			},
+		},
+		{
+			name: "fatal deadlock",
+			stderr: "fatal error: all goroutines are asleep - deadlock!\n\n" +
+				"goroutine 1 [chan receive]:\n" +
+				"main.main()\n" +
+				"\tsandbox/main.go:5 +0x2d\n",
+			want: &PanicInfo{
+				Message:   "all goroutines are asleep - deadlock!",
+				Kind:      "deadlock",
+				Fatal:     true,
+				Goroutine: 1,
+				Stack:     []StackFrame{{Function: "main.main", File: "main.go", Line: 5}},
+			},
+		},
+		{
+			name: "panic in a goroutine names its creator",
+			stderr: "panic: assignment to entry in nil map\n\n" +
+				"goroutine 6 [running]:\n" +
+				"main.worker(0x0?)\n" +
+				"\tsandbox/main.go:6 +0x25\n" +
+				"created by main.main in goroutine 1\n" +
+				"\tsandbox/main.go:10 +0x1f\n",
+			want: &PanicInfo{
+				Message:   "assignment to entry in nil map",
+				Kind:      "nil_map_write",
+				Goroutine: 6,
+				Stack: []StackFrame{
+					{Function: "main.worker", File: "main.go", Line: 6},
+					{Function: "main.main", File: "main.go", Line: 10, CreatedBy: true},
+				},
+			},
+		},
+		{
+			name: "panic during a recovered panic reports the last one",
+			stderr: "panic: first [recovered]\n" +
+				"\tpanic: runtime error: hash of unhashable type []int\n\n" +
+				"goroutine 1 [running]:\n" +
+				"main.main()\n" +
+				"\tsandbox/main.go:7 +0x3a\n",
+			want: &PanicInfo{
+				Message:   "hash of unhashable type []int",
+				Kind:      "runtime_error",
+				Goroutine: 1,
+				Stack:     []StackFrame{{Function: "main.main", File: "main.go", Line: 7}},
+			},
+		},
+		{
+			name:   "exit without a panic",
+			stderr: "exit status 1\n",
+		},
+		{
+			name:   "program printing a panic line",
+			stderr: "panic: not really\ndone\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsePanic(tt.stderr, "sandbox"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePanic() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
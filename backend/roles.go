@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Role decides what a user may do
+type Role string
+
+// Roles of users; new accounts are learners
+const (
+	RoleLearner    Role = "learner"
+	RoleInstructor Role = "instructor"
+	RoleAdmin      Role = "admin"
+)
+
+// Permission names a privileged action
+type Permission string
+
+// Permissions granted on top of a learner's access to their own data
+const (
+	// PermViewStudents lets instructors see the progress of their students
+	PermViewStudents Permission = "students:view"
+
+	// PermViewAllProgress lets a user see the progress of any learner
+	PermViewAllProgress Permission = "progress:view_all"
+
+	PermManageUsers Permission = "users:manage"
+	PermEditLessons Permission = "lessons:edit"
+	PermViewAudit   Permission = "audit:view"
+)
+
+// rolePermissions lists what every role may do
+var rolePermissions = map[Role][]Permission{
+	RoleLearner:    {},
+	RoleInstructor: {PermViewStudents},
+	RoleAdmin:      {PermViewStudents, PermViewAllProgress, PermManageUsers, PermEditLessons, PermViewAudit},
+}
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Can reports whether the role grants a permission
+func (r Role) Can(permission Permission) bool {
+	return slices.Contains(rolePermissions[r], permission)
+}
+
+// authorize only lets users through whose role grants a permission
+func authorize(permission Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		guard(c, string(permission), func(role Role) bool { return role.Can(permission) })
+	}
+}
+
+// requireRole only lets users with the given role through
+func requireRole(required Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		guard(c, "role:"+string(required), func(role Role) bool { return role == required })
+	}
+}
+
+// guard rejects anonymous requests and those of users whose role fails
+// allowed; refusals are audited with what was required
+func guard(c *gin.Context, required string, allowed func(Role) bool) {
+	if currentUserID(c) == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Login required"})
+		return
+	}
+	role, err := currentRole(c)
+	if err != nil {
+		log.Printf("Error getting role of user %s: %v", currentUserID(c), err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+		return
+	}
+	if !allowed(role) {
+		recordAudit(c, "access.denied", c.Request.Method+" "+c.FullPath(), map[string]any{"required": required, "role": role})
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	c.Next()
+}
+
+// currentRole returns the role of the logged-in user, loaded once per
+// request so that role changes apply at once rather than when the user's
+// token expires. Anonymous requests and deleted users have no role.
+func currentRole(c *gin.Context) (Role, error) {
+	if role, ok := c.Get("role"); ok {
+		return role.(Role), nil
+	}
+	if currentUserID(c) == "" {
+		return "", nil
+	}
+
+	user, err := database.GetUser(currentUserID(c))
+	if errors.Is(err, errUserNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	c.Set("role", user.Role)
+	return user.Role, nil
+}
@@ -0,0 +1,192 @@
+//go:build linux && (amd64 || arm64)
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// Secure bits that stop uid 0 from regaining capabilities on execve
+// (see capabilities(7)); they are not exported by x/sys/unix
+const (
+	secbitNoRoot                = 1 << 0
+	secbitNoRootLocked          = 1 << 1
+	secbitNoSetuidFixup         = 1 << 2
+	secbitNoSetuidFixupLocked   = 1 << 3
+	secbitKeepCapsLocked        = 1 << 5
+	secbitNoCapAmbientRaise     = 1 << 6
+	secbitNoCapAmbientRaiseLock = 1 << 7
+)
+
+// sandboxReportFD is the descriptor the sandbox init reports a failure to
+// build the sandbox on. It is closed when the program is executed, so that
+// the program cannot fake a failure of the sandbox.
+const sandboxReportFD = 3
+
+// runSandboxInit is the entry point of the sandbox init process. It runs
+// inside the namespaces created by SandboxExecutor, confines itself and
+// then replaces itself with the learner's program.
+func runSandboxInit(args []string) {
+	fs := flag.NewFlagSet(sandboxInitCommand, flag.ExitOnError)
+	root := fs.String("root", "", "job directory holding bin and rootfs")
+	memoryMB := fs.Int("memory-mb", 512, "data segment limit")
+	cpuSeconds := fs.Int("cpu-seconds", 5, "CPU time limit")
+	maxOpenFiles := fs.Int("max-open-files", 64, "open file descriptor limit")
+	maxProcesses := fs.Int("max-processes", 64, "process and thread limit")
+	maxFileSizeMB := fs.Int("max-file-size-mb", 8, "size limit for written files and /tmp")
+	probe := fs.Bool("probe", false, "set up the sandbox and exit")
+	fs.Parse(args)
+
+	report := os.NewFile(sandboxReportFD, "sandbox report")
+	unix.CloseOnExec(sandboxReportFD)
+	fail := func(step string, err error) {
+		fmt.Fprintf(report, "%s: %v", step, err)
+		os.Exit(sandboxSetupExitCode)
+	}
+
+	if err := setupSandboxRoot(*root, *maxFileSizeMB); err != nil {
+		fail("filesystem", err)
+	}
+
+	// RLIMIT_DATA rather than RLIMIT_AS: the Go runtime reserves far more
+	// address space than it uses, but only counts committed memory
+	limits := []struct {
+		resource int
+		value    uint64
+	}{
+		{unix.RLIMIT_DATA, uint64(*memoryMB) << 20},
+		{unix.RLIMIT_CPU, uint64(*cpuSeconds)},
+		{unix.RLIMIT_NOFILE, uint64(*maxOpenFiles)},
+		{unix.RLIMIT_NPROC, uint64(*maxProcesses)},
+		{unix.RLIMIT_FSIZE, uint64(*maxFileSizeMB) << 20},
+		{unix.RLIMIT_CORE, 0},
+	}
+	for _, limit := range limits {
+		// Zero turns a configured limit off; core dumps are always off
+		if limit.value == 0 && limit.resource != unix.RLIMIT_CORE {
+			continue
+		}
+		rlimit := unix.Rlimit{Cur: limit.value, Max: limit.value}
+		if err := unix.Setrlimit(limit.resource, &rlimit); err != nil {
+			fail("rlimit", err)
+		}
+	}
+
+	// Capabilities, no_new_privs and seccomp filters belong to a thread, so
+	// they are set on the thread that calls execve
+	runtime.LockOSThread()
+
+	if err := dropCapabilities(); err != nil {
+		fail("capabilities", err)
+	}
+	if err := installSeccompFilter(); err != nil {
+		fail("seccomp", err)
+	}
+
+	if *probe {
+		os.Exit(0)
+	}
+
+	argv := append([]string{"/app/prog"}, fs.Args()...)
+	env := []string{"HOME=/tmp", "TMPDIR=/tmp", "PATH=/app"}
+	if err := unix.Exec("/app/prog", argv, env); err != nil {
+		fail("exec", err)
+	}
+}
+
+// setupSandboxRoot pivots into a fresh tmpfs that contains only the program
+// (read-only at /app), a small writable /tmp and a few device nodes
+func setupSandboxRoot(root string, tmpSizeMB int) error {
+	rootfs := filepath.Join(root, "rootfs")
+
+	// Keep our mounts from propagating back to the host
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %v", err)
+	}
+	if err := unix.Mount("tmpfs", rootfs, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "size=1m,mode=0755"); err != nil {
+		return fmt.Errorf("mount root: %v", err)
+	}
+
+	for _, dir := range []string{"app", "tmp", "dev", ".old"} {
+		if err := os.Mkdir(filepath.Join(rootfs, dir), 0755); err != nil {
+			return err
+		}
+	}
+
+	app := filepath.Join(rootfs, "app")
+	if err := unix.Mount(filepath.Join(root, "bin"), app, "", unix.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind program: %v", err)
+	}
+	if err := unix.Mount("", app, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("remount program read-only: %v", err)
+	}
+
+	tmpOptions := fmt.Sprintf("size=%dm,mode=1777", tmpSizeMB)
+	if err := unix.Mount("tmpfs", filepath.Join(rootfs, "tmp"), "tmpfs", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, tmpOptions); err != nil {
+		return fmt.Errorf("mount /tmp: %v", err)
+	}
+
+	for _, device := range []string{"null", "zero", "random", "urandom"} {
+		target := filepath.Join(rootfs, "dev", device)
+		if err := os.WriteFile(target, nil, 0644); err != nil {
+			return err
+		}
+		if err := unix.Mount("/dev/"+device, target, "", unix.MS_BIND, ""); err != nil {
+			return fmt.Errorf("bind /dev/%s: %v", device, err)
+		}
+	}
+
+	if err := unix.PivotRoot(rootfs, filepath.Join(rootfs, ".old")); err != nil {
+		return fmt.Errorf("pivot_root: %v", err)
+	}
+	if err := unix.Chdir("/"); err != nil {
+		return err
+	}
+	if err := unix.Unmount("/.old", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("detach host root: %v", err)
+	}
+	if err := os.Remove("/.old"); err != nil {
+		return err
+	}
+	if err := unix.Mount("", "/", "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("remount root read-only: %v", err)
+	}
+
+	if err := unix.Sethostname([]byte("sandbox")); err != nil {
+		return err
+	}
+	return unix.Chdir("/tmp")
+}
+
+// dropCapabilities clears every capability of the calling thread, including
+// the bounding and ambient sets, and locks the secure bits so that running
+// as uid 0 inside the user namespace grants nothing after execve
+func dropCapabilities() error {
+	secbits := secbitNoRoot | secbitNoRootLocked | secbitNoSetuidFixup | secbitNoSetuidFixupLocked |
+		secbitKeepCapsLocked | secbitNoCapAmbientRaise | secbitNoCapAmbientRaiseLock
+	if err := unix.Prctl(unix.PR_SET_SECUREBITS, uintptr(secbits), 0, 0, 0); err != nil {
+		return fmt.Errorf("set securebits: %v", err)
+	}
+
+	for c := 0; c <= unix.CAP_LAST_CAP; c++ {
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil && err != unix.EINVAL {
+			return fmt.Errorf("drop bounding capability %d: %v", c, err)
+		}
+	}
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return fmt.Errorf("clear ambient capabilities: %v", err)
+	}
+
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capset(&header, &data[0]); err != nil {
+		return fmt.Errorf("capset: %v", err)
+	}
+	return nil
+}
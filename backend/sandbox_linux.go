@@ -0,0 +1,295 @@
+//go:build linux && (amd64 || arm64)
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SandboxExecutor compiles code with the host toolchain and runs the binary
+// in new user, PID, network and mount namespaces under rlimits and a seccomp
+// filter. It needs no Docker daemon, only unprivileged user namespaces.
+type SandboxExecutor struct {
+	hostBuilder
+	cfg    SandboxConfig
+	limits ResourceLimits
+}
+
+// newSandboxExecutor creates the namespace sandbox backend
+func newSandboxExecutor(cfg SandboxConfig, limits ResourceLimits, modules ModulesConfig, cache *BuildCache, toolchains ToolchainConfig) (Executor, error) {
+	return &SandboxExecutor{hostBuilder: hostBuilder{modules: modules, cache: cache, toolchainConfig: toolchains}, cfg: cfg, limits: limits}, nil
+}
+
+// Name implements Executor
+func (e *SandboxExecutor) Name() string {
+	return "sandbox"
+}
+
+// UnenforcedLimits implements LimitsReporter; rlimits cannot cap CPUs
+func (e *SandboxExecutor) UnenforcedLimits() []string {
+	if e.limits.CPUs > 0 {
+		return []string{"LIMIT_CPUS"}
+	}
+	return nil
+}
+
+// Check implements Executor by building an empty sandbox without running
+// anything in it, and by making sure the Go toolchains still run
+func (e *SandboxExecutor) Check(ctx context.Context) error {
+	dir, err := e.prepareDir()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	cmd := e.command(ctx, dir, e.limits, true)
+	report, err := attachSetupReport(cmd)
+	if err != nil {
+		return err
+	}
+	out, err := cmd.CombinedOutput()
+	if err := report.check(); err != nil {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("cannot create sandbox: %v %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return e.checkToolchains(ctx)
+}
+
+// Execute implements Executor
+func (e *SandboxExecutor) Execute(ctx context.Context, req *CodeExecutionRequest, streams *ExecutionStreams) (*CodeExecutionResponse, error) {
+	toolchain, err := e.toolchain(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	response, err := e.execute(ctx, req.withToolchain(toolchain), toolchain, streams)
+	if response != nil {
+		response.GoVersion = toolchain.Version
+	}
+	return response, err
+}
+
+// execute builds the request with the given release and runs it in the
+// sandbox
+func (e *SandboxExecutor) execute(ctx context.Context, req *CodeExecutionRequest, toolchain Toolchain, streams *ExecutionStreams) (*CodeExecutionResponse, error) {
+	files, err := req.workspaceFiles()
+	if err != nil {
+		return nil, err
+	}
+	if rejected := e.modules.checkImports(files); rejected != nil {
+		return rejected, nil
+	}
+
+	dir, err := e.prepareDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := writeWorkspace(filepath.Join(dir, "src"), files); err != nil {
+		return nil, err
+	}
+
+	// Compile on the host; only the resulting binary enters the sandbox
+	tests := req.TestCode != ""
+	build := e.build(ctx, toolchain, files, filepath.Join(dir, "src"), filepath.Join(dir, "bin", "prog"), tests)
+	if build.err != nil {
+		return compileFailure(build), nil
+	}
+
+	var response *CodeExecutionResponse
+	if tests {
+		response, err = e.runTests(ctx, dir, toolchain)
+	} else {
+		response, err = e.run(ctx, dir, req, streams)
+	}
+	if err != nil {
+		return nil, err
+	}
+	response.CompileOutput = build.output
+	response.CompileTimeMs = build.duration.Milliseconds()
+	response.Cached = build.cached
+	if !tests {
+		response.setPanic(files)
+	}
+
+	return response, nil
+}
+
+// run executes the compiled program in the sandbox
+func (e *SandboxExecutor) run(ctx context.Context, dir string, req *CodeExecutionRequest, streams *ExecutionStreams) (*CodeExecutionResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.limits.wallTime())
+	defer cancel()
+
+	output := newOutputCollector(streams.output(), e.limits.outputBytes(), cancel)
+	cmd := e.command(ctx, dir, e.limits, false)
+	cmd.Stdout = output.Stream("stdout")
+	cmd.Stderr = output.Stream("stderr")
+	if err := attachStdin(cmd, req, streams); err != nil {
+		return nil, err
+	}
+	report, err := attachSetupReport(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	err = cmd.Run()
+	if err := report.check(); err != nil {
+		return nil, err
+	}
+
+	response := &CodeExecutionResponse{}
+	output.setOutput(response)
+	response.setRunResult(ctx, err, time.Since(start))
+
+	outcome := outcomeOf(ctx, err, cmd.ProcessState)
+	outcome.outputExceeded = output.Exceeded()
+	outcome.output = response.Output
+	response.checkLimits(e.limits, outcome)
+
+	return response, nil
+}
+
+// runTests runs the compiled test binary in the sandbox and converts its
+// output to the go test -json format on the host
+func (e *SandboxExecutor) runTests(ctx context.Context, dir string, toolchain Toolchain) (*CodeExecutionResponse, error) {
+	limits := e.limits.forTests()
+	ctx, cancel := context.WithTimeout(ctx, limits.wallTime())
+	defer cancel()
+
+	raw := newOutputCollector(nil, limits.outputBytes(), cancel)
+	stderr := newOutputCollector(nil, limits.outputBytes(), cancel)
+	cmd := e.command(ctx, dir, limits, false, "-test.v=test2json")
+	cmd.Stdout = raw.Stream("stdout")
+	cmd.Stderr = stderr.Stream("stderr")
+	report, err := attachSetupReport(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	runErr := cmd.Run()
+	duration := time.Since(start)
+	if err := report.check(); err != nil {
+		return nil, err
+	}
+
+	stream, err := convertTestOutput(toolchain, raw.String())
+	if err != nil {
+		return nil, err
+	}
+
+	response := buildTestResponse(ctx, stream, stderr.String(), runErr, duration)
+	outcome := outcomeOf(ctx, runErr, cmd.ProcessState)
+	outcome.outputExceeded = raw.Exceeded() || stderr.Exceeded()
+	outcome.output = response.Output
+	response.checkLimits(limits, outcome)
+	return response, nil
+}
+
+// setupReport is the pipe a sandbox init reports on, at sandboxReportFD,
+// why it could not build the sandbox
+type setupReport struct {
+	r, w *os.File
+}
+
+// attachSetupReport hands cmd the write end of a setup report pipe
+func attachSetupReport(cmd *exec.Cmd) (*setupReport, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pipe: %v", err)
+	}
+	cmd.ExtraFiles = []*os.File{w}
+	return &setupReport{r: r, w: w}, nil
+}
+
+// check reads the report once cmd has finished and returns the failure of
+// the sandbox init, which is a backend error rather than something the
+// learner's program did. The program itself has no way to report one.
+func (s *setupReport) check() error {
+	s.w.Close()
+	defer s.r.Close()
+	report, err := io.ReadAll(io.LimitReader(s.r, 4096))
+	if err != nil {
+		return fmt.Errorf("failed to read sandbox report: %v", err)
+	}
+	if len(report) > 0 {
+		return fmt.Errorf("failed to set up sandbox: %s", report)
+	}
+	return nil
+}
+
+// prepareDir creates the job directory with src, bin and rootfs children.
+// The sandbox maps its root user to an unprivileged host user, so the
+// directories must be readable by everyone.
+func (e *SandboxExecutor) prepareDir() (string, error) {
+	dir, err := os.MkdirTemp("", "go_sandbox_")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	for _, sub := range []string{"", "src", "bin", "rootfs"} {
+		path := filepath.Join(dir, sub)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("failed to create %s: %v", path, err)
+		}
+		if err := os.Chmod(path, 0755); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("failed to chmod %s: %v", path, err)
+		}
+	}
+	return dir, nil
+}
+
+// command re-executes the server binary as the sandbox init process inside
+// fresh namespaces. The init sets up the sandbox and then replaces itself
+// with dir/bin/prog, or exits straight away when probe is set.
+func (e *SandboxExecutor) command(ctx context.Context, dir string, limits ResourceLimits, probe bool, progArgs ...string) *exec.Cmd {
+	args := []string{
+		sandboxInitCommand,
+		"-root", dir,
+		"-memory-mb", strconv.Itoa(limits.MemoryMB),
+		"-cpu-seconds", strconv.Itoa(limits.CPUSeconds),
+		"-max-open-files", strconv.Itoa(e.cfg.MaxOpenFiles),
+		"-max-processes", strconv.Itoa(limits.Processes),
+		"-max-file-size-mb", strconv.Itoa(e.cfg.MaxFileSizeMB),
+		"-probe=" + strconv.FormatBool(probe),
+		"--",
+	}
+	args = append(args, progArgs...)
+
+	cmd := exec.CommandContext(ctx, "/proc/self/exe", args...)
+	cmd.Env = []string{}
+	cmd.WaitDelay = pipeWaitDelay
+
+	// Root inside the sandbox must not be root outside of it
+	hostUID, hostGID := os.Getuid(), os.Getgid()
+	if hostUID == 0 {
+		hostUID, hostGID = 65534, 65534
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET |
+			syscall.CLONE_NEWNS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: hostUID, Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: hostGID, Size: 1}},
+		// Switch to the mapped root; a root server would otherwise keep its
+		// unmapped host credentials and get no capabilities in the namespace
+		Credential: &syscall.Credential{Uid: 0, Gid: 0, NoSetGroups: true},
+		Pdeathsig:  syscall.SIGKILL,
+	}
+
+	return cmd
+}
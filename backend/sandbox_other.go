@@ -0,0 +1,20 @@
+//go:build !linux || !(amd64 || arm64)
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// newSandboxExecutor reports that the namespace sandbox is unavailable
+func newSandboxExecutor(cfg SandboxConfig, limits ResourceLimits, modules ModulesConfig, cache *BuildCache, toolchains ToolchainConfig) (Executor, error) {
+	return nil, fmt.Errorf("the sandbox backend requires linux on amd64 or arm64, not %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// runSandboxInit is never reached on platforms without the sandbox backend
+func runSandboxInit(args []string) {
+	fmt.Fprintln(os.Stderr, "sandbox: not supported on", runtime.GOOS+"/"+runtime.GOARCH)
+	os.Exit(sandboxSetupExitCode)
+}
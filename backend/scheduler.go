@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scheduler bounds how many programs are compiled and run at once. Runs
+// beyond the worker limit wait in a bounded FIFO queue, and every user may
+// only have a few runs queued or running at a time.
+type Scheduler struct {
+	workers  int
+	perUser  int
+	maxQueue int
+
+	mu       sync.Mutex
+	running  int
+	queue    []*schedulerTicket
+	inFlight map[string]int
+
+	// avgRun is a moving average of how long a slot is held, used to tell
+	// rejected clients when to retry
+	avgRun time.Duration
+
+	completed int64
+	rejected  int64
+}
+
+// schedulerTicket is a run waiting in the queue
+type schedulerTicket struct {
+	user       string
+	ready      chan struct{}
+	dispatched bool
+
+	// position holds the latest queue position not yet reported
+	position chan int
+}
+
+// SchedulerStats is reported by /api/metrics
+type SchedulerStats struct {
+	Workers   int   `json:"workers"`
+	Running   int   `json:"running"`
+	Queued    int   `json:"queued"`
+	QueueSize int   `json:"queue_size"`
+	Completed int64 `json:"completed"`
+	Rejected  int64 `json:"rejected"`
+}
+
+// BusyError is returned when a run can neither start nor be queued
+type BusyError struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *BusyError) Error() string {
+	return e.Message
+}
+
+// RetryAfterSeconds rounds RetryAfter up to whole seconds for the
+// Retry-After header
+func (e *BusyError) RetryAfterSeconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+// NewScheduler creates a scheduler with the configured limits. A worker
+// limit below one is raised to one.
+func NewScheduler(cfg SchedulerConfig) *Scheduler {
+	return &Scheduler{
+		workers:  max(cfg.Workers, 1),
+		perUser:  cfg.PerUser,
+		maxQueue: max(cfg.QueueSize, 0),
+		inFlight: make(map[string]int),
+		avgRun:   2 * time.Second,
+	}
+}
+
+// Acquire waits for a worker slot for user and returns the function that
+// gives it back. While the run is queued, onQueued is called with its
+// 1-based queue position whenever that changes; it may be nil. A *BusyError
+// is returned at once when the user has too many runs in flight or the queue
+// is full, and ctx's error when ctx ends while waiting.
+func (s *Scheduler) Acquire(ctx context.Context, user string, onQueued func(position int)) (func(), error) {
+	s.mu.Lock()
+	if s.perUser > 0 && s.inFlight[user] >= s.perUser {
+		s.rejected++
+		err := &BusyError{
+			Message:    "Too many of your programs are running or waiting, wait for one to finish",
+			RetryAfter: s.avgRun,
+		}
+		s.mu.Unlock()
+		return nil, err
+	}
+	if s.running < s.workers && len(s.queue) == 0 {
+		s.running++
+		s.inFlight[user]++
+		s.mu.Unlock()
+		return s.releaser(user), nil
+	}
+	if len(s.queue) >= s.maxQueue {
+		s.rejected++
+		err := &BusyError{
+			Message:    "The server is busy running other programs, try again shortly",
+			RetryAfter: s.waitEstimate(len(s.queue)),
+		}
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	ticket := &schedulerTicket{
+		user:     user,
+		ready:    make(chan struct{}),
+		position: make(chan int, 1),
+	}
+	s.queue = append(s.queue, ticket)
+	s.inFlight[user]++
+	ticket.report(len(s.queue))
+	s.mu.Unlock()
+
+	for {
+		select {
+		case <-ticket.ready:
+			return s.releaser(user), nil
+		case position := <-ticket.position:
+			if onQueued != nil {
+				onQueued(position)
+			}
+		case <-ctx.Done():
+			s.mu.Lock()
+			if ticket.dispatched {
+				// The slot was handed over just as ctx ended. Nothing ran in
+				// it, so it goes back without counting as a run.
+				s.giveBack(user)
+				s.mu.Unlock()
+				return nil, ctx.Err()
+			}
+			s.remove(ticket)
+			s.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Stats returns the scheduler's limits and counters
+func (s *Scheduler) Stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return SchedulerStats{
+		Workers:   s.workers,
+		Running:   s.running,
+		Queued:    len(s.queue),
+		QueueSize: s.maxQueue,
+		Completed: s.completed,
+		Rejected:  s.rejected,
+	}
+}
+
+// releaser returns the function that gives a slot back exactly once
+func (s *Scheduler) releaser(user string) func() {
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			s.avgRun = (s.avgRun*7 + time.Since(start)) / 8
+			s.completed++
+			s.giveBack(user)
+		})
+	}
+}
+
+// giveBack frees a slot of user's and hands it on. The caller holds mu.
+func (s *Scheduler) giveBack(user string) {
+	s.running--
+	s.done(user)
+	s.dispatch()
+}
+
+// dispatch hands free slots to the head of the queue. The caller holds mu.
+func (s *Scheduler) dispatch() {
+	if s.running >= s.workers || len(s.queue) == 0 {
+		return
+	}
+	for s.running < s.workers && len(s.queue) > 0 {
+		ticket := s.queue[0]
+		s.queue = s.queue[1:]
+		ticket.dispatched = true
+		s.running++
+		close(ticket.ready)
+	}
+	s.reportPositions()
+}
+
+// remove drops a ticket whose caller gave up waiting. The caller holds mu.
+func (s *Scheduler) remove(ticket *schedulerTicket) {
+	for i, queued := range s.queue {
+		if queued == ticket {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			break
+		}
+	}
+	s.done(ticket.user)
+	s.reportPositions()
+}
+
+// done forgets one of user's runs. The caller holds mu.
+func (s *Scheduler) done(user string) {
+	if s.inFlight[user]--; s.inFlight[user] <= 0 {
+		delete(s.inFlight, user)
+	}
+}
+
+// reportPositions tells every queued run its current position. The caller
+// holds mu.
+func (s *Scheduler) reportPositions() {
+	for i, ticket := range s.queue {
+		ticket.report(i + 1)
+	}
+}
+
+// waitEstimate guesses how long until a run behind queued others starts.
+// The caller holds mu.
+func (s *Scheduler) waitEstimate(queued int) time.Duration {
+	rounds := queued/s.workers + 1
+	return max(time.Duration(rounds)*s.avgRun, time.Second)
+}
+
+// report replaces the position waiting to be reported, so that a slow
+// listener only ever sees the latest one
+func (t *schedulerTicket) report(position int) {
+	select {
+	case <-t.position:
+	default:
+	}
+	t.position <- position
+}
+
+// respondNotScheduled answers a request that did not get a worker slot:
+// 429 with a Retry-After header when the server is busy
+func respondNotScheduled(c *gin.Context, err error) {
+	var busy *BusyError
+	if !errors.As(err, &busy) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Request cancelled while queued"})
+		return
+	}
+	seconds := busy.RetryAfterSeconds()
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": busy.Message, "retry_after": seconds})
+}
+
+// respondExecutionError answers for an executor that failed, asking the
+// client to retry later when the executor was too busy to take the program
+func respondExecutionError(c *gin.Context, err error) {
+	var busy *BusyError
+	if errors.As(err, &busy) {
+		respondNotScheduled(c, err)
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// requestUser identifies who a request counts against for the per-user
+// limit: the logged-in user, and the client address for anonymous requests
+func requestUser(c *gin.Context) string {
+	if user := currentUserID(c); user != "" {
+		return "user:" + user
+	}
+	return "ip:" + c.ClientIP()
+}
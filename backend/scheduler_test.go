@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// acquireOutcome is what a call to Acquire did
+type acquireOutcome string
+
+const (
+	started acquireOutcome = "started"
+	queued  acquireOutcome = "queued"
+	busy    acquireOutcome = "busy"
+)
+
+// tryAcquire calls Acquire for user and reports whether the run started,
+// was queued or was turned away. A started run holds its slot until the
+// returned function is called; a queued run waits in the background until
+// ctx ends, and gives its slot straight back if it gets one.
+func tryAcquire(t *testing.T, ctx context.Context, s *Scheduler, user string, wg *sync.WaitGroup) (acquireOutcome, func()) {
+	t.Helper()
+	type result struct {
+		outcome acquireOutcome
+		release func()
+		err     error
+	}
+	results := make(chan result, 1)
+	var once sync.Once
+	report := func(r result) bool {
+		reported := false
+		once.Do(func() { results <- r; reported = true })
+		return reported
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		release, err := s.Acquire(ctx, user, func(int) { report(result{outcome: queued}) })
+		var busyErr *BusyError
+		switch {
+		case errors.As(err, &busyErr):
+			report(result{outcome: busy})
+		case err != nil:
+			report(result{err: err})
+		case !report(result{outcome: started, release: release}):
+			release()
+		}
+	}()
+
+	select {
+	case r := <-results:
+		if r.err != nil {
+			t.Fatalf("Acquire(%q) = %v", user, r.err)
+		}
+		return r.outcome, r.release
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Acquire(%q) neither started, queued nor failed", user)
+		return "", nil
+	}
+}
+
+func TestSchedulerLimits(t *testing.T) {
+	type acquire struct {
+		user string
+		want acquireOutcome
+	}
+	tests := []struct {
+		name     string
+		cfg      SchedulerConfig
+		acquires []acquire
+	}{
+		{
+			name:     "workers without a queue",
+			cfg:      SchedulerConfig{Workers: 2},
+			acquires: []acquire{{"a", started}, {"b", started}, {"c", busy}},
+		},
+		{
+			name:     "worker limit below one is raised to one",
+			cfg:      SchedulerConfig{Workers: 0},
+			acquires: []acquire{{"a", started}, {"b", busy}},
+		},
+		{
+			name: "queue up to its size",
+			cfg:  SchedulerConfig{Workers: 1, QueueSize: 2},
+			acquires: []acquire{
+				{"a", started}, {"b", queued}, {"c", queued}, {"d", busy},
+			},
+		},
+		{
+			name: "per-user limit counts queued runs",
+			cfg:  SchedulerConfig{Workers: 1, PerUser: 2, QueueSize: 4},
+			acquires: []acquire{
+				{"a", started}, {"a", queued}, {"a", busy}, {"b", queued},
+			},
+		},
+		{
+			name: "no per-user limit",
+			cfg:  SchedulerConfig{Workers: 1, QueueSize: 2},
+			acquires: []acquire{
+				{"a", started}, {"a", queued}, {"a", queued},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScheduler(tt.cfg)
+			ctx, cancel := context.WithCancel(context.Background())
+			var wg sync.WaitGroup
+			var releases []func()
+			rejected := 0
+			for i, a := range tt.acquires {
+				got, release := tryAcquire(t, ctx, s, a.user, &wg)
+				if got != a.want {
+					t.Fatalf("acquire %d by %q %s, want %s", i, a.user, got, a.want)
+				}
+				if release != nil {
+					releases = append(releases, release)
+				}
+				if got == busy {
+					rejected++
+				}
+			}
+			if stats := s.Stats(); stats.Rejected != int64(rejected) {
+				t.Errorf("rejected = %d, want %d", stats.Rejected, rejected)
+			}
+
+			cancel()
+			for _, release := range releases {
+				release()
+			}
+			wg.Wait()
+			if stats := s.Stats(); stats.Running != 0 || stats.Queued != 0 || len(s.inFlight) != 0 {
+				t.Errorf("after every run ended, stats = %+v and %d users in flight", stats, len(s.inFlight))
+			}
+		})
+	}
+}
+
+func TestSchedulerRunsQueueInOrder(t *testing.T) {
+	s := NewScheduler(SchedulerConfig{Workers: 1, QueueSize: 3})
+	release, err := s.Acquire(context.Background(), "a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	for i, user := range []string{"b", "c", "d"} {
+		user := user
+		positions := make(chan int, 3)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done, err := s.Acquire(context.Background(), user, func(position int) { positions <- position })
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, user)
+			mu.Unlock()
+			done()
+		}()
+		if position := <-positions; position != i+1 {
+			t.Fatalf("%s queued at position %d, want %d", user, position, i+1)
+		}
+	}
+
+	release()
+	release() // a second release is ignored
+	wg.Wait()
+	if want := []string{"b", "c", "d"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("runs started in order %v, want %v", order, want)
+	}
+	if stats := s.Stats(); stats.Running != 0 || stats.Completed != 4 {
+		t.Errorf("stats = %+v, want nothing running and 4 completed", stats)
+	}
+}
+
+func TestSchedulerCancelWhileQueued(t *testing.T) {
+	s := NewScheduler(SchedulerConfig{Workers: 1, PerUser: 1, QueueSize: 1})
+	release, err := s.Acquire(context.Background(), "a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	queuedAt := make(chan int, 1)
+	go func() {
+		_, err := s.Acquire(ctx, "b", func(position int) { queuedAt <- position })
+		result <- err
+	}()
+	<-queuedAt
+	cancel()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Acquire after cancel = %v, want %v", err, context.Canceled)
+	}
+
+	// The cancelled run no longer holds the queue or b's only run
+	if stats := s.Stats(); stats.Queued != 0 || s.inFlight["b"] != 0 {
+		t.Errorf("stats = %+v with %d runs in flight for b, want an empty queue", stats, s.inFlight["b"])
+	}
+	release()
+	again, err := s.Acquire(context.Background(), "b", nil)
+	if err != nil {
+		t.Fatalf("Acquire after the queue emptied = %v", err)
+	}
+	again()
+}
+
+func TestSchedulerCancelAfterDispatch(t *testing.T) {
+	// Acquire picks at random between a slot and its ended context when both
+	// are ready, so try until the slot is given back unused
+	for attempt := 0; attempt < 100; attempt++ {
+		s := NewScheduler(SchedulerConfig{Workers: 1, QueueSize: 1})
+		if _, err := s.Acquire(context.Background(), "a", nil); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		type result struct {
+			release func()
+			err     error
+		}
+		results := make(chan result, 1)
+		queuedAt := make(chan int, 1)
+		go func() {
+			release, err := s.Acquire(ctx, "b", func(position int) { queuedAt <- position })
+			results <- result{release, err}
+		}()
+		<-queuedAt
+
+		// End b's wait and hand it a's slot at the same time
+		s.mu.Lock()
+		cancel()
+		s.giveBack("a")
+		s.mu.Unlock()
+
+		r := <-results
+		if r.err == nil {
+			r.release()
+			continue
+		}
+		if !errors.Is(r.err, context.Canceled) {
+			t.Fatalf("Acquire after cancel = %v, want %v", r.err, context.Canceled)
+		}
+		// The unused slot is free again and did not count as a run
+		if stats := s.Stats(); stats.Running != 0 || stats.Completed != 0 || s.inFlight["b"] != 0 {
+			t.Errorf("stats = %+v with %d runs in flight for b, want the slot back and no run completed", stats, s.inFlight["b"])
+		}
+		if s.avgRun != 2*time.Second {
+			t.Errorf("average run = %v, want it unchanged at 2s", s.avgRun)
+		}
+		return
+	}
+	t.Fatal("Acquire never gave back a slot handed over after its context ended")
+}
+
+func TestBusyErrorRetryAfterSeconds(t *testing.T) {
+	tests := []struct {
+		retryAfter time.Duration
+		want       int
+	}{
+		{0, 0},
+		{time.Millisecond, 1},
+		{time.Second, 1},
+		{1500 * time.Millisecond, 2},
+	}
+	for _, tt := range tests {
+		if got := (&BusyError{RetryAfter: tt.retryAfter}).RetryAfterSeconds(); got != tt.want {
+			t.Errorf("RetryAfterSeconds() for %v = %d, want %d", tt.retryAfter, got, tt.want)
+		}
+	}
+}
+
+func TestRequestUser(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		forwardedFor   string
+		want           string
+	}{
+		{"client address", nil, "", "ip:192.0.2.1"},
+		{"forwarded address from an untrusted client", nil, "203.0.113.7", "ip:192.0.2.1"},
+		{"forwarded address from a trusted proxy", []string{"192.0.2.0/24"}, "203.0.113.7", "ip:203.0.113.7"},
+		{"forwarded address from another proxy", []string{"198.51.100.1"}, "203.0.113.7", "ip:192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newRouter(tt.trustedProxies)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			r.GET("/user", func(c *gin.Context) { got = requestUser(c) })
+
+			// httptest requests come from 192.0.2.1
+			req := httptest.NewRequest(http.MethodGet, "/user", nil)
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			r.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Errorf("requestUser() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+//go:build linux && (amd64 || arm64)
+
+package main
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Seccomp filter return values (see seccomp(2)); they are not exported by
+// x/sys/unix
+const (
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetAllow       = 0x7fff0000
+)
+
+// Offsets into struct seccomp_data
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArg0 = 16
+)
+
+// sysClone3 has the same number on every architecture we support
+const sysClone3 = 435
+
+// cloneNamespaceFlags are the clone flags that would create new namespaces
+const cloneNamespaceFlags = unix.CLONE_NEWNS | unix.CLONE_NEWCGROUP | unix.CLONE_NEWUTS |
+	unix.CLONE_NEWIPC | unix.CLONE_NEWUSER | unix.CLONE_NEWPID | unix.CLONE_NEWNET
+
+// seccompDeniedSyscalls are refused with EPERM. Together with the namespaces
+// and dropped capabilities they cover what the docker profile blocks that a
+// learner's program could still reach.
+var seccompDeniedSyscalls = []uint32{
+	unix.SYS_ACCT,
+	unix.SYS_ADD_KEY,
+	unix.SYS_ADJTIMEX,
+	unix.SYS_BPF,
+	unix.SYS_CHROOT,
+	unix.SYS_CLOCK_ADJTIME,
+	unix.SYS_CLOCK_SETTIME,
+	unix.SYS_DELETE_MODULE,
+	unix.SYS_FINIT_MODULE,
+	unix.SYS_FSCONFIG,
+	unix.SYS_FSMOUNT,
+	unix.SYS_FSOPEN,
+	unix.SYS_FSPICK,
+	unix.SYS_INIT_MODULE,
+	unix.SYS_IO_URING_ENTER,
+	unix.SYS_IO_URING_REGISTER,
+	unix.SYS_IO_URING_SETUP,
+	unix.SYS_KEXEC_FILE_LOAD,
+	unix.SYS_KEXEC_LOAD,
+	unix.SYS_KEYCTL,
+	unix.SYS_MOUNT,
+	unix.SYS_MOVE_MOUNT,
+	unix.SYS_NAME_TO_HANDLE_AT,
+	unix.SYS_OPEN_BY_HANDLE_AT,
+	unix.SYS_OPEN_TREE,
+	unix.SYS_PERF_EVENT_OPEN,
+	unix.SYS_PIVOT_ROOT,
+	unix.SYS_PROCESS_VM_READV,
+	unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_PTRACE,
+	unix.SYS_QUOTACTL,
+	unix.SYS_REBOOT,
+	unix.SYS_REQUEST_KEY,
+	unix.SYS_SETNS,
+	unix.SYS_SETTIMEOFDAY,
+	unix.SYS_SWAPOFF,
+	unix.SYS_SWAPON,
+	unix.SYS_SYSLOG,
+	unix.SYS_UMOUNT2,
+	unix.SYS_UNSHARE,
+	unix.SYS_USERFAULTFD,
+}
+
+// seccompFilter builds the BPF program installed before exec. Foreign
+// architectures kill the process, denied syscalls and clone calls that ask
+// for new namespaces fail with EPERM, and everything else is allowed.
+func seccompFilter() []unix.SockFilter {
+	stmt := func(code uint16, k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: code, K: k}
+	}
+	jump := func(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+	}
+	const (
+		load   = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+		ret    = unix.BPF_RET | unix.BPF_K
+		jeq    = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+		jge    = unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K
+		jset   = unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K
+		eperm  = seccompRetErrno | uint32(unix.EPERM)
+		enosys = seccompRetErrno | uint32(unix.ENOSYS)
+	)
+
+	filter := []unix.SockFilter{
+		stmt(load, seccompDataArch),
+		jump(jeq, seccompAuditArch, 1, 0),
+		stmt(ret, seccompRetKillProcess),
+		stmt(load, seccompDataNr),
+	}
+	if seccompX32SyscallBit != 0 {
+		filter = append(filter,
+			jump(jge, seccompX32SyscallBit, 0, 1),
+			stmt(ret, enosys),
+		)
+	}
+	for _, nr := range seccompDeniedSyscalls {
+		filter = append(filter,
+			jump(jeq, nr, 0, 1),
+			stmt(ret, eperm),
+		)
+	}
+
+	// clone3 passes its flags in memory the filter cannot read; report it as
+	// missing so that callers fall back to clone
+	filter = append(filter,
+		jump(jeq, sysClone3, 0, 1),
+		stmt(ret, enosys),
+		jump(jeq, unix.SYS_CLONE, 0, 3),
+		stmt(load, seccompDataArg0),
+		jump(jset, cloneNamespaceFlags, 0, 1),
+		stmt(ret, eperm),
+		stmt(ret, seccompRetAllow),
+	)
+
+	return filter
+}
+
+// installSeccompFilter sets no_new_privs and installs the filter on the
+// calling thread
+func installSeccompFilter() error {
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("set no_new_privs: %v", err)
+	}
+
+	filter := seccompFilter()
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0); err != nil {
+		return fmt.Errorf("install filter: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+const (
+	seccompAuditArch = unix.AUDIT_ARCH_X86_64
+
+	// seccompX32SyscallBit marks x32 ABI syscall numbers, which would
+	// otherwise bypass the x86_64 denylist
+	seccompX32SyscallBit = 0x40000000
+)
@@ -0,0 +1,10 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+const (
+	seccompAuditArch = unix.AUDIT_ARCH_AARCH64
+
+	// seccompX32SyscallBit is unused on arm64, which has a single syscall ABI
+	seccompX32SyscallBit = 0
+)
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StudentProgress is a learner's progress as their instructor sees it
+type StudentProgress struct {
+	User
+	Progress []UserProgress `json:"progress"`
+}
+
+// getStudents lists the logged-in instructor's students with their progress
+func getStudents(c *gin.Context) {
+	students, err := database.GetStudents(currentUserID(c))
+	if err != nil {
+		log.Printf("Error getting students: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get students"})
+		return
+	}
+
+	result := make([]StudentProgress, 0, len(students))
+	for _, student := range students {
+		progress, err := database.GetUserProgress(student.ID)
+		if err != nil {
+			log.Printf("Error getting progress of student %s: %v", student.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get students"})
+			return
+		}
+		if progress == nil {
+			progress = []UserProgress{}
+		}
+		result = append(result, StudentProgress{User: student, Progress: progress})
+	}
+	recordAudit(c, "students.view", "", map[string]any{"students": len(result)})
+
+	c.JSON(http.StatusOK, result)
+}
+
+// getStudentProgress returns the progress of one of the logged-in
+// instructor's students, or of any learner for users allowed to see all
+func getStudentProgress(c *gin.Context) {
+	studentID := c.Param("user_id")
+	if !canViewStudent(c, studentID) {
+		return
+	}
+
+	progress, err := database.GetUserProgress(studentID)
+	if err != nil {
+		log.Printf("Error getting user progress: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user progress"})
+		return
+	}
+	if len(progress) == 0 {
+		progress = []UserProgress{}
+	}
+	recordAudit(c, "progress.view", studentID, nil)
+
+	c.JSON(http.StatusOK, progress)
+}
+
+// getStudentHistory lists the executions of a student, with the query
+// parameters of the user's own history
+func getStudentHistory(c *gin.Context) {
+	studentID := c.Param("user_id")
+	if !canViewStudent(c, studentID) {
+		return
+	}
+
+	executions, ok := executionHistory(c, studentID)
+	if !ok {
+		return
+	}
+	recordAudit(c, "history.view", studentID, map[string]any{"executions": len(executions)})
+
+	c.JSON(http.StatusOK, executions)
+}
+
+// getStudentExecution loads one of a student's executions with its code
+func getStudentExecution(c *gin.Context) {
+	studentID := c.Param("user_id")
+	if !canViewStudent(c, studentID) {
+		return
+	}
+
+	execution, ok := executionByID(c, studentID)
+	if !ok {
+		return
+	}
+	recordAudit(c, "execution.view", studentID, map[string]any{"execution_id": execution.ID})
+
+	c.JSON(http.StatusOK, execution)
+}
+
+// canViewStudent reports whether the logged-in user may see a learner: one
+// of their students, or anyone for users allowed to see all progress.
+// Otherwise it answers the request and records the denied access.
+func canViewStudent(c *gin.Context, studentID string) bool {
+	role, err := currentRole(c)
+	allowed := err == nil && role.Can(PermViewAllProgress)
+	if err == nil && !allowed {
+		allowed, err = database.IsStudent(currentUserID(c), studentID)
+	}
+	if err != nil {
+		log.Printf("Error checking student %s: %v", studentID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check student"})
+		return false
+	}
+	if !allowed {
+		recordAudit(c, "access.denied", c.Request.Method+" "+c.FullPath(), map[string]any{"student_id": studentID})
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not one of your students"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestStudentHistory(t *testing.T) {
+	student, _ := newTestUser(t, RoleLearner)
+	instructor, instructorToken := newTestUser(t, RoleInstructor)
+	_, otherInstructorToken := newTestUser(t, RoleInstructor)
+	_, adminToken := newTestUser(t, RoleAdmin)
+	_, learnerToken := newTestUser(t, RoleLearner)
+	if err := database.AddStudent(instructor.ID, student.ID); err != nil {
+		t.Fatal(err)
+	}
+	executionID := recordExecution(&Execution{UserID: student.ID, LessonID: 1, Kind: "run", Code: "package main", CreatedAt: "2026-01-02T03:04:05Z"})
+	if executionID == 0 {
+		t.Fatal("execution was not recorded")
+	}
+
+	historyPath := "/api/students/" + student.ID + "/history"
+	executionPath := fmt.Sprintf("%s/%d", historyPath, executionID)
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantStatus int
+		wantAction string
+	}{
+		{"instructor lists a student's history", historyPath, instructorToken, http.StatusOK, "history.view"},
+		{"instructor loads a student's execution", executionPath, instructorToken, http.StatusOK, "execution.view"},
+		{"admin lists any learner's history", historyPath, adminToken, http.StatusOK, "history.view"},
+		{"other instructor is refused", historyPath, otherInstructorToken, http.StatusForbidden, "access.denied"},
+		{"other instructor cannot load an execution", executionPath, otherInstructorToken, http.StatusForbidden, "access.denied"},
+		{"learner lacks the permission", historyPath, learnerToken, http.StatusForbidden, "access.denied"},
+		{"anonymous request", historyPath, "", http.StatusUnauthorized, ""},
+		{"unknown execution", historyPath + "/999999", instructorToken, http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := database.GetAuditLog("", 0, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := serve(t, http.MethodGet, tt.path, nil, tt.token, nil)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+
+			after, err := database.GetAuditLog("", 0, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var action string
+			if len(after) > 0 && (len(before) == 0 || after[0].ID != before[0].ID) {
+				action = after[0].Action
+			}
+			if action != tt.wantAction {
+				t.Errorf("audit action %q, want %q", action, tt.wantAction)
+			}
+		})
+	}
+}
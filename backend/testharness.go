@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TestResult represents the outcome of a single hidden lesson test
+type TestResult struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// testEvent mirrors the events printed by go test -json (see go doc test2json)
+type testEvent struct {
+	Action string `json:"Action"`
+	Test   string `json:"Test"`
+	Output string `json:"Output"`
+}
+
+// parseTestEvents turns a go test -json stream into per-test results and the
+// output that did not belong to any test. passed reports whether the last
+// result of the package as a whole was a pass.
+func parseTestEvents(stream []byte) (results []TestResult, output string, passed bool) {
+	results = []TestResult{}
+	index := map[string]int{}
+	messages := map[string]*strings.Builder{}
+	var out strings.Builder
+
+	scanner := bufio.NewScanner(bytes.NewReader(stream))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event testEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			// Not an event, e.g. a build failure printed by an older toolchain
+			out.WriteString(scanner.Text() + "\n")
+			continue
+		}
+
+		if event.Test == "" {
+			switch event.Action {
+			case "output", "build-output":
+				out.WriteString(event.Output)
+			case "pass", "fail", "skip":
+				passed = event.Action == "pass"
+			}
+			continue
+		}
+
+		switch event.Action {
+		case "run":
+			index[event.Test] = len(results)
+			results = append(results, TestResult{Name: event.Test})
+			messages[event.Test] = &strings.Builder{}
+		case "output":
+			if msg, ok := messages[event.Test]; ok && !isTestFrameLine(event.Output) {
+				msg.WriteString(strings.TrimSpace(event.Output) + "\n")
+			}
+		case "pass", "fail", "skip":
+			i, ok := index[event.Test]
+			if !ok {
+				continue
+			}
+			results[i].Passed = event.Action != "fail"
+			if event.Action == "fail" {
+				results[i].Message = strings.TrimSpace(messages[event.Test].String())
+			}
+		}
+	}
+
+	return results, out.String(), passed
+}
+
+// hiddenTestNames returns the names of the top-level tests declared in the
+// hidden test code of a lesson
+func hiddenTestNames(testCode string) ([]string, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "main_test.go", testCode, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse lesson tests: %v", err)
+	}
+
+	var names []string
+	for _, decl := range file.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && isTestName(fn.Name.Name) {
+			names = append(names, fn.Name.Name)
+		}
+	}
+	return names, nil
+}
+
+// isTestName reports whether go test runs a function of that name as a test:
+// Test followed by nothing or by anything but a lower-case letter, other than
+// TestMain
+func isTestName(name string) bool {
+	if !strings.HasPrefix(name, "Test") || name == "TestMain" {
+		return false
+	}
+	if len(name) == len("Test") {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(name[len("Test"):])
+	return !unicode.IsLower(r)
+}
+
+// checkHiddenTests describes why a test run does not prove that the hidden
+// tests passed, or returns "" when it does. Every expected test must be
+// reported exactly once and pass, and nothing but those tests and their
+// subtests may be reported. Learner code runs in the same binary and can
+// print test2json frames of its own, so the results alone are not trusted.
+func checkHiddenTests(expected []string, results []TestResult) string {
+	want := make(map[string]bool, len(expected))
+	for _, name := range expected {
+		want[name] = true
+	}
+
+	seen := map[string]int{}
+	for _, result := range results {
+		parent, _, _ := strings.Cut(result.Name, "/")
+		if !want[parent] {
+			return fmt.Sprintf("Unexpected test %s was reported", result.Name)
+		}
+		if parent == result.Name {
+			seen[result.Name]++
+			if seen[result.Name] > 1 {
+				return fmt.Sprintf("Test %s was reported more than once", result.Name)
+			}
+		}
+		if !result.Passed {
+			return fmt.Sprintf("Test %s failed", result.Name)
+		}
+	}
+
+	for _, name := range expected {
+		if seen[name] == 0 {
+			return fmt.Sprintf("Test %s did not run", name)
+		}
+	}
+	return ""
+}
+
+// isTestFrameLine reports whether a line is go test bookkeeping rather than
+// output written by the test itself
+func isTestFrameLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	for _, prefix := range []string{"=== RUN", "=== PAUSE", "=== CONT", "--- PASS", "--- FAIL", "--- SKIP"} {
+		if strings.HasPrefix(trimmed, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// convertTestOutput turns the output of a test binary run with
+// -test.v=test2json into a go test -json stream, using the test2json of the
+// release the binary was built with
+func convertTestOutput(toolchain Toolchain, output string) ([]byte, error) {
+	convert := exec.Command(toolchain.goCommand(), "tool", "test2json", "-t", "-p", "sandbox")
+	convert.Stdin = strings.NewReader(output)
+	stream, err := convert.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert test output: %v", err)
+	}
+	return stream, nil
+}
+
+// buildTestResponse assembles the response for a go test -json run of an
+// already compiled test binary
+func buildTestResponse(ctx context.Context, stream []byte, stderr string, runErr error, duration time.Duration) *CodeExecutionResponse {
+	tests, output, passed := parseTestEvents(stream)
+
+	response := &CodeExecutionResponse{
+		Output: output + stderr,
+		Stdout: output,
+		Stderr: stderr,
+		Tests:  tests,
+	}
+	response.setRunResult(ctx, runErr, duration)
+	if ctx.Err() == context.DeadlineExceeded {
+		return response
+	}
+
+	failed := 0
+	for _, test := range tests {
+		if !test.Passed {
+			failed++
+		}
+	}
+
+	switch {
+	case failed > 0:
+		response.Error = fmt.Sprintf("%d of %d tests failed", failed, len(tests))
+	case runErr != nil:
+		// The test binary failed outside of any test, e.g. a panic in init,
+		// and setRunResult already described how it exited
+	case len(tests) == 0:
+		response.Error = "No tests were run"
+	case !passed:
+		response.Error = "The test run did not report a result"
+	}
+
+	return response
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// events joins go test -json lines into a stream
+func events(lines ...string) []byte {
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func TestParseTestEvents(t *testing.T) {
+	tests := []struct {
+		name       string
+		stream     []byte
+		wantTests  []TestResult
+		wantOutput string
+		wantPassed bool
+	}{
+		{
+			name:      "empty stream",
+			stream:    nil,
+			wantTests: []TestResult{},
+		},
+		{
+			name: "passing and failing tests",
+			stream: events(
+				`{"Action":"run","Test":"TestAdd"}`,
+				`{"Action":"output","Test":"TestAdd","Output":"=== RUN   TestAdd\n"}`,
+				`{"Action":"output","Test":"TestAdd","Output":"--- PASS: TestAdd (0.00s)\n"}`,
+				`{"Action":"pass","Test":"TestAdd"}`,
+				`{"Action":"run","Test":"TestSub"}`,
+				`{"Action":"output","Test":"TestSub","Output":"=== RUN   TestSub\n"}`,
+				`{"Action":"output","Test":"TestSub","Output":"    main_test.go:9: Sub(3, 1) = 4, want 2\n"}`,
+				`{"Action":"output","Test":"TestSub","Output":"--- FAIL: TestSub (0.00s)\n"}`,
+				`{"Action":"fail","Test":"TestSub"}`,
+				`{"Action":"output","Output":"FAIL\n"}`,
+				`{"Action":"fail"}`,
+			),
+			wantTests: []TestResult{
+				{Name: "TestAdd", Passed: true},
+				{Name: "TestSub", Message: "main_test.go:9: Sub(3, 1) = 4, want 2"},
+			},
+			wantOutput: "FAIL\n",
+		},
+		{
+			name: "package pass",
+			stream: events(
+				`{"Action":"run","Test":"TestAdd"}`,
+				`{"Action":"pass","Test":"TestAdd"}`,
+				`{"Action":"output","Output":"PASS\n"}`,
+				`{"Action":"pass"}`,
+			),
+			wantTests:  []TestResult{{Name: "TestAdd", Passed: true}},
+			wantOutput: "PASS\n",
+			wantPassed: true,
+		},
+		{
+			name: "package result without a pass",
+			stream: events(
+				`{"Action":"pass"}`,
+				`{"Action":"fail"}`,
+			),
+			wantTests: []TestResult{},
+		},
+		{
+			name: "skipped test passes",
+			stream: events(
+				`{"Action":"run","Test":"TestLater"}`,
+				`{"Action":"skip","Test":"TestLater"}`,
+			),
+			wantTests: []TestResult{{Name: "TestLater", Passed: true}},
+		},
+		{
+			name: "output of a passing test is not a message",
+			stream: events(
+				`{"Action":"run","Test":"TestLog"}`,
+				`{"Action":"output","Test":"TestLog","Output":"    main_test.go:4: hello\n"}`,
+				`{"Action":"pass","Test":"TestLog"}`,
+			),
+			wantTests: []TestResult{{Name: "TestLog", Passed: true}},
+		},
+		{
+			name: "build failure outside any test",
+			stream: events(
+				`{"Action":"build-output","Output":"./main.go:5:2: undefined: x\n"}`,
+				`# sandbox`,
+			),
+			wantTests:  []TestResult{},
+			wantOutput: "./main.go:5:2: undefined: x\n# sandbox\n",
+		},
+		{
+			name: "result of a test that never ran is ignored",
+			stream: events(
+				`{"Action":"fail","Test":"TestGhost"}`,
+			),
+			wantTests: []TestResult{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, output, passed := parseTestEvents(tt.stream)
+			if !reflect.DeepEqual(results, tt.wantTests) {
+				t.Errorf("tests = %+v, want %+v", results, tt.wantTests)
+			}
+			if output != tt.wantOutput {
+				t.Errorf("output = %q, want %q", output, tt.wantOutput)
+			}
+			if passed != tt.wantPassed {
+				t.Errorf("passed = %v, want %v", passed, tt.wantPassed)
+			}
+		})
+	}
+}
+
+func TestBuildTestResponse(t *testing.T) {
+	passed := events(`{"Action":"run","Test":"TestA"}`, `{"Action":"pass","Test":"TestA"}`, `{"Action":"pass"}`)
+	unfinished := events(`{"Action":"run","Test":"TestA"}`, `{"Action":"pass","Test":"TestA"}`)
+	failed := events(`{"Action":"run","Test":"TestA"}`, `{"Action":"fail","Test":"TestA"}`,
+		`{"Action":"run","Test":"TestB"}`, `{"Action":"pass","Test":"TestB"}`)
+
+	tests := []struct {
+		name      string
+		stream    []byte
+		runErr    error
+		wantError string
+	}{
+		{"all tests pass", passed, nil, ""},
+		{"counts failed tests", failed, errors.New("exit status 1"), "1 of 2 tests failed"},
+		{"no tests ran", events(), nil, "No tests were run"},
+		{"no package result", unfinished, nil, "The test run did not report a result"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := buildTestResponse(context.Background(), tt.stream, "", tt.runErr, 0)
+			if response.Error != tt.wantError {
+				t.Errorf("error = %q, want %q", response.Error, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestHiddenTestNames(t *testing.T) {
+	const code = `package main
+
+import "testing"
+
+func TestMain(m *testing.M) {}
+
+func TestAdd(t *testing.T) {}
+
+func Testify(t *testing.T) {}
+
+func Test(t *testing.T) {}
+
+func Test_sub(t *testing.T) {}
+
+func helper(t *testing.T) {}
+
+type suite struct{}
+
+func (suite) TestMethod(t *testing.T) {}
+`
+	names, err := hiddenTestNames(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"TestAdd", "Test", "Test_sub"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+
+	if _, err := hiddenTestNames("package main\n\nfunc TestAdd("); err == nil {
+		t.Error("code that does not parse was accepted")
+	}
+}
+
+func TestCheckHiddenTests(t *testing.T) {
+	expected := []string{"TestAdd", "TestSub"}
+
+	tests := []struct {
+		name    string
+		results []TestResult
+		want    string
+	}{
+		{
+			name:    "every test passed once",
+			results: []TestResult{{Name: "TestAdd", Passed: true}, {Name: "TestSub", Passed: true}},
+		},
+		{
+			name: "subtests of expected tests",
+			results: []TestResult{
+				{Name: "TestAdd", Passed: true},
+				{Name: "TestAdd/negative", Passed: true},
+				{Name: "TestSub", Passed: true},
+			},
+		},
+		{
+			name:    "missing test",
+			results: []TestResult{{Name: "TestAdd", Passed: true}},
+			want:    "Test TestSub did not run",
+		},
+		{
+			name:    "no results",
+			results: []TestResult{},
+			want:    "Test TestAdd did not run",
+		},
+		{
+			name: "unexpected test",
+			results: []TestResult{
+				{Name: "TestAdd", Passed: true},
+				{Name: "TestSub", Passed: true},
+				{Name: "TestHidden", Passed: true},
+			},
+			want: "Unexpected test TestHidden was reported",
+		},
+		{
+			name: "subtest of an unexpected test",
+			results: []TestResult{
+				{Name: "TestAdd", Passed: true},
+				{Name: "TestSub", Passed: true},
+				{Name: "TestHidden/x", Passed: true},
+			},
+			want: "Unexpected test TestHidden/x was reported",
+		},
+		{
+			name: "test reported twice",
+			results: []TestResult{
+				{Name: "TestAdd", Passed: true},
+				{Name: "TestAdd", Passed: true},
+				{Name: "TestSub", Passed: true},
+			},
+			want: "Test TestAdd was reported more than once",
+		},
+		{
+			name:    "failed test",
+			results: []TestResult{{Name: "TestAdd", Passed: true}, {Name: "TestSub"}},
+			want:    "Test TestSub failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkHiddenTests(expected, tt.results); got != tt.want {
+				t.Errorf("checkHiddenTests() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Toolchain is an installed Go release that programs can be built with
+type Toolchain struct {
+	// Version is the release as the go command names it, such as "go1.22.5"
+	Version string `json:"version"`
+
+	// GoRoot is the directory the release is installed in, on the host or
+	// inside the sandbox image
+	GoRoot string `json:"goroot"`
+}
+
+// goCommand returns the path of the release's go command
+func (t Toolchain) goCommand() string {
+	return filepath.Join(t.GoRoot, "bin", "go")
+}
+
+// gofmtCommand returns the path of the release's gofmt
+func (t Toolchain) gofmtCommand() string {
+	return filepath.Join(t.GoRoot, "bin", "gofmt")
+}
+
+// languageVersion returns the language version of the release, such as
+// "1.22", for the go directive of a go.mod
+func (t Toolchain) languageVersion() string {
+	parts := goVersionParts(t.Version)
+	return fmt.Sprintf("%d.%d", parts[0], parts[1])
+}
+
+// ToolchainConfig lists the Go releases the host backends build with, next
+// to the go command on the PATH. The docker backend uses the releases
+// installed in its image instead.
+type ToolchainConfig struct {
+	// GoRoots are the directories further releases are installed in
+	GoRoots []string
+
+	// Default is the version used when a request names none; empty means
+	// the go command on the PATH, or the image's own release
+	Default string
+}
+
+// Toolchains is the set of installed releases
+type Toolchains struct {
+	// list is sorted newest first
+	list             []Toolchain
+	defaultToolchain Toolchain
+}
+
+// newToolchains creates the set of the given releases. Releases found twice
+// are kept once. defaultVersion selects the default among them, which is
+// otherwise the first release given.
+func newToolchains(list []Toolchain, defaultVersion string) (*Toolchains, error) {
+	if len(list) == 0 {
+		return nil, fmt.Errorf("no Go toolchain installed")
+	}
+
+	t := &Toolchains{defaultToolchain: list[0]}
+	seen := make(map[string]bool)
+	for _, toolchain := range list {
+		if !seen[toolchain.Version] {
+			seen[toolchain.Version] = true
+			t.list = append(t.list, toolchain)
+		}
+	}
+	sort.SliceStable(t.list, func(i, j int) bool {
+		return compareGoVersions(t.list[i].Version, t.list[j].Version) > 0
+	})
+
+	if defaultVersion != "" {
+		toolchain, err := t.Select(defaultVersion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default Go version: %v", err)
+		}
+		t.defaultToolchain = toolchain
+	}
+	return t, nil
+}
+
+// Default returns the release used when a request names none
+func (t *Toolchains) Default() Toolchain {
+	return t.defaultToolchain
+}
+
+// Versions lists the installed releases, newest first
+func (t *Toolchains) Versions() []string {
+	versions := make([]string, 0, len(t.list))
+	for _, toolchain := range t.list {
+		versions = append(versions, toolchain.Version)
+	}
+	return versions
+}
+
+// Select returns the release a request asks for. A language version such
+// as "1.22" selects the newest installed 1.22 release, a full version such
+// as "1.22.5" that exact release. The "go" prefix is optional, and an empty
+// version selects the default.
+func (t *Toolchains) Select(requested string) (Toolchain, error) {
+	if requested == "" {
+		return t.defaultToolchain, nil
+	}
+
+	version := "go" + strings.TrimPrefix(requested, "go")
+	language := languageVersionRegex.MatchString(version)
+	for _, toolchain := range t.list {
+		if toolchain.Version == version {
+			return toolchain, nil
+		}
+		if language && "go"+toolchain.languageVersion() == version {
+			return toolchain, nil
+		}
+	}
+	return Toolchain{}, fmt.Errorf("Go %s is not installed, available versions are %s",
+		strings.TrimPrefix(version, "go"), strings.Join(t.Versions(), ", "))
+}
+
+// languageVersionRegex matches a language version such as "go1.22"
+var languageVersionRegex = regexp.MustCompile(`^go\d+\.\d+$`)
+
+// goVersionParts splits a release name such as "go1.22.5" or "go1.23rc1"
+// into its major, minor and patch numbers. A pre-release gets a patch
+// number of -1, which sorts it before the release itself.
+func goVersionParts(version string) [3]int {
+	var parts [3]int
+	fields := strings.SplitN(strings.TrimPrefix(version, "go"), ".", 3)
+	for i, field := range fields {
+		digits := strings.IndexFunc(field, func(r rune) bool { return r < '0' || r > '9' })
+		if digits >= 0 {
+			field = field[:digits]
+			if i < 2 {
+				parts[2] = -1
+			}
+		}
+		parts[i], _ = strconv.Atoi(field)
+	}
+	return parts
+}
+
+// compareGoVersions orders two release names like strings.Compare
+func compareGoVersions(a, b string) int {
+	pa, pb := goVersionParts(a), goVersionParts(b)
+	for i := range pa {
+		if pa[i] != pb[i] {
+			if pa[i] < pb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// discoverHostToolchains asks the go command on the PATH and the one in
+// every configured GOROOT which release it is
+func discoverHostToolchains(ctx context.Context, cfg ToolchainConfig) (*Toolchains, error) {
+	var list []Toolchain
+	if out, err := exec.CommandContext(ctx, "go", "env", "GOROOT").Output(); err == nil {
+		cfg.GoRoots = append([]string{strings.TrimSpace(string(out))}, cfg.GoRoots...)
+	}
+
+	for _, root := range cfg.GoRoots {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GOROOT %s: %v", root, err)
+		}
+		toolchain := Toolchain{GoRoot: root}
+		cmd := exec.CommandContext(ctx, toolchain.goCommand(), "env", "GOVERSION")
+		cmd.Env = append(os.Environ(), "GOROOT="+root, "GOTOOLCHAIN=local")
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("cannot determine Go version in %s: %v", root, err)
+		}
+		toolchain.Version = strings.TrimSpace(string(out))
+		list = append(list, toolchain)
+	}
+	return newToolchains(list, cfg.Default)
+}
+
+// resolveGoVersion selects the release a request asks for and names it
+// exactly in the request, so that every execution of a submission uses the
+// same release
+func resolveGoVersion(ctx context.Context, req *CodeExecutionRequest) error {
+	toolchains, err := executor.Toolchains(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot list Go versions: %v", err)
+	}
+	toolchain, err := toolchains.Select(req.GoVersion)
+	if err != nil {
+		return err
+	}
+	req.GoVersion = toolchain.Version
+	return nil
+}
+
+// withToolchain returns a copy of the request naming the release it is built
+// with, whose language version a default go.mod declares
+func (r *CodeExecutionRequest) withToolchain(toolchain Toolchain) *CodeExecutionRequest {
+	copied := *r
+	copied.GoVersion = toolchain.Version
+	return &copied
+}
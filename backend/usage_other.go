@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// processUsage is not available on this platform
+func processUsage(state *os.ProcessState) resourceUsage {
+	return resourceUsage{}
+}
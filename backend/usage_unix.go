@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// processUsage reads the peak memory and CPU time of an exited process
+func processUsage(state *os.ProcessState) resourceUsage {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return resourceUsage{}
+	}
+
+	// Darwin reports the peak resident set in bytes, the others in KiB
+	peak := int64(rusage.Maxrss)
+	if runtime.GOOS != "darwin" && runtime.GOOS != "ios" {
+		peak <<= 10
+	}
+	return resourceUsage{
+		peakMemory: peak,
+		cpuTime:    time.Duration(rusage.Utime.Nano() + rusage.Stime.Nano()),
+	}
+}
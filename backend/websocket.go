@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// wsMessage is a message sent by the client over the WebSocket channel.
+// Type "run" starts the program in Code and Files with Stdin as its first
+// input, built with GoVersion and recorded for LessonID, "stdin" types Data
+// into the running program, "eof" closes its stdin and "cancel" stops it.
+type wsMessage struct {
+	Type  string            `json:"type"`
+	Code  string            `json:"code,omitempty"`
+	Files map[string]string `json:"files,omitempty"`
+	Stdin string            `json:"stdin,omitempty"`
+	Data  string            `json:"data,omitempty"`
+
+	GoVersion string `json:"go_version,omitempty"`
+	LessonID  int    `json:"lesson_id,omitempty"`
+}
+
+// wsEvent is a message sent to the client. "queued" reports the run's
+// position while it waits for a worker and "started" the end of the wait.
+// "output" carries a chunk of the program's output with the time it was
+// written in milliseconds since the program started, "exit" the final result
+// and "error" a failed request, with RetryAfter seconds when the server was
+// too busy to queue it.
+type wsEvent struct {
+	Type       string                 `json:"type"`
+	Position   int                    `json:"position,omitempty"`
+	Stream     string                 `json:"stream,omitempty"`
+	Data       string                 `json:"data,omitempty"`
+	TimeMs     int64                  `json:"time_ms,omitempty"`
+	Result     *CodeExecutionResponse `json:"result,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	RetryAfter int                    `json:"retry_after,omitempty"`
+}
+
+// wsSession tracks the single run a connection may have in flight
+type wsSession struct {
+	conn *websocket.Conn
+
+	// user counts the runs against the scheduler's per-user limit, and
+	// userID is the logged-in user whose history records them
+	user   string
+	userID string
+
+	// writeMu admits one writer at a time; broken is set once a write
+	// failed and the connection was closed
+	writeMu sync.Mutex
+	broken  bool
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	stdin  *inputBuffer
+}
+
+// handleWebSocket streams program output to the client while it runs
+func handleWebSocket(c *gin.Context) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(maxRequestBytes)
+
+	// A client that answers neither pings nor sends anything is gone, even
+	// when its connection was never closed
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	session := &wsSession{conn: conn, user: requestUser(c), userID: currentUserID(c)}
+	// A closed connection stops whatever is still running
+	defer session.stop()
+
+	done := make(chan struct{})
+	defer close(done)
+	go session.keepAlive(done)
+
+	for {
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("WebSocket read error: %v", err)
+			}
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		switch msg.Type {
+		case "run":
+			session.run(&CodeExecutionRequest{
+				Code: msg.Code, Files: msg.Files, Stdin: msg.Stdin, GoVersion: msg.GoVersion,
+				UserID: session.userID, LessonID: msg.LessonID,
+			})
+		case "stdin":
+			session.input(msg.Data)
+		case "eof":
+			session.closeInput()
+		case "cancel":
+			session.stop()
+		default:
+			session.send(wsEvent{Type: "error", Error: "unknown message type " + msg.Type})
+		}
+	}
+}
+
+// run starts req unless another run is still in progress
+func (s *wsSession) run(req *CodeExecutionRequest) {
+	if _, err := req.workspaceFiles(); err != nil {
+		s.send(wsEvent{Type: "error", Error: err.Error()})
+		return
+	}
+	if err := resolveGoVersion(context.Background(), req); err != nil {
+		s.send(wsEvent{Type: "error", Error: err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.mu.Unlock()
+		s.send(wsEvent{Type: "error", Error: "a program is already running"})
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stdin := newInputBuffer()
+	s.cancel = cancel
+	s.stdin = stdin
+	s.mu.Unlock()
+
+	go func() {
+		// The session must be free again by the time the client hears the
+		// program ended, or a run started right away would be refused
+		event := s.execute(ctx, req, stdin)
+		s.finish(cancel, stdin)
+		s.send(event)
+	}()
+}
+
+// execute waits for a run slot, runs req and returns the event that ends
+// the run: its exit or an error
+func (s *wsSession) execute(ctx context.Context, req *CodeExecutionRequest, stdin *inputBuffer) wsEvent {
+	release, err := scheduler.Acquire(ctx, s.user, func(position int) {
+		s.send(wsEvent{Type: "queued", Position: position})
+	})
+	var busy *BusyError
+	if err != nil {
+		if errors.As(err, &busy) {
+			return wsEvent{Type: "error", Error: busy.Message, RetryAfter: busy.RetryAfterSeconds()}
+		}
+		return wsEvent{Type: "exit", Result: &CodeExecutionResponse{Error: "Execution cancelled"}}
+	}
+	defer release()
+	s.send(wsEvent{Type: "started"})
+
+	streams := &ExecutionStreams{
+		Output: func(chunk OutputChunk) {
+			s.send(wsEvent{Type: "output", Stream: chunk.Stream, Data: chunk.Data, TimeMs: chunk.TimeMs})
+		},
+		Input: stdin,
+	}
+	start := time.Now()
+	response, err := executor.Execute(ctx, req, streams)
+	if errors.As(err, &busy) {
+		return wsEvent{Type: "error", Error: busy.Message, RetryAfter: busy.RetryAfterSeconds()}
+	}
+	if err != nil {
+		return wsEvent{Type: "error", Error: err.Error()}
+	}
+	if ctx.Err() == context.Canceled {
+		response.Error = "Execution cancelled"
+	}
+	response.ExecutionID = recordRun(req, response, time.Since(start))
+	return wsEvent{Type: "exit", Result: response}
+}
+
+// stop cancels the running program, if any
+func (s *wsSession) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// input types data into the running program
+func (s *wsSession) input(data string) {
+	s.mu.Lock()
+	stdin := s.stdin
+	s.mu.Unlock()
+
+	if stdin == nil {
+		s.send(wsEvent{Type: "error", Error: "no program is running"})
+		return
+	}
+	if err := stdin.Write(data); err != nil {
+		s.send(wsEvent{Type: "error", Error: err.Error()})
+	}
+}
+
+// closeInput ends the running program's stdin
+func (s *wsSession) closeInput() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stdin != nil {
+		s.stdin.Close()
+	}
+}
+
+// finish stops the run and frees the session for the next one
+func (s *wsSession) finish(cancel context.CancelFunc, stdin *inputBuffer) {
+	cancel()
+	stdin.Close()
+
+	s.mu.Lock()
+	s.cancel = nil
+	s.stdin = nil
+	s.mu.Unlock()
+}
+
+const (
+	// wsWriteWait is how long a client may take to accept one event
+	wsWriteWait = 10 * time.Second
+
+	// wsPongWait is how long a client may stay silent before the session
+	// ends; it is pinged every wsPingPeriod to give it something to answer
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+)
+
+// keepAlive pings the client until done is closed or a ping fails
+func (s *wsSession) keepAlive(done <-chan struct{}) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			// WriteControl may be called next to the writer holding writeMu
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// send writes one event; gorilla connections allow a single writer at a
+// time. A client that does not take the event in time is disconnected, so
+// that it cannot stall the program's output and hold its run slot; closing
+// the connection ends the read loop, which stops the run.
+func (s *wsSession) send(event wsEvent) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if s.broken {
+		return
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	if err := s.conn.WriteJSON(event); err != nil {
+		log.Printf("WebSocket write error: %v", err)
+		s.broken = true
+		s.conn.Close()
+	}
+}
+
+// maxPendingInput bounds the input typed ahead of a program that is not
+// reading it
+const maxPendingInput = 64 * 1024
+
+// inputBuffer holds typed input until the program reads it. Writes never
+// block, so a program that does not read cannot stall the connection.
+type inputBuffer struct {
+	mu     sync.Mutex
+	ready  *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newInputBuffer() *inputBuffer {
+	b := &inputBuffer{}
+	b.ready = sync.NewCond(&b.mu)
+	return b
+}
+
+// Write queues data for the program
+func (b *inputBuffer) Write(data string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return errors.New("stdin is closed")
+	}
+	if b.buf.Len()+len(data) > maxPendingInput {
+		return errors.New("too much input waiting to be read")
+	}
+	b.buf.WriteString(data)
+	b.ready.Broadcast()
+	return nil
+}
+
+// Close marks the end of the input
+func (b *inputBuffer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	b.ready.Broadcast()
+}
+
+// Read implements io.Reader, blocking until input arrives or is closed
+func (b *inputBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.buf.Len() == 0 && !b.closed {
+		b.ready.Wait()
+	}
+	if b.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return b.buf.Read(p)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// dialWebSocket connects to the WebSocket endpoint of a test server
+func dialWebSocket(t *testing.T) *websocket.Conn {
+	t.Helper()
+	router, err := newRouter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readUntil reads events until one of the given type, failing on errors
+func readUntil(t *testing.T, conn *websocket.Conn, eventType string) wsEvent {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var event wsEvent
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Type == "error" {
+			t.Fatalf("got error event %q while waiting for %q", event.Error, eventType)
+		}
+		if event.Type == eventType {
+			return event
+		}
+	}
+}
+
+func TestWebSocketRunsAgainRightAfterExit(t *testing.T) {
+	useFakeExecutor(t, func(req *CodeExecutionRequest) (*CodeExecutionResponse, error) {
+		return &CodeExecutionResponse{Output: req.Code}, nil
+	})
+	conn := dialWebSocket(t)
+
+	// Each run is sent as soon as the previous one is reported, which the
+	// session must already be free for
+	for _, code := range []string{"first", "second", "third"} {
+		if err := conn.WriteJSON(wsMessage{Type: "run", Code: code}); err != nil {
+			t.Fatal(err)
+		}
+		exit := readUntil(t, conn, "exit")
+		if exit.Result == nil || exit.Result.Output != code {
+			t.Fatalf("exit = %+v, want the output of %q", exit.Result, code)
+		}
+	}
+}
+
+func TestWebSocketRejectsOversizedMessage(t *testing.T) {
+	fake := useFakeExecutor(t, nil)
+	conn := dialWebSocket(t)
+
+	code := "package main\n\n//" + strings.Repeat("x", maxRequestBytes)
+	if err := conn.WriteJSON(wsMessage{Type: "run", Code: code}); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event wsEvent
+	err := conn.ReadJSON(&event)
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("read = %+v, %v, want the connection closed for a message too big", event, err)
+	}
+	if len(fake.Requests()) > 0 {
+		t.Error("backend ran the oversized program")
+	}
+}
+
+func TestWebSocketTicket(t *testing.T) {
+	router, err := newRouter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/ws?ticket="
+
+	_, session := newTestUser(t, RoleLearner)
+	if w := serve(t, "POST", "/api/ws/ticket", nil, "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous ticket request = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	var issued struct {
+		Ticket string `json:"ticket"`
+	}
+	if w := serve(t, "POST", "/api/ws/ticket", nil, session, &issued); w.Code != http.StatusOK {
+		t.Fatalf("ticket request = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+issued.Ticket, nil)
+	if err != nil {
+		t.Fatalf("dial with a new ticket: %v", err)
+	}
+	conn.Close()
+
+	for name, ticket := range map[string]string{"used ticket": issued.Ticket, "session token": session} {
+		_, resp, err := websocket.DefaultDialer.Dial(url+ticket, nil)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("dial with a %s = %v, want %d", name, err, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestLogRequestLeavesOutQuery(t *testing.T) {
+	line := logRequest(gin.LogFormatterParams{Method: "GET", Path: "/api/ws?ticket=secret", StatusCode: 101})
+	if strings.Contains(line, "secret") || !strings.Contains(line, `"/api/ws"`) {
+		t.Errorf("logRequest() = %q, want the path without its query", line)
+	}
+}
@@ -1,69 +1,362 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
+// The backend copies the request envelope, and for the run phase the
+// compiled program, to /app/input and collects the binary of the build
+// phase from outputFile. The source is not sent on stdin, which belongs to
+// the learner's program.
+const (
+	requestFile = "/app/input/request.json"
+	programFile = "/app/input/prog"
+	outputFile  = "/app/output/prog"
+)
+
+// sdkDir holds the Go releases installed next to the image's own, one
+// GOROOT per directory
+const sdkDir = "/usr/local/sdk"
+
+// input is the envelope the backend copies to requestFile
+type input struct {
+	Phase  string            `json:"phase"` // "build", "run", "check" or "toolchains"
+	Files  map[string]string `json:"files"` // module files keyed by slash-separated path
+	Tests  bool              `json:"tests,omitempty"`
+	GoRoot string            `json:"goroot,omitempty"` // release to use; empty means the go on the PATH
+	Limits limits            `json:"limits"`
+}
+
+// goCommand returns the go command of the requested release and the
+// environment to run it in
+func (in input) goCommand() (string, []string) {
+	if in.GoRoot == "" {
+		return "go", os.Environ()
+	}
+	return filepath.Join(in.GoRoot, "bin", "go"), append(os.Environ(), "GOROOT="+in.GoRoot)
+}
+
+// toolchain is an installed Go release
+type toolchain struct {
+	Version string `json:"version"`
+	GoRoot  string `json:"goroot"`
+}
+
+// limits are applied to the program of the run phase; the container's own
+// limits bound its memory and processes as a whole
+type limits struct {
+	MemoryMB    int `json:"memory_mb"`
+	CPUSeconds  int `json:"cpu_seconds"`
+	WallSeconds int `json:"wall_seconds"`
+	OutputBytes int `json:"output_bytes"`
+}
+
+// event is one JSON line reported back to the backend on stdout
+type event struct {
+	Event      string `json:"event"` // "compile", "output", "exit", "check" or "toolchains"
+	OK         bool   `json:"ok,omitempty"`
+	Stream     string `json:"stream,omitempty"`
+	Output     string `json:"output,omitempty"`
+	TimeMs     int64  `json:"time_ms,omitempty"` // when output was written since the program started
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	ExitCode   *int   `json:"exit_code,omitempty"`
+	Timeout    bool   `json:"timeout,omitempty"`
+	Error      string `json:"error,omitempty"`
+
+	// Resources used by the program, and whether a signal ended it
+	PeakMemoryBytes int64 `json:"peak_memory_bytes,omitempty"`
+	CPUTimeMs       int64 `json:"cpu_time_ms,omitempty"`
+	Killed          bool  `json:"killed,omitempty"`
+
+	// Format holds the output of gofmt -d for the check event
+	Format string `json:"format,omitempty"`
+
+	Toolchains []toolchain `json:"toolchains,omitempty"`
+}
+
+var (
+	eventsMu sync.Mutex
+	events   = json.NewEncoder(os.Stdout)
+)
+
+// emit prints one event; the program's stdout and stderr are copied by
+// separate goroutines
+func emit(e event) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	events.Encode(e)
+}
+
+// eventWriter turns every write of the program into a timestamped output
+// event
+type eventWriter struct {
+	stream string
+	start  time.Time
+}
+
+func (w eventWriter) Write(p []byte) (int, error) {
+	emit(event{Event: "output", Stream: w.stream, Output: string(p), TimeMs: time.Since(w.start).Milliseconds()})
+	return len(p), nil
+}
+
 func main() {
-	// Read Go code from stdin
-	scanner := bufio.NewScanner(os.Stdin)
-	var code strings.Builder
-	
-	for scanner.Scan() {
-		code.WriteString(scanner.Text() + "\n")
-	}
-	
-	if err := scanner.Err(); err != nil {
+	// Read the request envelope
+	data, err := ioutil.ReadFile(requestFile)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
 		os.Exit(1)
 	}
-	
-	// Create a temporary file for the Go code
-	tmpDir := "/app/code"
-	err := os.MkdirAll(tmpDir, 0755)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating temp directory: %v\n", err)
+	var in input
+	if err := json.Unmarshal(data, &in); err != nil {
+		fmt.Fprintf(os.Stderr, "Error decoding input: %v\n", err)
 		os.Exit(1)
 	}
-	
-	tmpFile := filepath.Join(tmpDir, "main.go")
-	err = ioutil.WriteFile(tmpFile, []byte(code.String()), 0644)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing temp file: %v\n", err)
+
+	switch in.Phase {
+	case "build":
+		build(in)
+	case "run":
+		run(in)
+	case "check":
+		check(in)
+	case "toolchains":
+		toolchains()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown phase %q\n", in.Phase)
 		os.Exit(1)
 	}
-	
-	// Execute the Go code with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+}
+
+// build compiles the module into outputFile. Nothing of the learner's code
+// is executed.
+func build(in input) {
+	tmpDir := writeModule(in.Files)
+	defer os.RemoveAll(tmpDir)
+
+	// -trimpath prints the learner's files in stack traces as paths within
+	// the module
+	args := []string{"build", "-trimpath", "-o", outputFile, "."}
+	if in.Tests {
+		args = []string{"test", "-c", "-trimpath", "-o", outputFile, "."}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
-	
-	cmd := exec.CommandContext(ctx, "go", "run", tmpFile)
+
+	goCommand, env := in.goCommand()
+	cmd := exec.CommandContext(ctx, goCommand, args...)
 	cmd.Dir = tmpDir
-	
-	// Capture both stdout and stderr
+	cmd.Env = append(env, "CGO_ENABLED=0")
+
+	start := time.Now()
 	output, err := cmd.CombinedOutput()
-	
+	emit(event{
+		Event:      "compile",
+		OK:         err == nil,
+		Output:     string(output),
+		DurationMs: time.Since(start).Milliseconds(),
+		Timeout:    ctx.Err() == context.DeadlineExceeded,
+	})
+}
+
+// check runs gofmt -d and go vet on the module without running any of it.
+// File names in the output are relative to the module root.
+func check(in input) {
+	tmpDir := writeModule(in.Files)
+	defer os.RemoveAll(tmpDir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	goCommand, env := in.goCommand()
+	var names []string
+	for name := range in.Files {
+		if strings.HasSuffix(name, ".go") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	gofmt := exec.CommandContext(ctx, filepath.Join(filepath.Dir(goCommand), "gofmt"), append([]string{"-d"}, names...)...)
+	gofmt.Dir = tmpDir
+	gofmt.Env = env
+	formatDiff, _ := gofmt.Output()
+
+	vet := exec.CommandContext(ctx, goCommand, "vet", "-json", "./...")
+	vet.Dir = tmpDir
+	vet.Env = append(env, "CGO_ENABLED=0")
+	output, err := vet.CombinedOutput()
+	emit(event{
+		Event:   "check",
+		OK:      err == nil,
+		Output:  strings.ReplaceAll(string(output), tmpDir+"/", ""),
+		Format:  string(formatDiff),
+		Timeout: ctx.Err() == context.DeadlineExceeded,
+	})
+}
+
+// writeModule lays out the files as a module in a new temporary directory
+func writeModule(files map[string]string) string {
+	tmpDir, err := ioutil.TempDir("", "code")
 	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			fmt.Fprintf(os.Stderr, "Execution timeout exceeded\n")
+		fmt.Fprintf(os.Stderr, "Error creating temp directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	for name, content := range files {
+		target := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating directory for %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		if err := ioutil.WriteFile(target, []byte(content), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", name, err)
+			os.Exit(1)
+		}
+	}
+	return tmpDir
+}
+
+// run executes the compiled program under the request's limits; tests are
+// reported in the go test -json format
+func run(in input) {
+	// The program inherits the helper's rlimits; zero leaves a limit off
+	rlimits := []struct {
+		resource int
+		value    uint64
+	}{
+		{syscall.RLIMIT_DATA, uint64(in.Limits.MemoryMB) << 20},
+		{syscall.RLIMIT_CPU, uint64(in.Limits.CPUSeconds)},
+	}
+	for _, limit := range rlimits {
+		if limit.value == 0 {
+			continue
+		}
+		rlimit := syscall.Rlimit{Cur: limit.value, Max: limit.value}
+		if err := syscall.Setrlimit(limit.resource, &rlimit); err != nil {
+			fmt.Fprintf(os.Stderr, "Error setting limits: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	timeout := time.Duration(in.Limits.WallSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, programFile)
+	if in.Tests {
+		goCommand, env := in.goCommand()
+		cmd = exec.CommandContext(ctx, goCommand, "tool", "test2json", "-t", "-p", "sandbox", programFile, "-test.v=test2json")
+		cmd.Env = env
+	}
+	cmd.Dir = os.TempDir()
+
+	// Program output is streamed as it is written and the backend stops
+	// the program when there is too much; test output is sent in one piece
+	// because the backend parses it as a whole, so it is capped here
+	start := time.Now()
+	combined := &cappedBuffer{limit: in.Limits.OutputBytes, stop: cancel}
+	if in.Tests {
+		cmd.Stdout = combined
+		cmd.Stderr = combined
+	} else {
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = eventWriter{stream: "stdout", start: start}
+		cmd.Stderr = eventWriter{stream: "stderr", start: start}
+	}
+
+	err := cmd.Run()
+	duration := time.Since(start)
+
+	if in.Tests {
+		emit(event{Event: "output", Stream: "stdout", Output: combined.buf.String()})
+	}
+
+	exit := event{
+		Event:      "exit",
+		DurationMs: duration.Milliseconds(),
+		Timeout:    ctx.Err() == context.DeadlineExceeded,
+	}
+	if state := cmd.ProcessState; state != nil {
+		if code := state.ExitCode(); code >= 0 {
+			exit.ExitCode = &code
 		} else {
-			fmt.Fprintf(os.Stderr, "Execution error: %v\n", err)
+			exit.Killed = true
 		}
-		fmt.Fprintf(os.Stderr, "Output: %s\n", output)
-		os.Exit(1)
+		if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+			exit.PeakMemoryBytes = rusage.Maxrss << 10
+			exit.CPUTimeMs = (rusage.Utime.Nano() + rusage.Stime.Nano()) / int64(time.Millisecond)
+		}
+	}
+	if err != nil {
+		exit.Error = err.Error()
+	}
+	emit(exit)
+}
+
+// toolchains reports the Go releases in the image: the one on the PATH and
+// those in sdkDir
+func toolchains() {
+	var roots []string
+	if out, err := exec.Command("go", "env", "GOROOT").Output(); err == nil {
+		roots = append(roots, strings.TrimSpace(string(out)))
+	}
+	entries, _ := os.ReadDir(sdkDir)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			roots = append(roots, filepath.Join(sdkDir, entry.Name()))
+		}
+	}
+
+	found := []toolchain{}
+	for _, root := range roots {
+		cmd := exec.Command(filepath.Join(root, "bin", "go"), "env", "GOVERSION")
+		cmd.Env = append(os.Environ(), "GOROOT="+root, "GOTOOLCHAIN=local")
+		out, err := cmd.Output()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", root, err)
+			continue
+		}
+		found = append(found, toolchain{Version: strings.TrimSpace(string(out)), GoRoot: root})
+	}
+	emit(event{Event: "toolchains", Toolchains: found})
+}
+
+// cappedBuffer collects test output and stops the tests once they print more
+// than limit bytes. It keeps one byte past the limit, so that the backend
+// can tell the limit was exceeded.
+type cappedBuffer struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	limit int
+	stop  func()
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.limit <= 0 {
+		return b.buf.Write(p)
+	}
+	if room := b.limit + 1 - b.buf.Len(); len(p) >= room {
+		b.buf.Write(p[:max(room, 0)])
+		b.stop()
+		return len(p), nil
 	}
-	
-	// Print the output
-	fmt.Print(string(output))
-	
-	// Clean up
-	os.Remove(tmpFile)
+	return b.buf.Write(p)
 }
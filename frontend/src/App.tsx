@@ -6,7 +6,8 @@ import LessonView from './components/LessonView';
 import CodeEditor from './components/CodeEditor';
 import OutputPanel from './components/OutputPanel';
 import ProgressTracker from './components/ProgressTracker';
-import type { Lesson, CodeExecutionResponse, UserProgress } from './types';
+import ExecutionHistory from './components/ExecutionHistory';
+import type { Lesson, CodeExecutionResponse, User, UserProgress } from './types';
 import { apiService } from './services/api';
 
 function App() {
@@ -17,12 +18,57 @@ function App() {
   const [isExecuting, setIsExecuting] = useState<boolean>(false);
   const [userProgress, setUserProgress] = useState<UserProgress[]>([]);
   const [loading, setLoading] = useState<boolean>(true);
+  const [historyVersion, setHistoryVersion] = useState<number>(0);
+  const [user, setUser] = useState<User | null>(null);
+  const [singleSignOn, setSingleSignOn] = useState<string | undefined>();
+  const [authError, setAuthError] = useState<string | undefined>();
 
   useEffect(() => {
     loadLessons();
-    loadUserProgress();
+    loadUser();
+    apiService.getAuthProviders().then(providers => setSingleSignOn(providers.oidc?.name));
   }, []);
 
+  const loadUser = async () => {
+    try {
+      // Single sign-on returns here with the token or an error in the fragment
+      const fragment = new URLSearchParams(window.location.hash.slice(1));
+      if (fragment.has('token') || fragment.has('error')) {
+        window.history.replaceState(null, '', window.location.pathname + window.location.search);
+        if (fragment.has('token')) {
+          await apiService.acceptToken(fragment.get('token') as string);
+          localStorage.removeItem('userProgress');
+        } else {
+          setAuthError(fragment.get('error') as string);
+        }
+      }
+
+      const current = apiService.isLoggedIn() ? await apiService.getCurrentUser() : null;
+      setUser(current);
+      await loadUserProgress(current);
+    } catch (error) {
+      console.error('Failed to load user:', error);
+      await loadUserProgress(null);
+    }
+  };
+
+  const authenticate = async (mode: 'login' | 'register', username: string, password: string) => {
+    const auth = mode === 'login'
+      ? await apiService.login(username, password)
+      : await apiService.register(username, password);
+    setUser(auth.user);
+    localStorage.removeItem('userProgress');
+    await loadUserProgress(auth.user);
+    setHistoryVersion(v => v + 1);
+  };
+
+  const logout = () => {
+    apiService.logout();
+    setUser(null);
+    setUserProgress([]);
+    setHistoryVersion(v => v + 1);
+  };
+
   const loadLessons = async () => {
     try {
       const data = await apiService.getLessons();
@@ -54,13 +100,19 @@ function App() {
     }
   };
 
-  const loadUserProgress = async () => {
+  // Progress lives on the server for logged-in users and in localStorage
+  // for anonymous ones
+  const loadUserProgress = async (current: User | null) => {
     try {
-      const userId = 'demo-user';
-      const progress = await apiService.getUserProgress(userId);
+      if (!current) {
+        throw new Error('not logged in');
+      }
+      const progress = await apiService.getUserProgress();
       setUserProgress(progress);
     } catch (error) {
-      console.error('Failed to load user progress:', error);
+      if (current) {
+        console.error('Failed to load user progress:', error);
+      }
       // Try to load from localStorage as fallback
       const savedProgress = localStorage.getItem('userProgress');
       if (savedProgress) {
@@ -84,7 +136,7 @@ function App() {
     setOutput('');
 
     try {
-      const response: CodeExecutionResponse = await apiService.executeCode(code);
+      const response: CodeExecutionResponse = await apiService.executeCode(code, currentLesson?.id);
       setOutput(response.output);
       
       if (response.error) {
@@ -97,6 +149,7 @@ function App() {
       setOutput(`Error: ${error}`);
     } finally {
       setIsExecuting(false);
+      setHistoryVersion(v => v + 1);
     }
   };
 
@@ -107,58 +160,45 @@ function App() {
     const existingProgress = userProgress.find(p => p.lesson_id === currentLesson.id);
     if (existingProgress?.completed) return;
 
-    // Enhanced completion check
-    const isCompleted = checkLessonCompletion(currentLesson, code, output);
+    try {
+      // The server grades the submission and records progress on a pass
+      const result = await apiService.submitCode({
+        lesson_id: currentLesson.id,
+        code,
+      });
+      setHistoryVersion(v => v + 1);
+      if (!result.passed) return;
+
+      // Anonymous passes are kept as tokens to merge into an account later
+      if (result.progress_token) {
+        apiService.saveProgressToken(result.progress_token);
+      }
 
-    if (isCompleted) {
       const newProgress: UserProgress = {
-        user_id: 'demo-user',
+        user_id: user?.id || '',
         lesson_id: currentLesson.id,
         completed: true,
         completed_at: new Date().toISOString(),
+        lesson_version: currentLesson.version,
       };
 
-      try {
-        await apiService.updateProgress(newProgress);
-        
-        // Update local state
-        setUserProgress(prev => {
-          const filtered = prev.filter(p => p.lesson_id !== currentLesson.id);
-          return [...filtered, newProgress];
-        });
+      // Update local state
+      setUserProgress(prev => {
+        const filtered = prev.filter(p => p.lesson_id !== currentLesson.id);
+        return [...filtered, newProgress];
+      });
 
-        // Save to localStorage for persistence
+      // Anonymous progress is kept in localStorage until the learner logs in
+      if (!user) {
         const savedProgress = JSON.parse(localStorage.getItem('userProgress') || '[]');
         const updatedProgress = savedProgress.filter((p: UserProgress) => p.lesson_id !== currentLesson.id);
         updatedProgress.push(newProgress);
         localStorage.setItem('userProgress', JSON.stringify(updatedProgress));
-
-        console.log(`🎉 Lesson "${currentLesson.title}" completed!`);
-      } catch (error) {
-        console.error('Failed to update progress:', error);
       }
-    }
-  };
 
-  const checkLessonCompletion = (lesson: Lesson, code: string, output: string): boolean => {
-    // Basic checks
-    if (!code.trim() || output.includes('Error:')) return false;
-
-    // Lesson-specific completion criteria
-    switch (lesson.id) {
-      case 1: // Hello, Go!
-        return code.includes('fmt.Println') && output.includes('Hello');
-      case 2: // Variables
-        return code.includes('var ') || code.includes(':=');
-      case 3: // Functions
-        return code.includes('func ');
-      case 4: // Loops
-        return code.includes('for ');
-      case 5: // Conditionals
-        return code.includes('if ');
-      default:
-        // Generic completion - code runs without errors and has meaningful content
-        return code.trim().length > 20 && !output.includes('Error:');
+      console.log(`🎉 Lesson "${currentLesson.title}" completed!`);
+    } catch (error) {
+      console.error('Failed to submit solution:', error);
     }
   };
 
@@ -195,7 +235,13 @@ function App() {
   return (
     <Router>
       <div className="min-h-screen bg-gray-50">
-        <Header />
+        <Header
+          user={user}
+          singleSignOn={singleSignOn}
+          authError={authError}
+          onAuthenticate={authenticate}
+          onLogout={logout}
+        />
         
         <div className="flex h-screen pt-16">
           <Sidebar 
@@ -228,7 +274,18 @@ function App() {
                   <OutputPanel 
                     output={output}
                     isExecuting={isExecuting}
-                  />
+                  >
+                    {currentLesson && user && (
+                      <ExecutionHistory
+                        lessonId={currentLesson.id}
+                        version={historyVersion}
+                        onLoad={(execution) => {
+                          setCode(execution.code || '');
+                          setOutput(execution.error ? `Error: ${execution.error}` : execution.output || '');
+                        }}
+                      />
+                    )}
+                  </OutputPanel>
                 </div>
               </div>
               
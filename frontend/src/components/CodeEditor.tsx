@@ -1,6 +1,7 @@
-import React, { useRef } from 'react';
+import React, { useEffect, useRef } from 'react';
 import Editor from '@monaco-editor/react';
 import { Play, Download, Upload } from 'lucide-react';
+import { apiService } from '../services/api';
 
 interface CodeEditorProps {
   code: string;
@@ -16,9 +17,53 @@ const CodeEditor: React.FC<CodeEditorProps> = ({
   isExecuting,
 }) => {
   const editorRef = useRef<any>(null);
+  const formatterRef = useRef<any>(null);
 
-  const handleEditorDidMount = (editor: any) => {
+  useEffect(() => () => formatterRef.current?.dispose(), []);
+
+  const handleEditorDidMount = (editor: any, monaco: any) => {
     editorRef.current = editor;
+
+    // Format with the backend on Shift+Alt+F and on Ctrl+S; code that does
+    // not parse is left as it is and its errors are marked instead, as is a
+    // formatter that cannot add missing imports
+    formatterRef.current?.dispose();
+    formatterRef.current = monaco.languages.registerDocumentFormattingEditProvider('go', {
+      provideDocumentFormattingEdits: async (model: any) => {
+        const result = await apiService.formatCode(model.getValue());
+        monaco.editor.setModelMarkers(
+          model,
+          'gofmt',
+          [
+            ...(result.diagnostics || []).map((d) => ({
+              severity: monaco.MarkerSeverity.Error,
+              message: d.message,
+              startLineNumber: d.line,
+              startColumn: d.column || 1,
+              endLineNumber: d.line,
+              endColumn: (d.column || 1) + 1,
+            })),
+            ...(result.warning
+              ? [{
+                  severity: monaco.MarkerSeverity.Warning,
+                  message: result.warning,
+                  startLineNumber: 1,
+                  startColumn: 1,
+                  endLineNumber: 1,
+                  endColumn: 2,
+                }]
+              : []),
+          ],
+        );
+        if (!result.changed || result.code === undefined) {
+          return [];
+        }
+        return [{ range: model.getFullModelRange(), text: result.code }];
+      },
+    });
+    editor.addCommand(monaco.KeyMod.CtrlCmd | monaco.KeyCode.KeyS, () => {
+      editor.getAction('editor.action.formatDocument')?.run();
+    });
     
     // Configure Go language features
     editor.updateOptions({
@@ -153,7 +198,7 @@ const CodeEditor: React.FC<CodeEditorProps> = ({
       <div className="p-3 border-t border-gray-200 bg-gray-50 text-xs text-gray-500">
         <div className="flex items-center justify-between">
           <div className="flex items-center space-x-4">
-            <span>Tip: Use Ctrl+Enter to run code</span>
+            <span>Tip: Use Ctrl+Enter to run code and Ctrl+S to format it</span>
             <span>•</span>
             <span>Auto-save enabled</span>
           </div>
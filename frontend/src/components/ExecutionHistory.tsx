@@ -0,0 +1,73 @@
+import React, { useEffect, useState } from 'react';
+import { History, CheckCircle, XCircle } from 'lucide-react';
+import type { Execution } from '../types';
+import { apiService } from '../services/api';
+
+interface ExecutionHistoryProps {
+  lessonId: number;
+  version: number;
+  onLoad: (execution: Execution) => void;
+}
+
+// ExecutionHistory lists the earlier runs and submissions of a lesson and
+// loads one back into the editor when clicked
+const ExecutionHistory: React.FC<ExecutionHistoryProps> = ({
+  lessonId,
+  version,
+  onLoad,
+}) => {
+  const [executions, setExecutions] = useState<Execution[]>([]);
+
+  useEffect(() => {
+    apiService
+      .getExecutionHistory(lessonId)
+      .then(setExecutions)
+      .catch(() => setExecutions([]));
+  }, [lessonId, version]);
+
+  const load = async (id: number) => {
+    try {
+      onLoad(await apiService.getExecution(id));
+    } catch (error) {
+      console.error('Failed to load execution:', error);
+    }
+  };
+
+  if (executions.length === 0) {
+    return null;
+  }
+
+  return (
+    <div className="border-t border-gray-200 max-h-48 overflow-y-auto">
+      <div className="flex items-center space-x-2 px-4 py-2 text-sm font-medium text-gray-700">
+        <History className="w-4 h-4" />
+        <span>History</span>
+      </div>
+      <ul>
+        {executions.map((execution) => {
+          const failed = execution.kind === 'submit' ? !execution.passed : !!execution.error;
+          return (
+            <li key={execution.id}>
+              <button
+                onClick={() => load(execution.id)}
+                className="w-full flex items-center justify-between px-4 py-1.5 text-xs text-gray-600 hover:bg-gray-100"
+              >
+                <span className="flex items-center space-x-2">
+                  {failed ? (
+                    <XCircle className="w-3 h-3 text-red-500" />
+                  ) : (
+                    <CheckCircle className="w-3 h-3 text-green-500" />
+                  )}
+                  <span>{execution.kind === 'submit' ? 'Submission' : 'Run'}</span>
+                </span>
+                <span>{new Date(execution.created_at).toLocaleString()}</span>
+              </button>
+            </li>
+          );
+        })}
+      </ul>
+    </div>
+  );
+};
+
+export default ExecutionHistory;
@@ -1,7 +1,43 @@
-import React from 'react';
-import { Play, BookOpen, Code, Trophy } from 'lucide-react';
+import React, { useEffect, useState } from 'react';
+import { Play, BookOpen, Code, Trophy, LogIn, LogOut, User as UserIcon } from 'lucide-react';
+import type { User } from '../types';
+import { apiService } from '../services/api';
+
+interface HeaderProps {
+  user: User | null;
+  singleSignOn?: string;
+  authError?: string;
+  onAuthenticate: (mode: 'login' | 'register', username: string, password: string) => Promise<void>;
+  onLogout: () => void;
+}
+
+const Header: React.FC<HeaderProps> = ({ user, singleSignOn, authError, onAuthenticate, onLogout }) => {
+  const [showForm, setShowForm] = useState<boolean>(false);
+  const [mode, setMode] = useState<'login' | 'register'>('login');
+  const [username, setUsername] = useState<string>('');
+  const [password, setPassword] = useState<string>('');
+  const [error, setError] = useState<string>('');
+
+  // A failed single sign-on reopens the login form with its error
+  useEffect(() => {
+    if (authError) {
+      setError(authError);
+      setShowForm(true);
+    }
+  }, [authError]);
+
+  const submit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError('');
+    try {
+      await onAuthenticate(mode, username, password);
+      setShowForm(false);
+      setPassword('');
+    } catch (err: any) {
+      setError(err?.response?.data?.error || 'Something went wrong');
+    }
+  };
 
-const Header: React.FC = () => {
   return (
     <header className="fixed top-0 left-0 right-0 bg-white shadow-sm border-b border-gray-200 z-50">
       <div className="max-w-7xl mx-auto px-4 sm:px-6 lg:px-8">
@@ -46,6 +82,73 @@ const Header: React.FC = () => {
               <Play className="w-4 h-4" />
               <span>Run Code</span>
             </button>
+
+            {user ? (
+              <div className="flex items-center space-x-2 text-sm text-gray-700">
+                <UserIcon className="w-4 h-4" />
+                <span>{user.username}</span>
+                {user.role !== 'learner' && (
+                  <span className="px-2 py-0.5 rounded-full bg-blue-100 text-go-blue text-xs capitalize">{user.role}</span>
+                )}
+                <button onClick={onLogout} className="p-1 text-gray-500 hover:text-go-blue" title="Log out">
+                  <LogOut className="w-4 h-4" />
+                </button>
+              </div>
+            ) : (
+              <div className="relative">
+                <button
+                  onClick={() => setShowForm(!showForm)}
+                  className="flex items-center space-x-1 text-sm text-gray-700 hover:text-go-blue"
+                >
+                  <LogIn className="w-4 h-4" />
+                  <span>Log in</span>
+                </button>
+
+                {showForm && (
+                  <form
+                    onSubmit={submit}
+                    className="absolute right-0 mt-2 w-64 bg-white border border-gray-200 rounded-lg shadow-lg p-4 space-y-3"
+                  >
+                    <input
+                      type="text"
+                      placeholder="Username"
+                      value={username}
+                      onChange={(e) => setUsername(e.target.value)}
+                      className="w-full border border-gray-300 rounded px-2 py-1 text-sm"
+                      autoComplete="username"
+                    />
+                    <input
+                      type="password"
+                      placeholder="Password"
+                      value={password}
+                      onChange={(e) => setPassword(e.target.value)}
+                      className="w-full border border-gray-300 rounded px-2 py-1 text-sm"
+                      autoComplete={mode === 'login' ? 'current-password' : 'new-password'}
+                    />
+                    {error && <p className="text-xs text-red-600">{error}</p>}
+                    <button type="submit" className="w-full bg-go-blue text-white py-1.5 rounded text-sm hover:bg-blue-600">
+                      {mode === 'login' ? 'Log in' : 'Create account'}
+                    </button>
+                    <button
+                      type="button"
+                      onClick={() => setMode(mode === 'login' ? 'register' : 'login')}
+                      className="w-full text-xs text-gray-500 hover:text-go-blue"
+                    >
+                      {mode === 'login' ? 'No account yet? Register' : 'Have an account? Log in'}
+                    </button>
+                    {singleSignOn && (
+                      <a
+                        href={apiService.singleSignOnUrl()}
+                        className="block w-full text-center border border-gray-300 py-1.5 rounded text-sm text-gray-700 hover:border-go-blue hover:text-go-blue"
+                      >
+                        Log in with {singleSignOn}
+                      </a>
+                    )}
+                    <p className="text-xs text-gray-400">Lessons you passed on this device are added to your account.</p>
+                  </form>
+                )}
+              </div>
+            )}
           </div>
         </div>
       </div>
@@ -4,9 +4,10 @@ import { Terminal, CheckCircle, XCircle, Loader } from 'lucide-react';
 interface OutputPanelProps {
   output: string;
   isExecuting: boolean;
+  children?: React.ReactNode;
 }
 
-const OutputPanel: React.FC<OutputPanelProps> = ({ output, isExecuting }) => {
+const OutputPanel: React.FC<OutputPanelProps> = ({ output, isExecuting, children }) => {
   const getStatusIcon = () => {
     if (isExecuting) {
       return <Loader className="w-4 h-4 text-blue-500 animate-spin" />;
@@ -93,6 +94,8 @@ const OutputPanel: React.FC<OutputPanelProps> = ({ output, isExecuting }) => {
         )}
       </div>
 
+      {children}
+
       {/* Output Footer */}
       <div className="p-3 border-t border-gray-200 bg-gray-50 text-xs text-gray-500">
         <div className="flex items-center justify-between">
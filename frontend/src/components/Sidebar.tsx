@@ -20,6 +20,12 @@ const Sidebar: React.FC<SidebarProps> = ({
     return progress?.completed ? 'completed' : 'locked';
   };
 
+  // Lessons edited after the learner completed them are worth a second look
+  const isUpdatedSinceCompleted = (lesson: Lesson) => {
+    const progress = userProgress?.find(p => p.lesson_id === lesson.id);
+    return (lesson.version || 1) > (progress?.lesson_version || 1);
+  };
+
   const getDifficultyColor = (difficulty: string) => {
     switch (difficulty) {
       case 'beginner':
@@ -89,6 +95,9 @@ const Sidebar: React.FC<SidebarProps> = ({
                       {status === 'completed' && (
                         <span className="ml-2 text-green-600">✓ Completed</span>
                       )}
+                      {status === 'completed' && isUpdatedSinceCompleted(lesson) && (
+                        <span className="ml-2 text-blue-600">Updated since</span>
+                      )}
                     </div>
                   </div>
                 </div>
@@ -1,5 +1,5 @@
 import axios from 'axios';
-import type { Lesson, CodeExecutionRequest, CodeExecutionResponse, UserProgress } from '../types';
+import type { AuthProviders, AuthResponse, Lesson, CodeExecutionRequest, CodeExecutionResponse, CodeSubmissionRequest, CodeSubmissionResponse, CredentialsRequest, Execution, FormatResponse, User, UserProgress } from '../types';
 
 import { config } from '../config';
 
@@ -13,6 +13,47 @@ const api = axios.create({
   },
 });
 
+const TOKEN_KEY = 'authToken';
+const PROGRESS_TOKENS_KEY = 'progressTokens';
+const PROGRESS_NONCE_KEY = 'progressNonce';
+
+// Send the logged-in user's token with every request
+api.interceptors.request.use((request) => {
+  const token = localStorage.getItem(TOKEN_KEY);
+  if (token) {
+    request.headers.Authorization = `Bearer ${token}`;
+  }
+  return request;
+});
+
+// Tokens of lessons passed before logging in, merged into the account later
+const getProgressTokens = (): string[] => {
+  try {
+    return JSON.parse(localStorage.getItem(PROGRESS_TOKENS_KEY) || '[]');
+  } catch {
+    return [];
+  }
+};
+
+// Random value of this browser, without which its progress tokens cannot be
+// merged
+const getProgressNonce = (): string => {
+  let nonce = localStorage.getItem(PROGRESS_NONCE_KEY);
+  if (!nonce) {
+    nonce = crypto.randomUUID();
+    localStorage.setItem(PROGRESS_NONCE_KEY, nonce);
+  }
+  return nonce;
+};
+
+// Log in with the result of a register or login request, which has merged
+// the anonymous progress into the account
+const authenticated = (auth: AuthResponse): AuthResponse => {
+  localStorage.setItem(TOKEN_KEY, auth.token);
+  localStorage.removeItem(PROGRESS_TOKENS_KEY);
+  return auth;
+};
+
 export const apiService = {
   // Health check
   async healthCheck(): Promise<boolean> {
@@ -47,10 +88,80 @@ export const apiService = {
     }
   },
 
+  // Create an account, adding the lessons passed anonymously
+  async register(username: string, password: string): Promise<AuthResponse> {
+    const request: CredentialsRequest = { username, password, progress_tokens: getProgressTokens(), progress_nonce: getProgressNonce() };
+    const response = await api.post('/auth/register', request);
+    return authenticated(response.data);
+  },
+
+  // Log in, adding the lessons passed anonymously
+  async login(username: string, password: string): Promise<AuthResponse> {
+    const request: CredentialsRequest = { username, password, progress_tokens: getProgressTokens(), progress_nonce: getProgressNonce() };
+    const response = await api.post('/auth/login', request);
+    return authenticated(response.data);
+  },
+
+  // Ways of logging in besides username and password
+  async getAuthProviders(): Promise<AuthProviders> {
+    try {
+      const response = await api.get('/auth/providers');
+      return response.data;
+    } catch (error) {
+      console.error('Failed to fetch login providers:', error);
+      return { password: true };
+    }
+  },
+
+  // Where the browser goes to log in with single sign-on
+  singleSignOnUrl(): string {
+    return `${API_BASE_URL}/auth/oidc/login`;
+  },
+
+  // Keep the token single sign-on returned and add the lessons passed
+  // anonymously to the account
+  async acceptToken(token: string): Promise<void> {
+    localStorage.setItem(TOKEN_KEY, token);
+    const progressTokens = getProgressTokens();
+    if (progressTokens.length > 0) {
+      await api.post('/progress/merge', { progress_tokens: progressTokens, progress_nonce: getProgressNonce() });
+      localStorage.removeItem(PROGRESS_TOKENS_KEY);
+    }
+  },
+
+  logout() {
+    localStorage.removeItem(TOKEN_KEY);
+  },
+
+  isLoggedIn(): boolean {
+    return localStorage.getItem(TOKEN_KEY) !== null;
+  },
+
+  // Get the logged-in user, logging out when the token is no longer valid
+  async getCurrentUser(): Promise<User | null> {
+    try {
+      const response = await api.get('/auth/me');
+      return response.data;
+    } catch (error) {
+      if (axios.isAxiosError(error) && error.response?.status === 401) {
+        localStorage.removeItem(TOKEN_KEY);
+        return null;
+      }
+      throw error;
+    }
+  },
+
+  // Keep the token of a lesson passed anonymously
+  saveProgressToken(token: string) {
+    const tokens = getProgressTokens();
+    tokens.push(token);
+    localStorage.setItem(PROGRESS_TOKENS_KEY, JSON.stringify(tokens));
+  },
+
   // Execute Go code
-  async executeCode(code: string): Promise<CodeExecutionResponse> {
+  async executeCode(code: string, lessonId?: number): Promise<CodeExecutionResponse> {
     try {
-      const request: CodeExecutionRequest = { code };
+      const request: CodeExecutionRequest = { code, lesson_id: lessonId };
       const response = await api.post('/execute', request);
       return response.data;
     } catch (error) {
@@ -59,10 +170,21 @@ export const apiService = {
     }
   },
 
-  // Get user progress
-  async getUserProgress(userId: string): Promise<UserProgress[]> {
+  // Format Go code with gofmt and fix its imports
+  async formatCode(code: string): Promise<FormatResponse> {
     try {
-      const response = await api.get(`/progress/${userId}`);
+      const response = await api.post('/format', { code });
+      return response.data;
+    } catch (error) {
+      console.error('Code formatting failed:', error);
+      throw error;
+    }
+  },
+
+  // Get the logged-in user's progress
+  async getUserProgress(): Promise<UserProgress[]> {
+    try {
+      const response = await api.get('/progress');
       return response.data;
     } catch (error) {
       console.error('Failed to fetch user progress:', error);
@@ -70,18 +192,46 @@ export const apiService = {
     }
   },
 
-  // Update user progress
-  async updateProgress(progress: UserProgress): Promise<void> {
+  // List the logged-in user's past runs and submissions of a lesson, newest first
+  async getExecutionHistory(lessonId: number): Promise<Execution[]> {
+    try {
+      const response = await api.get('/history', { params: { lesson_id: lessonId } });
+      return response.data;
+    } catch (error) {
+      console.error('Failed to fetch execution history:', error);
+      throw error;
+    }
+  },
+
+  // Load a past run or submission with its code
+  async getExecution(executionId: number): Promise<Execution> {
     try {
-      await api.post('/progress', progress);
+      const response = await api.get(`/history/${executionId}`);
+      return response.data;
     } catch (error) {
-      console.error('Failed to update progress:', error);
+      console.error(`Failed to fetch execution ${executionId}:`, error);
       throw error;
     }
   },
 
-  // WebSocket connection for real-time features
-  createWebSocketConnection(): WebSocket {
-    return new WebSocket(config.WS_URL);
+  // Submit a lesson solution for grading
+  async submitCode(request: CodeSubmissionRequest): Promise<CodeSubmissionResponse> {
+    try {
+      const response = await api.post('/submit', { ...request, progress_nonce: getProgressNonce() });
+      return response.data;
+    } catch (error) {
+      console.error('Failed to submit code:', error);
+      throw error;
+    }
+  },
+
+  // WebSocket connection for real-time features; browsers cannot set its
+  // headers, so a one-time ticket goes in the URL instead of the token
+  async createWebSocketConnection(): Promise<WebSocket> {
+    if (!localStorage.getItem(TOKEN_KEY)) {
+      return new WebSocket(config.WS_URL);
+    }
+    const response = await api.post('/ws/ticket');
+    return new WebSocket(`${config.WS_URL}?ticket=${encodeURIComponent(response.data.ticket)}`);
   },
 };
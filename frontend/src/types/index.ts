@@ -9,15 +9,95 @@ export interface Lesson {
   solution: string;
   difficulty: 'beginner' | 'intermediate' | 'advanced';
   order: number;
+  version?: number;
+  retired_at?: string;
 }
 
 export interface CodeExecutionRequest {
   code: string;
+  lesson_id?: number;
 }
 
 export interface CodeExecutionResponse {
   output: string;
   error?: string;
+  execution_id?: number;
+}
+
+export interface CodeSubmissionRequest {
+  lesson_id: number;
+  code: string;
+  progress_nonce?: string;
+}
+
+export interface CodeSubmissionResponse {
+  passed: boolean;
+  output: string;
+  expected: string;
+  diff?: string;
+  error?: string;
+  execution_id?: number;
+  progress_token?: string;
+}
+
+export interface Diagnostic {
+  file: string;
+  line: number;
+  column?: number;
+  message: string;
+}
+
+export interface FormatResponse {
+  code?: string;
+  changed: boolean;
+  warning?: string;
+  error?: string;
+  diagnostics?: Diagnostic[];
+}
+
+export interface Execution {
+  id: number;
+  user_id: string;
+  lesson_id: number;
+  kind: 'run' | 'submit';
+  code?: string;
+  files?: Record<string, string>;
+  stdin?: string;
+  go_version?: string;
+  output?: string;
+  error?: string;
+  exit_code?: number;
+  passed?: boolean;
+  duration_ms: number;
+  created_at: string;
+}
+
+export type Role = 'learner' | 'instructor' | 'admin';
+
+export interface User {
+  id: string;
+  username: string;
+  role: Role;
+  created_at: string;
+}
+
+export interface CredentialsRequest {
+  username: string;
+  password: string;
+  progress_tokens?: string[];
+  progress_nonce?: string;
+}
+
+export interface AuthResponse {
+  token: string;
+  expires_at: string;
+  user: User;
+  merged: number;
+}
+
+export interface AuthProviders {
+  password: boolean;
+  oidc?: { name: string };
 }
 
 export interface UserProgress {
@@ -25,6 +105,7 @@ export interface UserProgress {
   lesson_id: number;
   completed: boolean;
   completed_at?: string;
+  lesson_version?: number;
 }
 
 export interface ApiResponse<T> {